@@ -0,0 +1,63 @@
+package extract
+
+import (
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/export"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
+	"github.com/spf13/cobra"
+)
+
+// ExportCmd represents the export command, which converts GTFS data to formats for analysis.
+// Its subcommands extract the feed first, so they take the same filtering options as extract.
+var ExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export GTFS data to other formats, with the same filtering options as extract",
+}
+
+var exportSQLiteCmd = &cobra.Command{
+	Use:     "sqlite [flags]... input-gtfs output-db",
+	Example: `  gtfs-tool export sqlite --keep-routes 6 --exclude-shapes feed.zip lpp6.db`,
+	Short:   "Export GTFS data to a SQLite database",
+	Long: `Loads every file of the extracted feed into a table named after the file. Columns are typed
+as in the GTFS reference (dates and times are text), and tables have primary keys, foreign keys
+and indexes on ID columns. Empty values are NULL. Files not in the reference get text columns.
+Rows with the same primary key are an error, use --dedupe keep-first or --dedupe keep-last to keep one row per key.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runExtractorTo(args[0], args[1], export.CreateSQLiteSink)
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		_params = newExtractParams()
+		return newExtractor()
+	},
+
+	Args: cobra.ExactArgs(2),
+}
+
+var exportParquetCmd = &cobra.Command{
+	Use:     "parquet [flags]... input-gtfs output-dir",
+	Example: `  gtfs-tool export parquet --exclude-shapes feed.zip warehouse/lpp/`,
+	Short:   "Export GTFS data to a directory of Parquet files",
+	Long: `Writes every file of the extracted feed to a Parquet file named after the file, like stop_times.parquet.
+Columns are typed as in the GTFS reference: integers, doubles, dates as DATE, and times and other values
+as dictionary encoded strings. Empty values are null. Files not in the reference get string columns.
+A value that doesn't match its column type, like 1.0 in an integer column, is written as null and
+its row is reported like a malformed row, see --rejects. With --strict it is an error instead.
+Rows are written in row groups, so only one row group is held in memory at a time.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runExtractorTo(args[0], args[1], func(path string) (gtfsio.Sink, error) {
+			return export.CreateParquetSink(path, _strict)
+		})
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		_params = newExtractParams()
+		return newExtractor()
+	},
+
+	Args: cobra.ExactArgs(2),
+}
+
+func init() {
+	addExtractFlags(exportSQLiteCmd)
+	addExtractFlags(exportParquetCmd)
+	ExportCmd.AddCommand(exportSQLiteCmd)
+	ExportCmd.AddCommand(exportParquetCmd)
+}
@@ -1,95 +1,254 @@
 package extract
 
 import (
-	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"maps"
 	"os"
+	"path/filepath"
 	"slices"
+	"strconv"
 
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/file"
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/columnorder"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/export"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
 	"github.com/spf13/cobra"
+	"golang.org/x/text/encoding"
 )
 
 var _params *params.ExtractParams
 var _extractor *extract.Extractor
 
+// nil to detect the encoding of every input file
+var _encoding encoding.Encoding
+
 // ExtractCmd represents the extract command, which allows users to extract subsets of GTFS data
 // based on various filtering options such as included/excluded files and fields.
 var ExtractCmd = &cobra.Command{
-	Use:   "extract [flags]... input-gtfs output-gtfs",
+	Use: "extract [flags]... input-gtfs output-gtfs",
+	Example: `  gtfs-tool extract --exclude-shapes feed.zip out.zip
+  gtfs-tool extract --where 'routes.txt,route_type=3' feed/ out/
+  curl -s https://example.com/gtfs.zip | gtfs-tool extract --exclude-shapes - - > out.zip
+  gtfs-tool extract --dry-run --format json --exclude-empty-fields feed.zip`,
 	Short: "Extract a subset of GTFS data, with various filtering options",
 	Long:  `TODO: long description`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		in := args[0]
-		out := args[1]
-
-		zipReader, err := zip.OpenReader(in)
-		if err != nil {
-			return err
+		if _dry_run {
+			out := ""
+			if len(args) == 2 {
+				out = args[1]
+			}
+			return planExtractor(args[0], out)
 		}
-		defer zipReader.Close()
-
-		writeFile, err := os.Create(out)
-		if err != nil {
-			return err
+		if len(args) != 2 {
+			return fmt.Errorf("accepts 2 arg(s), received %d", len(args))
 		}
-		zipWriter := zip.NewWriter(writeFile)
-		defer writeFile.Close()
-		defer zipWriter.Close()
-
-		return _extractor.Extract(&zipReader.Reader, zipWriter)
+		return runExtractor(args[0], args[1])
 	},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		uniquly_combine := func(a []string, b []string) []string {
-			m := make(map[string]any, len(a)+len(b))
-			for _, v := range a {
-				m[v] = nil
-			}
-			for _, v := range b {
-				m[v] = nil
-			}
-			return slices.Collect(maps.Keys(m))
-		}
-		exclude_files := uniquly_combine(_exclude_files_individual, _exclude_files_sliced)
-		include_files := uniquly_combine(_include_files_individual, _include_files_sliced)
-
-		_params = params.NewExtractParams(
-			exclude_files,
-			include_files,
-			_exclude_emptyfiles,
-			_exclude_emptyfields,
-			_exclude_shapes,
-			_exclude_fields,
-			_include_fields,
-		)
-		err := _params.ParseAndValidate()
-		if err != nil {
-			return err
+		if _format != formatText && _format != formatJSON {
+			return fmt.Errorf("invalid format %q, must be %s or %s", _format, formatText, formatJSON)
 		}
+		_params = newExtractParams()
+		return newExtractor()
+	},
 
-		verbosity := logging.NoStatus
+	// The output feed is optional with --dry-run
+	Args: cobra.RangeArgs(1, 2),
+}
 
-		if _verboseverbose {
-			verbosity = logging.EvenMoreVerbose
-		} else if _verbose {
-			verbosity = logging.Verbose
+// newExtractParams creates the extract params from the filter flags shared by extract and export.
+func newExtractParams() *params.ExtractParams {
+	uniquly_combine := func(a []string, b []string) []string {
+		m := make(map[string]any, len(a)+len(b))
+		for _, v := range a {
+			m[v] = nil
+		}
+		for _, v := range b {
+			m[v] = nil
 		}
+		return slices.Collect(maps.Keys(m))
+	}
+	exclude_files := uniquly_combine(_exclude_files_individual, _exclude_files_sliced)
+	include_files := uniquly_combine(_include_files_individual, _include_files_sliced)
+
+	return params.NewExtractParams(
+		exclude_files,
+		include_files,
+		_exclude_emptyfiles,
+		_exclude_emptyfields,
+		_exclude_shapes,
+		_exclude_fields,
+		_include_fields,
+	).WithRenamedFields(_rename_fields).
+		WithTransforms(_transforms).
+		WithWhere(_where).
+		WithKeep(_keep_agencies, _keep_routes, _keep_trips).
+		WithDateWindow(_from_date, _to_date).
+		WithArea(_bbox, _polygon, _clip_trips).
+		WithSample(_sample_trips, _seed, _sample_per_route).
+		WithPruneOrphans(_prune_orphans).
+		WithShapeSimplification(_simplify_shapes, _shape_precision).
+		WithJobs(_jobs).
+		WithMemoryLimit(_memory_limit).
+		WithStrict(_strict).
+		WithDedupe(params.DedupePolicy(_dedupe)).
+		WithSort(_sorts, _sort_canonical).
+		WithColumnOrder(_column_order, _custom_column_order).
+		WithMinimal(_minimal).
+		WithAllowInvalid(_allow_invalid)
+}
+
+// newExtractor validates _params and creates _extractor from them.
+func newExtractor() error {
+	err := _params.ParseAndValidate()
+	if err != nil {
+		return err
+	}
+	if _encoding, err = gtfsio.LookupEncoding(_input_encoding); err != nil {
+		return err
+	}
+
+	verbosity := logging.NoStatus
+
+	if _verboseverbose {
+		verbosity = logging.EvenMoreVerbose
+	} else if _verbose {
+		verbosity = logging.Verbose
+	}
+
+	_extractor = extract.NewExtractor(_params, reporter, verbosity)
+	return nil
+}
+
+// runExtractor runs _extractor on the input feed and writes the result to the output feed.
+// Both can be either a zip archive or a directory.
+func runExtractor(in, out string) error {
+	return runExtractorTo(in, out, gtfsio.CreateSink)
+}
 
-		_extractor = extract.NewExtractor(_params, reporter, verbosity)
+// runExtractorTo runs _extractor on the input feed and writes the result to out with the sink created by createSink.
+// The output is created next to out under a temporary name and renamed to out once the extraction succeeded,
+// so a failed run doesn't leave a partial output. Existing directories and the standard output are written in place.
+func runExtractorTo(in, out string, createSink func(path string) (gtfsio.Sink, error)) error {
+	source, err := gtfsio.OpenSource(in)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	source = gtfsio.DecodeSource(source, _encoding)
+
+	path := out
+	if out != gtfsio.Stdio && !gtfsio.IsDirPath(out) {
+		tmp, err := os.MkdirTemp(filepath.Dir(out), "."+filepath.Base(out)+".tmp-*")
 		if err != nil {
 			return err
 		}
+		defer os.RemoveAll(tmp)
+		path = filepath.Join(tmp, filepath.Base(out))
+	}
+
+	sink, err := createSink(path)
+	if err != nil {
+		return err
+	}
+	if err := _extractor.Extract(source, sink); err != nil {
+		sink.Close()
+		return err
+	}
+	reportWarnings(_extractor.Warnings())
+	if err := sink.Close(); err != nil {
+		return err
+	}
+	if path != out {
+		if err := os.Rename(path, out); err != nil {
+			return err
+		}
+	}
+	rejects := _extractor.Rejects()
+	// Rows with values an export sink couldn't convert to the type of their column
+	if s, ok := sink.(interface{ Rejects() []export.Reject }); ok {
+		for _, r := range s.Rejects() {
+			rejects = append(rejects, file.Reject{File: r.File, Line: r.Line, Content: r.Content, Reason: r.Reason})
+		}
+	}
+	return reportRejects(rejects)
+}
+
+// reportWarnings lists the removed files and fields that may make the output invalid GTFS on stderr.
+func reportWarnings(warnings []string) {
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: output may not be valid GTFS, it is missing the %s\n", w)
+	}
+}
+
+// reportRejects writes the malformed rows skipped by the extraction to the --rejects file,
+// or lists them on stderr if it isn't given.
+func reportRejects(rejects []file.Reject) error {
+	if _rejects == "" {
+		for _, r := range rejects {
+			fmt.Fprintf(os.Stderr, "skipped malformed row %s:%d (%s): %s\n", r.File, r.Line, r.Reason, r.Content)
+		}
 		return nil
-	},
+	}
 
-	Args: cobra.ExactArgs(2),
+	out, err := os.Create(_rejects)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := csv.NewWriter(out)
+	w.Write([]string{"file", "line", "reason", "content"})
+	for _, r := range rejects {
+		w.Write([]string{r.File, strconv.Itoa(r.Line), r.Reason, r.Content})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if len(rejects) > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d malformed rows, see %s\n", len(rejects), _rejects)
+	}
+	return out.Close()
 }
 
+// Output formats of --dry-run
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// planExtractor runs _extractor on the input feed without writing the output feed, and prints the plan
+// to stdout. The output size is estimated for a zip archive, unless out is a directory.
+func planExtractor(in, out string) error {
+	source, err := gtfsio.OpenSource(in)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	source = gtfsio.DecodeSource(source, _encoding)
+
+	plan, err := _extractor.Plan(source, out == "" || !gtfsio.IsDirPath(out))
+	if err != nil {
+		return err
+	}
+	reportWarnings(_extractor.Warnings())
+	if _format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+	return plan.WriteText(os.Stdout)
+}
+
+// reporter writes to stderr, so status doesn't mix with a feed written to stdout
 var reporter logging.LogConsumer = func(status string, level logging.StatusLevel) {
-	fmt.Println(status)
+	fmt.Fprintln(os.Stderr, status)
 }
 
 var (
@@ -99,6 +258,37 @@ var (
 	_include_files_sliced     []string
 	_exclude_fields           []string
 	_include_fields           []string
+	_rename_fields            []string
+	_transforms               []string
+	_where                    []string
+	_keep_agencies            []string
+	_keep_routes              []string
+	_keep_trips               []string
+	_from_date                string
+	_to_date                  string
+	_bbox                     string
+	_polygon                  string
+	_clip_trips               bool
+	_sample_trips             int
+	_seed                     int64
+	_sample_per_route         bool
+	_prune_orphans            bool
+	_simplify_shapes          float64
+	_shape_precision          int
+	_jobs                     int
+	_memory_limit             string
+	_input_encoding           string
+	_strict                   bool
+	_dedupe                   string
+	_sorts                    []string
+	_sort_canonical           bool
+	_column_order             string
+	_custom_column_order      []string
+	_rejects                  string
+	_minimal                  bool
+	_allow_invalid            bool
+	_dry_run                  bool
+	_format                   string
 	_exclude_emptyfiles       bool
 	_exclude_emptyfields      bool
 	_exclude_shapes           bool
@@ -107,22 +297,69 @@ var (
 )
 
 func init() {
+	addExtractFlags(ExtractCmd)
 	fl := ExtractCmd.Flags()
+	fl.BoolVar(&_dry_run, "dry-run", false, "Print what would be extracted instead of writing the output feed, which is then optional")
+	fl.StringVar(&_format, "format", formatText, "Format of the --dry-run output, text or json")
+}
+
+// addInputFlags adds the flags about reading the input feed to cmd.
+func addInputFlags(cmd *cobra.Command) {
+	fl := cmd.Flags()
+	fl.StringVar(&_input_encoding, "input-encoding", gtfsio.AutoEncoding,
+		"Encoding of the input files, like utf-8, utf-16, windows-1250 or iso-8859-2; auto detects it for every file from the BOM or the first 64 KiB, and fails on invalid UTF-8 later in a file detected as UTF-8. The output is always UTF-8 without BOM")
+	fl.BoolVar(&_strict, "strict", false, "Fail on malformed rows, like rows with a wrong number of fields or stray quotes, instead of skipping them; export parquet also fails on values not matching their column type instead of writing them as null")
+	fl.StringVar(&_rejects, "rejects", "", "Write the skipped malformed rows to this CSV file (file, line, reason, content) instead of listing them on stderr")
+}
+
+// addExtractFlags adds the flags selecting and transforming what is extracted to cmd.
+func addExtractFlags(cmd *cobra.Command) {
+	fl := cmd.Flags()
 
-	fl.StringArrayVar(&_exclude_files_individual, "exclude-file", []string{}, "Individual file to exclude (can be specified multiple times)")
-	fl.StringArrayVar(&_include_files_individual, "include-file", []string{}, "Individual file to include (can be specified multiple times)")
-	fl.StringSliceVar(&_exclude_files_sliced, "exclude-files", []string{}, "Files to exclude, separated by commas")
-	fl.StringSliceVar(&_include_files_sliced, "include-files", []string{}, "Files to include, separated by commas")
-	fl.StringArrayVar(&_exclude_fields, "exclude-fields", []string{}, "Fields to exclude (format: filename,fieldnames,...)")
-	fl.StringArrayVar(&_include_fields, "include-fields", []string{}, "Fields to include (format: filename,fieldnames,...)")
+	fl.StringArrayVar(&_exclude_files_individual, "exclude-file", []string{}, "Individual file or file pattern to exclude, like fare_*.txt (can be specified multiple times)")
+	fl.StringArrayVar(&_include_files_individual, "include-file", []string{}, "Individual file or file pattern to include, like fare_*.txt (can be specified multiple times)")
+	fl.StringSliceVar(&_exclude_files_sliced, "exclude-files", []string{}, "Files or file patterns to exclude, separated by commas")
+	fl.StringSliceVar(&_include_files_sliced, "include-files", []string{}, "Files or file patterns to include, separated by commas")
+	fl.StringArrayVar(&_exclude_fields, "exclude-fields", []string{}, "Fields to exclude (format: filename,fieldnames,...); names can be globs or /regexps/, e.g. *,*_desc (*,*_url removes the required agency_url, so it needs --allow-invalid)")
+	fl.StringArrayVar(&_include_fields, "include-fields", []string{}, "Fields to include (format: filename,fieldnames,...); names can be globs or /regexps/, e.g. stops.txt,stop_*")
+	fl.StringArrayVar(&_rename_fields, "rename-fields", []string{}, "Fields to rename (format: filename,old=new,...); all other options refer to the new names")
+	fl.StringArrayVar(&_transforms, "transform", []string{}, "Transform values of a field (format: filename,field,operation[,argument]; operations: trim, upper, lower, replace,/regex/replacement/, default,value, set,value, null)")
+	fl.StringArrayVar(&_where, "where", []string{}, "Keep only rows matching the expression (format: filename,expression; e.g. routes.txt,route_type=3)")
+	fl.StringSliceVar(&_keep_agencies, "keep-agencies", []string{}, "Keep only these agencies and everything they reference, separated by commas")
+	fl.StringSliceVar(&_keep_routes, "keep-routes", []string{}, "Keep only these routes and everything they reference, separated by commas")
+	fl.StringSliceVar(&_keep_trips, "keep-trips", []string{}, "Keep only these trips and everything they reference, separated by commas")
+	fl.StringVar(&_from_date, "from-date", "", "Keep only service on or after this date (format: YYYYMMDD)")
+	fl.StringVar(&_to_date, "to-date", "", "Keep only service on or before this date (format: YYYYMMDD)")
+	fl.StringVar(&_bbox, "bbox", "", "Keep only trips visiting stops inside the bounding box (format: minlon,minlat,maxlon,maxlat)")
+	fl.StringVar(&_polygon, "polygon", "", "Keep only trips visiting stops inside the polygons of the GeoJSON file")
+	fl.BoolVar(&_clip_trips, "clip-trips", false, "Truncate trips to their part inside the bbox or polygon")
+	fl.IntVar(&_sample_trips, "sample-trips", 0, "Keep only this many trips, chosen at random, and everything they reference; the same --seed gives the same trips")
+	fl.Int64Var(&_seed, "seed", 0, "Seed of the random choice of --sample-trips")
+	fl.BoolVar(&_sample_per_route, "sample-per-route", false, "Keep the first --sample-trips trips of every route instead of random trips")
+	fl.BoolVar(&_prune_orphans, "prune-orphans", false, "Remove entities that are no longer referenced, like stops no trip visits or unused shapes")
+	fl.Float64Var(&_simplify_shapes, "simplify-shapes", 0, "Simplify shapes so no removed point is further than the given number of meters from the simplified shape; kept points keep their shape_dist_traveled, distances are not recomputed or added if missing")
+	fl.IntVar(&_shape_precision, "shape-precision", 0, "Round shape coordinates to the given number of decimal places (6 is about 10 cm)")
+	fl.IntVarP(&_jobs, "jobs", "j", 1, "Number of files to extract concurrently, 0 for the number of CPUs")
+	fl.StringVar(&_memory_limit, "memory-limit", "256MiB", "Rows of a file buffered in memory by --exclude-empty-fields before they are spilled to a compressed temporary file (e.g. 64MiB, 1GiB)")
+	fl.StringVar(&_dedupe, "dedupe", "", "Remove exact duplicate rows, and of rows with the same primary key (like stop_id in stops.txt) keep-first, keep-last or fail")
+	fl.StringArrayVar(&_sorts, "sort", []string{}, "Sort the rows of a file by fields (format: filename,field1,field2,...), more sorts of a file add fields; numeric GTFS fields like stop_sequence are compared as numbers, and files larger than --memory-limit are sorted on disk")
+	fl.BoolVar(&_sort_canonical, "sort-canonical", false, "Sort stop_times.txt by trip_id and stop_sequence, shapes.txt by shape_id and shape_pt_sequence and calendar_dates.txt by service_id and date, unless --sort gives other fields for them")
+	fl.StringVar(&_column_order, "column-order", columnorder.Input, "Order of the output columns: input, canonical (as in the GTFS reference, unknown columns last) or custom")
+	fl.StringArrayVar(&_custom_column_order, "custom-column-order", []string{}, "Column order of a file for --column-order custom (format: filename,field1,field2,...); other columns follow in input order")
+	fl.BoolVar(&_minimal, "minimal", false, "Keep only the files and fields required by the GTFS reference (agency, stops, routes, trips, stop_times, calendar, calendar_dates and feed_info) with location_type, which parent_station depends on, and the ones given with --include-files and --include-fields")
+	fl.BoolVar(&_allow_invalid, "allow-invalid", false, "Allow removing or renaming files and fields required by the GTFS reference, like stop_times.txt or trip_id, with only a warning")
+	addInputFlags(cmd)
 	fl.BoolVar(&_exclude_emptyfiles, "exclude-empty-files", false, "Exclude empty files")
 	fl.BoolVar(&_exclude_emptyfields, "exclude-empty-fields", false, "Exclude empty fields")
 	fl.BoolVar(&_exclude_shapes, "exclude-shapes", false, "Exclude shapes")
 	// fl.BoolVarP(&_verbose, "verbose", "v", false, "Enable verbose output")
 	// fl.BoolVar(&_verboseverbose, "verboseverbose", false, "Enable very verbose output")
 
-	ExtractCmd.MarkFlagsMutuallyExclusive("exclude-file", "include-file")
-	ExtractCmd.MarkFlagsMutuallyExclusive("exclude-files", "include-files")
-	ExtractCmd.MarkFlagsMutuallyExclusive("exclude-file", "include-files")
-	ExtractCmd.MarkFlagsMutuallyExclusive("include-file", "exclude-files")
+	cmd.MarkFlagsMutuallyExclusive("exclude-file", "include-file")
+	cmd.MarkFlagsMutuallyExclusive("exclude-files", "include-files")
+	cmd.MarkFlagsMutuallyExclusive("exclude-file", "include-files")
+	cmd.MarkFlagsMutuallyExclusive("include-file", "exclude-files")
+	cmd.MarkFlagsMutuallyExclusive("bbox", "polygon")
+	cmd.MarkFlagsMutuallyExclusive("minimal", "exclude-file")
+	cmd.MarkFlagsMutuallyExclusive("minimal", "exclude-files")
 }
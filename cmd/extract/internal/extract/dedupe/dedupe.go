@@ -0,0 +1,150 @@
+package dedupe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/schema"
+)
+
+var ErrDuplicateKey = errors.New("duplicate primary key")
+
+// Apply finds the duplicate rows of every file of the feed and registers row processors removing them.
+func Apply(f *feed.Feed, policy params.DedupePolicy, log logging.LogReporter) error {
+	for _, fileName := range f.Names() {
+		header, err := f.Header(fileName)
+		if err != nil {
+			return err
+		}
+		var keyIndices []int
+		if file, ok := schema.Lookup(fileName); ok {
+			keyIndices = indices(header, file.PrimaryKey)
+		}
+
+		removed, duplicateRows, err := scan(f, fileName, header, keyIndices, policy)
+		if err != nil {
+			return err
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		f.AddProcessors(fileName, removeRows(removed))
+		log(logging.Verbose, "Removing %d duplicate rows of file %s, %d of them exact duplicates",
+			len(removed), fileName, duplicateRows)
+	}
+	return nil
+}
+
+// indices returns the indices of the fields in the header, or nil if any of them is missing.
+func indices(header, fields []string) []int {
+	if len(fields) == 0 {
+		return nil
+	}
+	result := make([]int, len(fields))
+	for i, field := range fields {
+		result[i] = slices.Index(header, field)
+		if result[i] < 0 {
+			return nil
+		}
+	}
+	return result
+}
+
+// scan returns the positions of the rows of a file to remove, among the rows read through the feed,
+// and how many of them are exact duplicates.
+func scan(f *feed.Feed, fileName string, header []string, keyIndices []int, policy params.DedupePolicy) (map[int]struct{}, int, error) {
+	removed := map[int]struct{}{}
+	duplicateRows := 0
+	// Hashes of the rows, to save memory, since most files have no key or a key that isn't the whole row
+	seenRows := map[[16]byte]struct{}{}
+	// key -> position of the row kept for it so far
+	seenKeys := map[string]int{}
+
+	position := 0
+	err := f.ReadTable(fileName, func(row feed.Row) error {
+		defer func() { position++ }()
+		values := row.Values()
+		hash := hashRow(values)
+		if _, ok := seenRows[hash]; ok {
+			removed[position] = struct{}{}
+			duplicateRows++
+			return nil
+		}
+		seenRows[hash] = struct{}{}
+		if keyIndices == nil {
+			return nil
+		}
+
+		key := rowKey(values, keyIndices)
+		kept, ok := seenKeys[key]
+		switch {
+		case !ok:
+			seenKeys[key] = position
+		case policy == params.DedupeFail:
+			return fmt.Errorf("%w %s in file %s", ErrDuplicateKey, formatKey(header, keyIndices, values), fileName)
+		case policy == params.DedupeKeepLast:
+			removed[kept] = struct{}{}
+			seenKeys[key] = position
+		default:
+			removed[position] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return removed, duplicateRows, nil
+}
+
+// removeRows returns a processor removing the rows at the positions, counting from zero. The processor
+// must be registered right after scanning, so it sees the same rows as the scan.
+func removeRows(positions map[int]struct{}) rows.Processor {
+	return rows.ProcessorFunc(func(header []string) (rows.Func, error) {
+		// Every read counts from the start again
+		position := -1
+		return func(record []string) (bool, error) {
+			position++
+			_, remove := positions[position]
+			return !remove, nil
+		}, nil
+	})
+}
+
+// hashRow returns a 128 bit hash of the values. Lengths are hashed too, so values can't run into each other.
+func hashRow(values []string) [16]byte {
+	h := fnv.New128a()
+	var length [8]byte
+	for _, value := range values {
+		binary.LittleEndian.PutUint64(length[:], uint64(len(value)))
+		h.Write(length[:])
+		h.Write([]byte(value))
+	}
+	var sum [16]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func rowKey(values []string, keyIndices []int) string {
+	parts := make([]string, len(keyIndices))
+	for i, index := range keyIndices {
+		parts[i] = values[index]
+	}
+	// GTFS values can't contain NUL
+	return strings.Join(parts, "\x00")
+}
+
+func formatKey(header []string, keyIndices []int, values []string) string {
+	parts := make([]string, len(keyIndices))
+	for i, index := range keyIndices {
+		parts[i] = header[index] + "=" + values[index]
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,91 @@
+package dedupe_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/dedupe"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed/feedtest"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+var testFeed = map[string]string{
+	"stops.txt": "stop_id,stop_name\n" +
+		"P1,Konzorcij\n" +
+		"P2,Bavarski dvor\n" +
+		"P1,Konzorcij\n" +
+		"P2,Bavarski dvor (nov)\n",
+	"stop_times.txt": "trip_id,stop_id,stop_sequence\n" +
+		"T1,P1,1\n" +
+		"T1,P2,2\n" +
+		"T1,P3,2\n",
+	// No primary key, only exact duplicates are removed
+	"transfers.txt": "from_stop_id,to_stop_id,transfer_type\n" +
+		"P1,P2,0\n" +
+		"P1,P2,0\n" +
+		"P1,P2,1\n",
+	"lpp_extra.txt": "key,value\na,1\na,1\n",
+}
+
+func TestApply(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	tests := []struct {
+		name          string
+		policy        params.DedupePolicy
+		wantStops     []string
+		wantStopTimes []string
+	}{
+		{
+			name:          "keep first",
+			policy:        params.DedupeKeepFirst,
+			wantStops:     []string{"P1,Konzorcij", "P2,Bavarski dvor"},
+			wantStopTimes: []string{"T1,P1,1", "T1,P2,2"},
+		},
+		{
+			name:          "keep last",
+			policy:        params.DedupeKeepLast,
+			wantStops:     []string{"P1,Konzorcij", "P2,Bavarski dvor (nov)"},
+			wantStopTimes: []string{"T1,P1,1", "T1,P3,2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := feedtest.NewFeed(t, testFeed, nil)
+			if err := dedupe.Apply(f, tt.policy, reporter); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if got := feedtest.Rows(t, f, "stops.txt"); !slices.Equal(got, tt.wantStops) {
+				t.Errorf("stops.txt = %v, want %v", got, tt.wantStops)
+			}
+			if got := feedtest.Rows(t, f, "stop_times.txt"); !slices.Equal(got, tt.wantStopTimes) {
+				t.Errorf("stop_times.txt = %v, want %v", got, tt.wantStopTimes)
+			}
+			if got, want := feedtest.Rows(t, f, "transfers.txt"), []string{"P1,P2,0", "P1,P2,1"}; !slices.Equal(got, want) {
+				t.Errorf("transfers.txt = %v, want %v", got, want)
+			}
+			if got, want := feedtest.Rows(t, f, "lpp_extra.txt"), []string{"a,1"}; !slices.Equal(got, want) {
+				t.Errorf("lpp_extra.txt = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApply_Fail(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+
+	// Exact duplicates are removed without failing
+	f := feedtest.NewFeed(t, map[string]string{"stops.txt": "stop_id,stop_name\nP1,Konzorcij\nP1,Konzorcij\n"}, nil)
+	if err := dedupe.Apply(f, params.DedupeFail, reporter); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+	if got, want := feedtest.Rows(t, f, "stops.txt"), []string{"P1,Konzorcij"}; !slices.Equal(got, want) {
+		t.Errorf("stops.txt = %v, want %v", got, want)
+	}
+
+	f = feedtest.NewFeed(t, testFeed, nil)
+	if err := dedupe.Apply(f, params.DedupeFail, reporter); !errors.Is(err, dedupe.ErrDuplicateKey) {
+		t.Errorf("Apply() error = %v, want %v", err, dedupe.ErrDuplicateKey)
+	}
+}
@@ -0,0 +1,5 @@
+// Package dedupe removes duplicate rows from a GTFS feed.
+// Rows that are exact duplicates of another row of the same file are always removed. Of the rows of a
+// file with the same primary key, like two stops.txt rows with the same stop_id, the first or the last
+// is kept, or they are an error, depending on the policy.
+package dedupe
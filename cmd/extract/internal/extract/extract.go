@@ -1,14 +1,29 @@
 package extract
 
 import (
-	"archive/zip"
+	"cmp"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"slices"
+	"strings"
+	"sync"
 
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/dedupe"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/file"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/geo"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/prune"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/sample"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/shapes"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/subset"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/window"
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/pattern"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/schema"
 )
 
 // Extractor is responsible for processing GTFS data based on the provided parameters.
@@ -17,6 +32,12 @@ type Extractor struct {
 	params      *params.ExtractParams
 	status      logging.LogConsumer
 	reportLevel logging.StatusLevel
+
+	// malformed rows skipped by the last extraction, guarded by rejectsMu since files can be extracted concurrently
+	rejects   []file.Reject
+	rejectsMu sync.Mutex
+	// removed files and fields that may make the output of the last extraction invalid GTFS
+	warnings []string
 }
 
 // NewExtractor creates a new Extractor instance with the given parameters, status consumer, and report level.
@@ -46,21 +67,35 @@ func (e *Extractor) report(level logging.StatusLevel, format string, a ...any) {
 	}
 }
 
-// Extract processes the input zip archive, filtering and transforming the data according to the parameters,
-// and writes the result to the output zip archive.
-func (e *Extractor) Extract(zipReader *zip.Reader, zipWriter *zip.Writer) error {
+// Extract processes the input feed, filtering and transforming the data according to the parameters,
+// and writes the result to the output feed.
+func (e *Extractor) Extract(source gtfsio.Source, sink gtfsio.Sink) error {
 	if !e.params.IsParsedAndValid() {
 		return fmt.Errorf("extract parameters are not parsed or valid")
 	}
 
 	params := e.params
 	statusReporter := e.report
+	e.rejects = nil
+	e.warnings = nil
+
+	// Must be created before filtering, since passes need to read files that might not be in the output
+	inputFeed := feed.New(source.Files(), params)
+	// Checked before the passes read any file. The headers are read with the renames applied, as the file extractors
+	// apply them before the row processors and the field mapping, so a colliding rename fails here and the
+	// required fields are checked under their new names
+	if err := e.checkRequired(source, inputFeed); err != nil {
+		return err
+	}
+	if err := e.runPasses(inputFeed); err != nil {
+		return err
+	}
 
 	var filter []string
-	var filteredFiles []*zip.File
+	var filteredFiles []gtfsio.File
 	if len(params.IncludedFiles()) == 0 && len(params.ExcludedFiles()) == 0 {
 		// Edge case for reporting
-		filteredFiles = zipReader.File
+		filteredFiles = source.Files()
 		statusReporter(logging.Verbose, "No file inclusion or exclusion specified, including all files")
 	} else {
 		include := len(params.IncludedFiles()) > 0
@@ -71,27 +106,174 @@ func (e *Extractor) Extract(zipReader *zip.Reader, zipWriter *zip.Writer) error
 			filter = params.ExcludedFiles()
 			statusReporter(logging.Verbose, "Excluding files: %v\n", filter)
 		}
-		filteredFiles = filterFiles(zipReader.File, filter, include)
+		filteredFiles = filterFiles(source.Files(), filter, include)
 	}
 
+	if jobs := params.Jobs(); jobs > 1 && len(filteredFiles) > 1 {
+		statusReporter(logging.Verbose, "Extracting files with %d jobs", jobs)
+		return e.extractParallel(filteredFiles, inputFeed, sink, jobs)
+	}
 	for _, f := range filteredFiles {
-		// In a closure to ensure file is closed after processing (defer)
-		err := func() error {
-			fileExtractor := file.NewFileExtractor(f.Name, statusReporter, params)
-			fileReader, err := f.Open()
+		var writeErr error
+		err := e.extractFile(f, inputFeed, func() (io.Writer, func()) {
+			writeFile, err := sink.Create(f.Name())
 			if err != nil {
-				return fmt.Errorf("error opening file %s: %w", f.Name, err)
+				writeErr = fmt.Errorf("error creating file %s: %w", f.Name(), err)
+				return failedWriter{writeErr}, func() {}
 			}
-			defer fileReader.Close()
-			err = fileExtractor.Run(fileReader, func() (io.Writer, func()) {
-				writeFile, err := zipWriter.CreateHeader(&f.FileHeader)
-				if err != nil {
-					return nil, func() {}
+			return writeFile, func() {
+				if err := writeFile.Close(); err != nil {
+					writeErr = fmt.Errorf("error writing file %s: %w", f.Name(), err)
 				}
-				return writeFile, func() {}
-			})
-			return nil
+			}
+		})
+		if err == nil {
+			err = writeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rejects returns the malformed rows skipped by the last extraction, ordered by file and line.
+func (e *Extractor) Rejects() []file.Reject {
+	e.rejectsMu.Lock()
+	defer e.rejectsMu.Unlock()
+	rejects := slices.Clone(e.rejects)
+	slices.SortStableFunc(rejects, func(a, b file.Reject) int {
+		return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line))
+	})
+	return rejects
+}
+
+// Warnings returns the files and fields removed by the last extraction that may make the output invalid GTFS,
+// like conditionally required fields, or required ones if invalid output is allowed.
+func (e *Extractor) Warnings() []string {
+	return e.warnings
+}
+
+// checkRequired checks the headers of the input files for renames that collide, and for files and fields
+// required by the GTFS reference that the extraction would remove. Only files and fields the input feed
+// has are checked. Removing required ones is an error, unless invalid output is allowed.
+func (e *Extractor) checkRequired(source gtfsio.Source, inputFeed *feed.Feed) error {
+	var required, conditional []string
+	for _, f := range source.Files() {
+		if _, ok := schema.Lookup(f.Name()); !ok && len(e.params.RenamedFields(f.Name())) == 0 {
+			continue
+		}
+		header, err := inputFeed.Header(f.Name())
+		if err != nil {
+			return err
+		}
+		fileRequired, fileConditional := e.params.RemovedRequired(f.Name(), header)
+		required = append(required, fileRequired...)
+		conditional = append(conditional, fileConditional...)
+	}
+	if len(required) > 0 && !e.params.AllowInvalid() {
+		return errors.Join(params.ErrRemovesRequired, fmt.Errorf("removed %s", strings.Join(required, ", ")))
+	}
+	e.warnings = append(required, conditional...)
+	return nil
+}
+
+func (e *Extractor) reject(r file.Reject) {
+	e.rejectsMu.Lock()
+	defer e.rejectsMu.Unlock()
+	e.rejects = append(e.rejects, r)
+	e.report(logging.Verbose, "\tSkipping malformed row on line %d of file %s: %s", r.Line, r.File, r.Reason)
+}
+
+// failedWriter fails every write, for a file that couldn't be created.
+type failedWriter struct {
+	err error
+}
+
+func (w failedWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+// extractFile runs a FileExtractor on a single file of the input feed.
+func (e *Extractor) extractFile(f gtfsio.File, inputFeed *feed.Feed, writerCreate func() (io.Writer, func())) error {
+	fileExtractor := file.NewFileExtractor(f.Name(), e.report, e.params).
+		WithRowProcessors(inputFeed.Processors(f.Name())...).
+		WithRejectHandler(e.reject)
+	fileReader, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %w", f.Name(), err)
+	}
+	defer fileReader.Close()
+	return fileExtractor.Run(fileReader, writerCreate)
+}
+
+// spooled is a file extracted by a worker to a temporary file.
+type spooled struct {
+	// nil if the file extractor didn't write the file, like an excluded empty file
+	file *os.File
+	err  error
+}
+
+func (s spooled) remove() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+}
+
+// extractParallel extracts files with up to jobs workers. Each worker extracts a file to a temporary file,
+// which is copied to the sink in the order of files once all files before it are copied, so the output
+// doesn't depend on scheduling. The first error stops the extraction.
+func (e *Extractor) extractParallel(files []gtfsio.File, inputFeed *feed.Feed, sink gtfsio.Sink, jobs int) error {
+	results := make([]chan spooled, len(files))
+	for i := range results {
+		results[i] = make(chan spooled, 1)
+	}
+
+	done := make(chan struct{})
+	indices := make(chan int)
+	go func() {
+		defer close(indices)
+		for i := range files {
+			select {
+			case indices <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range min(jobs, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i] <- e.spoolFile(files[i], inputFeed)
+			}
 		}()
+	}
+
+	defer func() {
+		// Stop handing out files and clean up the ones finished but not copied
+		close(done)
+		wg.Wait()
+		for _, result := range results {
+			select {
+			case s := <-result:
+				s.remove()
+			default:
+			}
+		}
+	}()
+
+	for i, f := range files {
+		s := <-results[i]
+		err := s.err
+		if err == nil && s.file != nil {
+			err = copyToSink(s.file, f.Name(), sink)
+		}
+		s.remove()
 		if err != nil {
 			return err
 		}
@@ -99,9 +281,113 @@ func (e *Extractor) Extract(zipReader *zip.Reader, zipWriter *zip.Writer) error
 	return nil
 }
 
-func filterFiles(srcFiles []*zip.File, filterFiles []string, include bool) []*zip.File {
-	return slices.DeleteFunc(srcFiles, func(file *zip.File) bool {
-		//TODO: optimize with map (just key presence) or set (something hash based)
-		return include != slices.Contains(filterFiles, file.Name)
+// spoolFile extracts a single file to a temporary file, positioned at its start.
+func (e *Extractor) spoolFile(f gtfsio.File, inputFeed *feed.Feed) spooled {
+	var s spooled
+	err := e.extractFile(f, inputFeed, func() (io.Writer, func()) {
+		s.file, s.err = os.CreateTemp("", "gtfs-tool-*.txt")
+		if s.err != nil {
+			// Like a failed sink.Create, the file extractor fails writing
+			return failedWriter{s.err}, func() {}
+		}
+		return s.file, func() {}
+	})
+	if err == nil {
+		err = s.err
+	}
+	if err == nil && s.file != nil {
+		_, err = s.file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		s.remove()
+		return spooled{err: fmt.Errorf("error extracting file %s: %w", f.Name(), err)}
+	}
+	return s
+}
+
+func copyToSink(r io.Reader, name string, sink gtfsio.Sink) error {
+	w, err := sink.Create(name)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %w", name, err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return fmt.Errorf("error writing file %s: %w", name, err)
+	}
+	return w.Close()
+}
+
+// runPasses runs the extraction passes that need to look at more than one file. Each pass
+// registers row processors on the feed, and sees the rows left by the passes before it.
+func (e *Extractor) runPasses(inputFeed *feed.Feed) error {
+	params := e.params
+
+	// Before everything else, so the other passes see each entity once
+	if policy := params.Dedupe(); policy != "" {
+		e.report(logging.Verbose, "Removing duplicate rows, %s for duplicate keys", policy)
+		if err := dedupe.Apply(inputFeed, policy, e.report); err != nil {
+			return fmt.Errorf("error removing duplicates: %w", err)
+		}
+	}
+
+	// Clip services first, so a subset doesn't pull in services that are not active in the window
+	from, to := params.DateWindow()
+	serviceWindow := window.Window{From: from, To: to}
+	if !serviceWindow.IsEmpty() {
+		e.report(logging.Verbose, "Clipping services to window %s", serviceWindow)
+		if err := window.Apply(inputFeed, serviceWindow, e.report); err != nil {
+			return fmt.Errorf("error clipping service window: %w", err)
+		}
+	}
+
+	selection := subset.Selection{
+		Agencies: params.KeepAgencies(),
+		Routes:   params.KeepRoutes(),
+		Trips:    params.KeepTrips(),
+	}
+	if !selection.IsEmpty() {
+		e.report(logging.Verbose, "Computing subset for agencies %v, routes %v, trips %v",
+			selection.Agencies, selection.Routes, selection.Trips)
+		if _, err := subset.Apply(inputFeed, selection, e.report); err != nil {
+			return fmt.Errorf("error computing subset: %w", err)
+		}
+	}
+
+	if area := params.Area(); area != nil {
+		e.report(logging.Verbose, "Restricting feed to area, clipping trips: %v", params.ClipTrips())
+		if err := geo.Apply(inputFeed, area, params.ClipTrips(), e.report); err != nil {
+			return fmt.Errorf("error restricting feed to area: %w", err)
+		}
+	}
+
+	// Sample among the trips left by the filtering passes
+	if params.SampleTrips() > 0 {
+		opts := sample.Options{Trips: params.SampleTrips(), Seed: params.SampleSeed(), PerRoute: params.SamplePerRoute()}
+		e.report(logging.Verbose, "Sampling %d trips, per route: %v, seed %d", opts.Trips, opts.PerRoute, opts.Seed)
+		if _, err := sample.Apply(inputFeed, opts, e.report); err != nil {
+			return fmt.Errorf("error sampling trips: %w", err)
+		}
+	}
+
+	// After the other filtering passes, so it cleans up after all of them
+	if params.PruneOrphans() {
+		e.report(logging.Verbose, "Pruning orphaned entities")
+		if err := prune.Apply(inputFeed, params, e.report); err != nil {
+			return fmt.Errorf("error pruning orphans: %w", err)
+		}
+	}
+
+	if params.SimplifyShapes() > 0 || params.ShapePrecision() > 0 {
+		e.report(logging.Verbose, "Simplifying shapes")
+		if err := shapes.Apply(inputFeed, params.SimplifyShapes(), params.ShapePrecision(), e.report); err != nil {
+			return fmt.Errorf("error simplifying shapes: %w", err)
+		}
+	}
+	return nil
+}
+
+func filterFiles(srcFiles []gtfsio.File, filterFiles []string, include bool) []gtfsio.File {
+	return slices.DeleteFunc(srcFiles, func(file gtfsio.File) bool {
+		return include != pattern.MatchAny(filterFiles, file.Name())
 	})
 }
@@ -4,12 +4,16 @@ import (
 	"archive/zip"
 	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"slices"
 	"testing"
 
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/file"
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
 )
 
@@ -38,54 +42,54 @@ func (tep *testExtractParams) ToExtractParams() *params.ExtractParams {
 }
 
 func Test_filterFiles(t *testing.T) {
-	genZip := func(name string) *zip.File {
-		return &zip.File{FileHeader: zip.FileHeader{Name: name}}
+	genZip := func(name string) gtfsio.File {
+		return testFile(name)
 	}
 	tests := []struct {
 		name string // description of this test case
 		// Named input parameters for target function.
-		srcFiles    []*zip.File
+		srcFiles    []gtfsio.File
 		filterFiles []string
 		include     bool
-		want        []*zip.File
+		want        []gtfsio.File
 	}{
 		{
 			name: "include files",
-			srcFiles: []*zip.File{
+			srcFiles: []gtfsio.File{
 				genZip("a.txt"),
 				genZip("b.txt"),
 				genZip("c.txt"),
 			},
 			filterFiles: []string{"a.txt", "c.txt"},
 			include:     true,
-			want: []*zip.File{
+			want: []gtfsio.File{
 				genZip("a.txt"),
 				genZip("c.txt"),
 			},
 		},
 		{
 			name: "exclude files",
-			srcFiles: []*zip.File{
+			srcFiles: []gtfsio.File{
 				genZip("a.txt"),
 				genZip("b.txt"),
 				genZip("c.txt"),
 			},
 			filterFiles: []string{"a.txt", "c.txt"},
 			include:     false,
-			want: []*zip.File{
+			want: []gtfsio.File{
 				genZip("b.txt"),
 			},
 		},
 		{
 			name: "exclude file that isn't in source",
-			srcFiles: []*zip.File{
+			srcFiles: []gtfsio.File{
 				genZip("a.txt"),
 				genZip("b.txt"),
 				genZip("c.txt"),
 			},
 			filterFiles: []string{"d.txt"},
 			include:     false,
-			want: []*zip.File{
+			want: []gtfsio.File{
 				genZip("a.txt"),
 				genZip("b.txt"),
 				genZip("c.txt"),
@@ -93,22 +97,49 @@ func Test_filterFiles(t *testing.T) {
 		},
 		{
 			name: "include file that isn't in source",
-			srcFiles: []*zip.File{
+			srcFiles: []gtfsio.File{
 				genZip("a.txt"),
 				genZip("b.txt"),
 				genZip("c.txt"),
 			},
 			filterFiles: []string{"d.txt"},
 			include:     true,
-			want:        []*zip.File{},
+			want:        []gtfsio.File{},
+		},
+		{
+			name: "exclude glob",
+			srcFiles: []gtfsio.File{
+				genZip("fare_attributes.txt"),
+				genZip("fare_rules.txt"),
+				genZip("stops.txt"),
+			},
+			filterFiles: []string{"fare_*.txt"},
+			include:     false,
+			want: []gtfsio.File{
+				genZip("stops.txt"),
+			},
+		},
+		{
+			name: "include regexp",
+			srcFiles: []gtfsio.File{
+				genZip("stops.txt"),
+				genZip("stop_times.txt"),
+				genZip("trips.txt"),
+			},
+			filterFiles: []string{"/^stop/"},
+			include:     true,
+			want: []gtfsio.File{
+				genZip("stops.txt"),
+				genZip("stop_times.txt"),
+			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			got := filterFiles(tt.srcFiles, tt.filterFiles, tt.include)
 			// TODO: update the condition below to compare got with tt.want.
-			if !slices.EqualFunc(got, tt.want, func(a, b *zip.File) bool {
-				return a.Name == b.Name
+			if !slices.EqualFunc(got, tt.want, func(a, b gtfsio.File) bool {
+				return a.Name() == b.Name()
 			}) {
 				t.Errorf("filterFiles() = %v, want %v", got, tt.want)
 			}
@@ -116,6 +147,17 @@ func Test_filterFiles(t *testing.T) {
 	}
 }
 
+// testFile is a gtfsio.File with only a name
+type testFile string
+
+func (f testFile) Name() string {
+	return string(f)
+}
+
+func (f testFile) Open() (io.ReadCloser, error) {
+	return nil, os.ErrNotExist
+}
+
 type zipTestFunc func(reader *zip.Reader, filename string, f *zip.File) error // error if test fails
 type zipTests map[string]zipTestFunc                                          // filename, test function
 
@@ -215,7 +257,7 @@ func Test_extractCommand(t *testing.T) {
 				reportLevel,
 			)
 
-			err = extractor.Extract(inputZip, zipWriter)
+			err = extractor.Extract(gtfsio.NewZipSource(inputZip), gtfsio.NewZipSink(zipWriter))
 			zipWriter.Close()
 
 			if (err != nil) != tt.wantErr {
@@ -300,3 +342,362 @@ func exists(filename string) zipTestFunc {
 		return fmt.Errorf("file %s does not exist in zip", filename)
 	}
 }
+
+// testSource is a gtfsio.Source over a fixed list of files
+type testSource []gtfsio.File
+
+func (s testSource) Files() []gtfsio.File {
+	return slices.Clone(s)
+}
+
+func (s testSource) Close() error {
+	return nil
+}
+
+func TestExtractor_Extract_Jobs(t *testing.T) {
+	var reporter logging.LogConsumer = func(status string, level logging.StatusLevel) {}
+	input := map[string]string{
+		"agency.txt":     "agency_id,agency_name\nA1,LPP\n",
+		"routes.txt":     "route_id,agency_id,route_type,route_color\nR6,A1,3,\nR11,A1,3,\n",
+		"trips.txt":      "route_id,service_id,trip_id\nR6,WD,T1\nR11,WD,T2\n",
+		"stop_times.txt": "trip_id,stop_id,stop_sequence,pickup_type\nT1,P1,1,\nT1,P2,2,\nT2,P2,1,\n",
+		"stops.txt":      "stop_id,stop_name\nP1,Konzorcij\nP2,Bavarski dvor\n",
+		"levels.txt":     "level_id,level_index\n",
+	}
+	inputBytes := createZipBytes(t, input)
+
+	extract := func(jobs int) []string {
+		zr, err := zip.NewReader(bytes.NewReader(inputBytes), int64(len(inputBytes)))
+		if err != nil {
+			t.Fatalf("failed to create zip reader: %v", err)
+		}
+		p := params.NewExtractParamsParsed(nil, nil, true, true, false, nil, nil).
+			WithWhere([]string{"routes.txt,route_id=R6"}).
+			WithJobs(jobs)
+		if err := p.ParseAndValidate(); err != nil {
+			t.Fatalf("failed to parse params: %v", err)
+		}
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		if err := NewExtractor(p, reporter, logging.NoStatus).Extract(gtfsio.NewZipSource(zr), gtfsio.NewZipSink(zw)); err != nil {
+			t.Fatalf("Extract() with %d jobs failed: %v", jobs, err)
+		}
+		zw.Close()
+
+		out, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		var files []string
+		for _, f := range out.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatalf("failed to open %s: %v", f.Name, err)
+			}
+			content, _ := io.ReadAll(r)
+			r.Close()
+			files = append(files, f.Name+":"+string(content))
+		}
+		return files
+	}
+
+	want := extract(1)
+	if len(want) != len(input)-1 {
+		t.Fatalf("sequential extraction wrote %d files, want %d", len(want), len(input)-1)
+	}
+	for _, jobs := range []int{2, 4, 16} {
+		if got := extract(jobs); !slices.Equal(got, want) {
+			t.Errorf("Extract() with %d jobs = %v, want %v", jobs, got, want)
+		}
+	}
+}
+
+func TestExtractor_Extract_JobsError(t *testing.T) {
+	var reporter logging.LogConsumer = func(status string, level logging.StatusLevel) {}
+	p := params.NewExtractParamsParsed(nil, nil, false, false, false, nil, nil).WithJobs(4)
+	if err := p.ParseAndValidate(); err != nil {
+		t.Fatalf("failed to parse params: %v", err)
+	}
+	source := testSource{testFile("a.txt"), testFile("b.txt"), testFile("c.txt")}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	err := NewExtractor(p, reporter, logging.NoStatus).Extract(source, gtfsio.NewZipSink(zw))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Extract() error = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestExtractor_Extract_Rejects(t *testing.T) {
+	var reporter logging.LogConsumer = func(status string, level logging.StatusLevel) {}
+	inputBytes := createZipBytes(t, map[string]string{
+		"stops.txt": "stop_id,stop_name\nP1,Konzorcij\nP2\nP3,Kolodvor\n",
+		"trips.txt": "route_id,service_id,trip_id\nR6,WD,T1,x\nR6,WD,T2\n",
+	})
+	want := []file.Reject{
+		{File: "stops.txt", Line: 3, Content: "P2", Reason: "wrong number of fields: 1 instead of 2"},
+		{File: "trips.txt", Line: 2, Content: "R6,WD,T1,x", Reason: "wrong number of fields: 4 instead of 3"},
+	}
+
+	for _, jobs := range []int{1, 4} {
+		for _, strict := range []bool{false, true} {
+			zr, err := zip.NewReader(bytes.NewReader(inputBytes), int64(len(inputBytes)))
+			if err != nil {
+				t.Fatalf("failed to create zip reader: %v", err)
+			}
+			p := params.NewExtractParamsParsed(nil, nil, false, false, false, nil, nil).
+				WithJobs(jobs).
+				WithStrict(strict)
+			if err := p.ParseAndValidate(); err != nil {
+				t.Fatalf("failed to parse params: %v", err)
+			}
+			extractor := NewExtractor(p, reporter, logging.NoStatus)
+			err = extractor.Extract(gtfsio.NewZipSource(zr), gtfsio.NewZipSink(zip.NewWriter(io.Discard)))
+			if strict {
+				if !errors.Is(err, csv.ErrFieldCount) {
+					t.Errorf("Extract() with %d jobs, strict error = %v, want %v", jobs, err, csv.ErrFieldCount)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("Extract() with %d jobs failed: %v", jobs, err)
+			}
+			if got := extractor.Rejects(); !slices.Equal(got, want) {
+				t.Errorf("Rejects() with %d jobs = %+v, want %+v", jobs, got, want)
+			}
+		}
+	}
+}
+
+func TestExtractor_Extract_RemovesRequired(t *testing.T) {
+	var reporter logging.LogConsumer = func(status string, level logging.StatusLevel) {}
+	withURL := map[string]string{
+		"agency.txt": "agency_id,agency_name,agency_url,agency_timezone\nA1,LPP,https://www.lpp.si,Europe/Ljubljana\n",
+		"stops.txt":  "stop_id,stop_name,stop_url,parent_station\nP1,Konzorcij,,\n",
+	}
+	withoutURL := map[string]string{
+		"agency.txt": "agency_id,agency_name,agency_timezone\nA1,LPP,Europe/Ljubljana\n",
+		"stops.txt":  "stop_id,stop_name,stop_url\nP1,Konzorcij,\n",
+	}
+	tests := []struct {
+		name         string
+		input        map[string]string
+		allowInvalid bool
+		wantErr      error
+		wantWarnings []string
+	}{
+		{
+			name:    "required field in input",
+			input:   withURL,
+			wantErr: params.ErrRemovesRequired,
+		},
+		{
+			name:         "required field in input with allow invalid",
+			input:        withURL,
+			allowInvalid: true,
+			wantWarnings: []string{
+				"required field agency_url of agency.txt",
+				"conditionally required field parent_station of stops.txt",
+			},
+		},
+		{
+			// Neither agency_url nor parent_station nor feed_info.txt are in the input, so none are removed
+			name:  "required field not in input",
+			input: withoutURL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputBytes := createZipBytes(t, tt.input)
+			zr, err := zip.NewReader(bytes.NewReader(inputBytes), int64(len(inputBytes)))
+			if err != nil {
+				t.Fatalf("failed to create zip reader: %v", err)
+			}
+			p := params.NewExtractParams(nil, nil, false, false, false, []string{"*,*_url", "stops.txt,parent_station"}, nil).
+				WithAllowInvalid(tt.allowInvalid)
+			if err := p.ParseAndValidate(); err != nil {
+				t.Fatalf("failed to parse params: %v", err)
+			}
+			extractor := NewExtractor(p, reporter, logging.NoStatus)
+			err = extractor.Extract(gtfsio.NewZipSource(zr), gtfsio.NewZipSink(zip.NewWriter(io.Discard)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Extract() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := extractor.Warnings(); !slices.Equal(got, tt.wantWarnings) {
+				t.Errorf("Warnings() = %v, want %v", got, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestExtractor_Extract_Minimal(t *testing.T) {
+	var reporter logging.LogConsumer = func(status string, level logging.StatusLevel) {}
+	inputBytes := createZipBytes(t, map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_desc,stop_lat,stop_lon,location_type,parent_station\n" +
+			"ST,Kolodvor,,46.058,14.510,1,\n" +
+			"P1,Kolodvor peron 1,Peron,46.058,14.511,0,ST\n" +
+			"E1,Kolodvor vhod,,46.057,14.510,2,ST\n",
+	})
+	zr, err := zip.NewReader(bytes.NewReader(inputBytes), int64(len(inputBytes)))
+	if err != nil {
+		t.Fatalf("failed to create zip reader: %v", err)
+	}
+	p := params.NewExtractParams(nil, nil, false, false, false, nil, nil).WithMinimal(true)
+	if err := p.ParseAndValidate(); err != nil {
+		t.Fatalf("failed to parse params: %v", err)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := NewExtractor(p, reporter, logging.NoStatus).Extract(gtfsio.NewZipSource(zr), gtfsio.NewZipSink(zw)); err != nil {
+		t.Fatalf("Extract() failed: %v", err)
+	}
+	zw.Close()
+
+	out, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	r, err := out.Open("stops.txt")
+	if err != nil {
+		t.Fatalf("failed to open stops.txt: %v", err)
+	}
+	defer r.Close()
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read stops.txt: %v", err)
+	}
+	header := records[0]
+	if slices.Contains(header, "stop_desc") {
+		t.Errorf("header = %v, want optional stop_desc removed", header)
+	}
+	typeIdx, parentIdx := slices.Index(header, "location_type"), slices.Index(header, "parent_station")
+	if typeIdx < 0 || parentIdx < 0 {
+		t.Fatalf("header = %v, want location_type and parent_station", header)
+	}
+	// A parent station must be a station, which it is only with its location_type
+	locationTypes := map[string]string{}
+	for _, record := range records[1:] {
+		locationTypes[record[0]] = record[typeIdx]
+	}
+	for _, record := range records[1:] {
+		if parent := record[parentIdx]; parent != "" && locationTypes[parent] != "1" {
+			t.Errorf("stop %s has parent_station %s with location_type %q, want 1", record[0], parent, locationTypes[parent])
+		}
+	}
+}
+
+func TestExtractor_Extract_WriteError(t *testing.T) {
+	var reporter logging.LogConsumer = func(status string, level logging.StatusLevel) {}
+	p := params.NewExtractParamsParsed(nil, nil, false, false, false, nil, nil)
+	if err := p.ParseAndValidate(); err != nil {
+		t.Fatalf("failed to parse params: %v", err)
+	}
+	inputBytes := createZipBytes(t, map[string]string{"stops.txt": "stop_id,stop_name\nP1,Konzorcij\n"})
+	zr, err := zip.NewReader(bytes.NewReader(inputBytes), int64(len(inputBytes)))
+	if err != nil {
+		t.Fatalf("failed to create zip reader: %v", err)
+	}
+	errFull := errors.New("disk full")
+	err = NewExtractor(p, reporter, logging.NoStatus).Extract(gtfsio.NewZipSource(zr), failingSink{errFull})
+	if !errors.Is(err, errFull) {
+		t.Errorf("Extract() error = %v, want %v", err, errFull)
+	}
+}
+
+// failingSink fails when a file is closed, like a sink that loads files into a database.
+type failingSink struct {
+	err error
+}
+
+func (s failingSink) Create(name string) (io.WriteCloser, error) {
+	return failingFile{s.err}, nil
+}
+
+func (s failingSink) Close() error {
+	return nil
+}
+
+type failingFile struct {
+	err error
+}
+
+func (f failingFile) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (f failingFile) Close() error {
+	return f.err
+}
+
+func createZipBytes(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractor_Plan(t *testing.T) {
+	var reporter logging.LogConsumer = func(status string, level logging.StatusLevel) {}
+	input := map[string]string{
+		"routes.txt": "route_id,route_type,route_color\nR6,3,\nR11,3,\nRT,2,\nR9\n",
+		"stops.txt":  "stop_id,stop_name,stop_desc\nP1,Konzorcij,\nP2,\"Bavarski\ndvor\",\n",
+		"levels.txt": "level_id,level_index\n",
+		"shapes.txt": "shape_id,shape_pt_sequence\nS1,1\n",
+		"trips.txt":  "route_id,trip_id,shape_id\nR6,T1,S1\n",
+	}
+	inputBytes := createZipBytes(t, input)
+	zr, err := zip.NewReader(bytes.NewReader(inputBytes), int64(len(inputBytes)))
+	if err != nil {
+		t.Fatalf("failed to create zip reader: %v", err)
+	}
+	p := params.NewExtractParamsParsed([]string{"fare_rules.txt"}, nil, true, true, true,
+		map[string][]string{"stops.txt": {"stop_name", "stop_code"}}, nil).
+		WithWhere([]string{"routes.txt,route_type=3"})
+	if err := p.ParseAndValidate(); err != nil {
+		t.Fatalf("failed to parse params: %v", err)
+	}
+
+	plan, err := NewExtractor(p, reporter, logging.NoStatus).Plan(gtfsio.NewZipSource(zr), true)
+	if err != nil {
+		t.Fatalf("Plan() failed: %v", err)
+	}
+
+	got := make(map[string]FilePlan, len(plan.Files))
+	for _, f := range plan.Files {
+		got[f.Name] = f
+	}
+	if f := got["shapes.txt"]; f.Kept || f.Reason != ReasonExcluded || f.RowsIn != 1 {
+		t.Errorf("shapes.txt = %+v, want dropped as excluded with 1 row", f)
+	}
+	if f := got["trips.txt"]; !f.Kept || !slices.Equal(f.RemovedColumns, []RemovedColumn{{"shape_id", ReasonExcluded}}) {
+		t.Errorf("trips.txt = %+v, want shape_id removed", f)
+	}
+	if f := got["levels.txt"]; f.Kept || f.Reason != ReasonEmpty {
+		t.Errorf("levels.txt = %+v, want dropped as empty", f)
+	}
+	if f := got["routes.txt"]; !f.Kept || f.RowsIn != 4 || f.RowsOut != 2 || f.RowsRejected != 1 ||
+		!slices.Equal(f.Columns, []string{"route_id", "route_type"}) ||
+		!slices.Equal(f.RemovedColumns, []RemovedColumn{{"route_color", ReasonEmpty}}) {
+		t.Errorf("routes.txt = %+v, want 2 of 4 rows and 1 rejected without the empty route_color", f)
+	}
+	if f := got["stops.txt"]; !f.Kept || f.RowsOut != 2 ||
+		!slices.Equal(f.Columns, []string{"stop_id"}) ||
+		!slices.Equal(f.RemovedColumns, []RemovedColumn{{"stop_name", ReasonExcluded}, {"stop_desc", ReasonEmpty}}) ||
+		!slices.Equal(f.MissingFields, []string{"stop_code"}) {
+		t.Errorf("stops.txt = %+v, want only stop_id and missing stop_code", f)
+	}
+	if !slices.Equal(plan.MissingFiles, []string{"fare_rules.txt"}) {
+		t.Errorf("MissingFiles = %v, want [fare_rules.txt]", plan.MissingFiles)
+	}
+	if plan.OutputBytes == 0 || !plan.Zipped {
+		t.Errorf("OutputBytes = %d, Zipped = %v, want a zip size", plan.OutputBytes, plan.Zipped)
+	}
+}
@@ -0,0 +1,4 @@
+// Package feed gives extraction passes that work across GTFS files read access to the whole input feed.
+// Passes read tables the same way the file extractor will, and register row processors that the
+// file extractor then applies when writing the output.
+package feed
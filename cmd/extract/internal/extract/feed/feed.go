@@ -0,0 +1,170 @@
+package feed
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/file"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
+)
+
+// Feed is the input feed of an extraction, together with the row processors registered by passes.
+type Feed struct {
+	files      map[string]gtfsio.File
+	params     *params.ExtractParams
+	processors map[string][]rows.Processor
+}
+
+// New creates a Feed over the files of the input feed.
+// Rows read through the Feed are processed by the row processors from the params first.
+func New(files []gtfsio.File, params *params.ExtractParams) *Feed {
+	f := &Feed{
+		files:      make(map[string]gtfsio.File, len(files)),
+		params:     params,
+		processors: make(map[string][]rows.Processor),
+	}
+	for _, file := range files {
+		f.files[file.Name()] = file
+	}
+	return f
+}
+
+// Names returns the names of the files of the input feed, sorted.
+func (f *Feed) Names() []string {
+	return slices.Sorted(maps.Keys(f.files))
+}
+
+// Has reports whether the input feed contains the file.
+func (f *Feed) Has(fileName string) bool {
+	_, ok := f.files[fileName]
+	return ok
+}
+
+// AddProcessors registers row processors for a file. They apply to all later reads of the file
+// and to the extracted output.
+func (f *Feed) AddProcessors(fileName string, processors ...rows.Processor) {
+	f.processors[fileName] = append(f.processors[fileName], processors...)
+}
+
+// Processors returns the row processors registered by passes for a file.
+func (f *Feed) Processors(fileName string) []rows.Processor {
+	return f.processors[fileName]
+}
+
+// Row is a single data row of a table read through the Feed.
+type Row struct {
+	index  map[string]int
+	record []string
+}
+
+// Get returns the value of field, or an empty string if the table doesn't have it.
+func (r Row) Get(field string) string {
+	i, ok := r.index[field]
+	if !ok || i >= len(r.record) {
+		return ""
+	}
+	return r.record[i]
+}
+
+// Values returns the values of all fields of the row, in the order of the header.
+// The slice must not be modified or kept after the callback returns.
+func (r Row) Values() []string {
+	return r.record
+}
+
+// Has reports whether the table has the field.
+func (r Row) Has(field string) bool {
+	_, ok := r.index[field]
+	return ok
+}
+
+// Header returns the header of the file, with fields renamed according to the params.
+// A file that is not in the feed or is empty has no header.
+func (f *Feed) Header(fileName string) ([]string, error) {
+	_, header, closer, err := f.open(fileName)
+	if closer != nil {
+		closer.Close()
+	}
+	return header, err
+}
+
+// open opens the file and reads its header. All return values are nil if the file is not in the feed or is empty.
+// Malformed rows are skipped without reporting them, unless strict, since the extracted output reports them.
+func (f *Feed) open(fileName string) (*file.Reader, []string, io.Closer, error) {
+	input, ok := f.files[fileName]
+	if !ok {
+		return nil, nil, nil, nil
+	}
+	reader, err := input.Open()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error opening file %s: %w", fileName, err)
+	}
+
+	csvReader := file.NewReader(reader, fileName, f.params.Strict(), nil)
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		reader.Close()
+		return nil, nil, nil, nil
+	}
+	if err == nil {
+		header, err = file.RenameFields(header, f.params.RenamedFields(fileName))
+		if err != nil {
+			err = fmt.Errorf("error renaming fields of file %s: %w", fileName, err)
+		}
+	} else {
+		err = fmt.Errorf("error reading header of file %s: %w", fileName, err)
+	}
+	if err != nil {
+		reader.Close()
+		return nil, nil, nil, err
+	}
+	return csvReader, header, reader, nil
+}
+
+// ReadTable calls fn for every row of the file that is kept by the row processors.
+// A file that is not in the feed is not an error, fn is simply never called.
+func (f *Feed) ReadTable(fileName string, fn func(row Row) error) error {
+	csvReader, header, closer, err := f.open(fileName)
+	if err != nil || closer == nil {
+		return err
+	}
+	defer closer.Close()
+
+	processors := append(f.params.RowProcessors(fileName), f.processors[fileName]...)
+	processRow, err := rows.BindAll(header, processors)
+	if err != nil {
+		return fmt.Errorf("error preparing row processors for file %s: %w", fileName, err)
+	}
+
+	index := make(map[string]int, len(header))
+	for i, field := range header {
+		index[field] = i
+	}
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading data from file %s: %w", fileName, err)
+		}
+		if processRow != nil {
+			keep, err := processRow(record)
+			if err != nil {
+				return fmt.Errorf("error processing row of file %s: %w", fileName, err)
+			}
+			if !keep {
+				continue
+			}
+		}
+		if err := fn(Row{index: index, record: record}); err != nil {
+			return err
+		}
+	}
+}
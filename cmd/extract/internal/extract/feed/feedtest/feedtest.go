@@ -0,0 +1,81 @@
+// Package feedtest helps testing extraction passes on small feeds given as file contents.
+package feedtest
+
+import (
+	"archive/zip"
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
+)
+
+// NewFeed creates a Feed of the files, from file name to contents, zipped in memory.
+// The params are parsed first, nil params select everything.
+func NewFeed(t testing.TB, files map[string]string, p *params.ExtractParams) *feed.Feed {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip writer: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("failed to create zip reader: %v", err)
+	}
+	if p == nil {
+		p = params.NewExtractParamsParsed(nil, nil, false, false, false, nil, nil)
+	}
+	if err := p.ParseAndValidate(); err != nil {
+		t.Fatalf("failed to parse params: %v", err)
+	}
+	return feed.New(gtfsio.NewZipSource(zr).Files(), p)
+}
+
+// Column returns the values of field in the rows of the file that are kept by the passes, sorted.
+func Column(t testing.TB, f *feed.Feed, fileName, field string) []string {
+	t.Helper()
+	var values []string
+	err := f.ReadTable(fileName, func(row feed.Row) error {
+		values = append(values, row.Get(field))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", fileName, err)
+	}
+	slices.Sort(values)
+	return values
+}
+
+// Rows returns the values of the fields in the rows of the file that are kept by the passes, in file order,
+// or all values without fields. The values of a row are joined by commas.
+func Rows(t testing.TB, f *feed.Feed, fileName string, fields ...string) []string {
+	t.Helper()
+	var rows []string
+	err := f.ReadTable(fileName, func(row feed.Row) error {
+		if len(fields) == 0 {
+			rows = append(rows, strings.Join(row.Values(), ","))
+			return nil
+		}
+		values := make([]string, len(fields))
+		for i, field := range fields {
+			values[i] = row.Get(field)
+		}
+		rows = append(rows, strings.Join(values, ","))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", fileName, err)
+	}
+	return rows
+}
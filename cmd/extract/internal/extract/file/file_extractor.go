@@ -9,6 +9,9 @@ import (
 
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal"
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/pattern"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/columnorder"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
 )
 
@@ -26,21 +29,40 @@ type FileExtractor struct {
 
 	includedFields []string
 	excludedFields []string
+
+	// old field name -> new field name, applied to the header before everything else
+	renamedFields map[string]string
+
+	// applied in order to every data row, before the field mapping
+	rowProcessors []rows.Processor
+
+	// bytes of rows buffered in memory while excluding empty fields, before they are spilled to disk
+	memoryLimit int64
+
+	// fail on malformed rows instead of skipping them
+	strict bool
+	// called for every skipped malformed row, can be nil
+	onReject func(Reject)
+
+	// nil to keep the input order
+	columnOrder *columnorder.Order
+	// empty to keep the input order of rows
+	sortKeys []params.SortKey
 }
 
 func NewFileExtractor(
 	fileName string,
 	statusReporter logging.LogReporter,
 	globalExtractorParams *params.ExtractParams) *FileExtractor {
-	includedF, ok := globalExtractorParams.IncludedFields()[fileName]
-	if !ok {
+	includedF := globalExtractorParams.IncludedFieldsOf(fileName)
+	if includedF == nil {
 		includedF = []string{}
 	}
-	excludedF, ok := globalExtractorParams.ExcludedFields()[fileName]
-	if !ok {
+	excludedF := globalExtractorParams.ExcludedFieldsOf(fileName)
+	if excludedF == nil {
 		excludedF = []string{}
 	}
-	return NewFileExtractorAll(
+	fe := NewFileExtractorAll(
 		fileName,
 		statusReporter,
 		globalExtractorParams.ExcludeEmptyFiles(),
@@ -48,6 +70,13 @@ func NewFileExtractor(
 		includedF,
 		excludedF,
 	)
+	fe.renamedFields = globalExtractorParams.RenamedFields(fileName)
+	fe.rowProcessors = globalExtractorParams.RowProcessors(fileName)
+	fe.memoryLimit = globalExtractorParams.MemoryLimit()
+	fe.strict = globalExtractorParams.Strict()
+	fe.columnOrder = globalExtractorParams.ColumnOrder()
+	fe.sortKeys = globalExtractorParams.SortKeys(fileName)
+	return fe
 }
 
 func NewFileExtractorAll(
@@ -65,9 +94,54 @@ func NewFileExtractorAll(
 		excludeEmptyFields: excludeEmptyFields,
 		includedFields:     includedFields,
 		excludedFields:     excludedFields,
+		memoryLimit:        params.DefaultMemoryLimit,
 	}
 }
 
+// WithRenamedFields sets the fields to rename, from old to new name. Included and excluded fields and
+// row processors refer to the new names.
+func (fe *FileExtractor) WithRenamedFields(renamedFields map[string]string) *FileExtractor {
+	fe.renamedFields = renamedFields
+	return fe
+}
+
+// WithRowProcessors appends row processors, which filter or rewrite data rows before the field mapping is applied.
+func (fe *FileExtractor) WithRowProcessors(processors ...rows.Processor) *FileExtractor {
+	fe.rowProcessors = append(fe.rowProcessors, processors...)
+	return fe
+}
+
+// WithMemoryLimit sets how many bytes of rows are buffered in memory while excluding empty fields.
+// Rows over the limit are spilled to a compressed temporary file.
+func (fe *FileExtractor) WithMemoryLimit(limit int64) *FileExtractor {
+	fe.memoryLimit = limit
+	return fe
+}
+
+// WithStrict sets whether a malformed row is an error. Otherwise it is skipped and passed to the reject handler.
+func (fe *FileExtractor) WithStrict(strict bool) *FileExtractor {
+	fe.strict = strict
+	return fe
+}
+
+// WithRejectHandler sets the function called for every malformed row skipped when not strict.
+func (fe *FileExtractor) WithRejectHandler(onReject func(Reject)) *FileExtractor {
+	fe.onReject = onReject
+	return fe
+}
+
+// WithColumnOrder sets the order of the columns of the output file, nil to keep the input order.
+func (fe *FileExtractor) WithColumnOrder(order *columnorder.Order) *FileExtractor {
+	fe.columnOrder = order
+	return fe
+}
+
+// WithSortKeys sets the fields the output rows are sorted by. Rows over the memory limit are sorted on disk.
+func (fe *FileExtractor) WithSortKeys(keys []params.SortKey) *FileExtractor {
+	fe.sortKeys = keys
+	return fe
+}
+
 func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Writer, func())) error {
 	log := fe.statusReporter
 
@@ -78,11 +152,9 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 	// --------------------------------------
 
 	// CSV reader setup
-	csvReader := csv.NewReader(fileReader)
-	// Fix for malformed CSVs
-	csvReader.LazyQuotes = true
+	reader := NewReader(fileReader, fe.fileName, fe.strict, fe.onReject)
 
-	rowIterator, stop := iter.Pull(readerRowsIterator(csvReader))
+	rowIterator, stop := iter.Pull(readerRowsIterator(reader))
 	defer stop()
 
 	// --------------------------------------
@@ -95,6 +167,11 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 	if err != nil {
 		return err
 	}
+	if possibleHeader != nil {
+		if possibleHeader, err = RenameFields(possibleHeader, fe.renamedFields); err != nil {
+			return fmt.Errorf("error renaming fields of file %s: %w", fe.fileName, err)
+		}
+	}
 
 	// File has headers, now check if it has data rows
 
@@ -116,8 +193,8 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 		writ, closeFunc := writerCreate()
 		defer closeFunc()
 		csvWriter := csv.NewWriter(writ)
-		defer csvWriter.Flush()
-		return nil
+		csvWriter.Flush()
+		return csvWriter.Error()
 	}
 
 	// File has header and at least one data row, proceed to processing
@@ -138,6 +215,13 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 	}
 
 	newHeader := internal.ApplyBoolMaskToSlice(possibleHeader, includeMask)
+	var columnPerm []int
+	if fe.columnOrder != nil {
+		columnPerm = fe.columnOrder.Permutation(fe.fileName, newHeader)
+		orderedHeader := make([]string, len(newHeader))
+		columnorder.Reorder(orderedHeader, newHeader, columnPerm)
+		newHeader = orderedHeader
+	}
 
 	log(logging.EvenMoreVerbose, "\tNew header: %v", func() []any {
 		return internal.ToAny(newHeader)
@@ -150,7 +234,11 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 	// Create the file in the output zip
 	writ, closeFunc := writerCreate()
 	defer closeFunc()
-	csvWriter := csv.NewWriter(writ)
+	var csvWriter rowWriter = csv.NewWriter(writ)
+	if len(fe.sortKeys) > 0 {
+		log(logging.EvenMoreVerbose, "\tSorting rows of file %s by %v", fe.fileName, fe.sortKeys)
+		csvWriter = newSortedWriter(csv.NewWriter(writ), fe.fileName, fe.sortKeys, fe.memoryLimit)
+	}
 	defer csvWriter.Flush()
 
 	// Write new headers
@@ -160,22 +248,40 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 		}
 	} else {
 		// Defer writing headers if excluding empty fields
-		log(logging.EvenMoreVerbose, "\tDeferring header write due to ExcludeEmptyFields option. Embrace for memory usage!")
+		log(logging.EvenMoreVerbose, "\tDeferring header write due to ExcludeEmptyFields option, buffering up to %d bytes in memory", fe.memoryLimit)
 	}
 
 	log(logging.EvenMoreVerbose, "\tProcessing rows for file: %s", fe.fileName)
 
 	// Prepare for row processing
-	/* TODO: maybe look into a io.Writer with CSVWriter and a backing Buffer (with compression?),
-	then write to target writer with the use of io.WriterTo= */
-	recordsBuffer := make([][]string, 0, 1000) // buffer to hold data rows if excluding empty fields
+	recordsBuffer := newRecordBuffer(fe.memoryLimit) // buffer to hold data rows if excluding empty fields
+	defer recordsBuffer.Close()
 	fieldHasDataMask := make([]bool, len(newHeader))
 
 	// Preallocate newRecord slice
 	newRecord := make([]string, len(newHeader))
-	rowsRead := 1 // Since we have already read one data row
+	// newRecord before reordering its columns
+	mappedRecord := newRecord
+	if columnPerm != nil {
+		mappedRecord = make([]string, len(newHeader))
+	}
+	rowsRead := 0
+	rowsWritten := 0
 	allFieldsHaveData := false
 
+	// Row processors see the original record, so they can use fields that are not written out
+	processRow, err := rows.BindAll(possibleHeader, fe.rowProcessors)
+	if err != nil {
+		return fmt.Errorf("error preparing row processors for file %s: %w", fe.fileName, err)
+	}
+
+	var readErr error
+	nextRecord := func() []string {
+		var record []string
+		record, readErr = fe.iteratorEntryParser(rowIterator())
+		return record
+	}
+
 	writeRowFunc := func(record []string) error {
 		if err := csvWriter.Write(record); err != nil {
 			return fmt.Errorf("error writing row \"%v\" to file %s: %w", record, fe.fileName, err)
@@ -185,14 +291,36 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 
 	// Process rows
 	// First process the already read dataRow
-	for record := possibleDataRow; record != nil; rowsRead++ {
+	for record := possibleDataRow; record != nil; record = nextRecord() {
+		rowsRead++
+
+		if processRow != nil {
+			keep, err := processRow(record)
+			if err != nil {
+				return fmt.Errorf("error processing row %d of file %s: %w", rowsRead, fe.fileName, err)
+			}
+			if !keep {
+				continue
+			}
+		}
+		rowsWritten++
+
 		// Apply field mapping
-		internal.AssignByBoolMask(newRecord, record, includeMask)
+		internal.AssignByBoolMask(mappedRecord, record, includeMask)
+		if columnPerm != nil {
+			columnorder.Reorder(newRecord, mappedRecord, columnPerm)
+		}
 
 		// Handle writing or buffering based on excludeEmptyFields option
 		// as soon as all fields have data, we can switch to direct writing
 		if fe.excludeEmptyFields && !allFieldsHaveData {
-			recordsBuffer = append(recordsBuffer, slices.Clone(newRecord))
+			spilled := recordsBuffer.Spilled()
+			if err := recordsBuffer.Add(newRecord); err != nil {
+				return fmt.Errorf("error buffering rows of file %s: %w", fe.fileName, err)
+			}
+			if !spilled && recordsBuffer.Spilled() {
+				log(logging.Verbose, "\tBuffered rows of file %s exceed %d bytes, spilling them to disk", fe.fileName, fe.memoryLimit)
+			}
 			// Online check if all fields have data
 			onFlyAllFieldsHaveData := true
 			for i, val := range newRecord {
@@ -211,13 +339,13 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 					return fmt.Errorf("error writing header to file %s: %w", fe.fileName, err)
 				}
 				// Write buffered records now
-				for _, bufferedRecord := range recordsBuffer {
-					if err := writeRowFunc(bufferedRecord); err != nil {
-						return err
-					}
+				if err := recordsBuffer.Each(writeRowFunc); err != nil {
+					return err
 				}
 				// Clear buffer
-				recordsBuffer = nil
+				if err := recordsBuffer.Close(); err != nil {
+					return fmt.Errorf("error removing spill file of file %s: %w", fe.fileName, err)
+				}
 
 				log(logging.EvenMoreVerbose, "\tFinished writing buffered records for file: %s", fe.fileName)
 			}
@@ -227,14 +355,9 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 				return err
 			}
 		}
-
-		record, err = fe.iteratorEntryParser(rowIterator())
-		if err != nil {
-			return err
-		}
-		// if record == nil {
-		// 	break
-		// }
+	}
+	if readErr != nil {
+		return readErr
 	}
 
 	if fe.excludeEmptyFields && !allFieldsHaveData {
@@ -248,6 +371,11 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 
 		// Filter header
 		finalHeader := internal.ApplyBoolMaskToSlice(newHeader, finalFieldMask)
+		if rowsWritten == 0 {
+			// Every row was filtered out, so no field has data. Keep the header as without excluding empty fields
+			// instead of writing an empty one
+			finalHeader = newHeader
+		}
 
 		// Write final header
 		if err := writeRowFunc(finalHeader); err != nil {
@@ -255,17 +383,23 @@ func (fe *FileExtractor) Run(fileReader io.Reader, writerCreate func() (io.Write
 		}
 
 		// Filter and write buffered records
-		for _, bufferedRecord := range recordsBuffer {
-			finalRecord := internal.ApplyBoolMaskToSlice(bufferedRecord, finalFieldMask)
-			if err := writeRowFunc(finalRecord); err != nil {
-				return err
-			}
+		err := recordsBuffer.Each(func(bufferedRecord []string) error {
+			return writeRowFunc(internal.ApplyBoolMaskToSlice(bufferedRecord, finalFieldMask))
+		})
+		if err != nil {
+			return err
 		}
 
 		log(logging.EvenMoreVerbose, "\tFinished writing filtered records for file: %s", fe.fileName)
 	}
 
-	log(logging.Verbose, "Finished processing file: %s, rows written: %d", fe.fileName, rowsRead)
+	// Write errors of buffered rows only show up when flushing, which also writes sorted rows
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return fmt.Errorf("error writing file %s: %w", fe.fileName, err)
+	}
+
+	log(logging.Verbose, "Finished processing file: %s, rows read: %d, rows written: %d", fe.fileName, rowsRead, rowsWritten)
 	return nil
 }
 
@@ -275,7 +409,7 @@ type iteratorEntry struct {
 }
 
 // readerRowsIterator returns an iterator over the rows the input CSV file.
-func readerRowsIterator(src *csv.Reader) iter.Seq[*iteratorEntry] {
+func readerRowsIterator(src *Reader) iter.Seq[*iteratorEntry] {
 	return func(yield func(*iteratorEntry) bool) {
 		for {
 			record, err := src.Read()
@@ -304,7 +438,7 @@ func (fe *FileExtractor) iteratorEntryParser(entry *iteratorEntry, ok bool) ([]s
 	case entry == nil && ok:
 		// should not happen
 		return nil, fmt.Errorf("unexpected nil entry when reading data from file %s", fe.fileName)
-	case entry.err != nil && (entry.err == io.EOF || len(entry.record) == 0):
+	case entry.err == io.EOF:
 		// No more data, iterator exhausted
 		return nil, nil
 	case entry.err != nil:
@@ -319,17 +453,44 @@ func (fe *FileExtractor) iteratorEntryParser(entry *iteratorEntry, ok bool) ([]s
 	}
 }
 
-// returns the mask of indices to include from the original header
+// RenameFields returns a copy of header with the fields renamed from old to new names.
+// Renames of fields that are not in the header are ignored. It is an error if a new name is already used
+// by another field of the header.
+func RenameFields(header []string, renames map[string]string) ([]string, error) {
+	if len(renames) == 0 {
+		return header, nil
+	}
+	renamed := slices.Clone(header)
+	for i, fieldName := range header {
+		if newName, ok := renames[fieldName]; ok {
+			renamed[i] = newName
+		}
+	}
+	for i, fieldName := range renamed {
+		if fieldName == header[i] {
+			continue
+		}
+		if slices.Index(renamed, fieldName) != i || slices.Index(renamed[i+1:], fieldName) >= 0 {
+			return nil, fmt.Errorf("%w: %s renamed to %s, which is already a field", params.ErrRenameCollision, header[i], fieldName)
+		}
+	}
+	return renamed, nil
+}
+
+// GenerateFieldMapping returns the mask of the fields of extractedHeader to include, and how many are included.
+// includedFields and excludedFields are field name patterns, globs or /regexps/. A field matching an included
+// pattern is always included; otherwise a field matching an excluded pattern is not, and if there are included
+// patterns, neither is any other field.
 func GenerateFieldMapping(extractedHeader []string, includedFields, excludedFields []string) ([]bool, int) {
 	// decider determines whether a given field in a file should be included based on the Extractor's parameters.
 	shouldFieldBeIncluded := func(fieldName string) bool {
 		// Check inclusion first, since inclusion takes precedence over exclusion
-		if slices.Contains(includedFields, fieldName) {
+		if pattern.MatchAny(includedFields, fieldName) {
 			return true
 		}
 
 		// Then check exclusion
-		if slices.Contains(excludedFields, fieldName) {
+		if pattern.MatchAny(excludedFields, fieldName) {
 			return false
 		}
 
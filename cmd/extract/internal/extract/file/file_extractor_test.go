@@ -1,11 +1,21 @@
 package file_test
 
 import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
 	"io"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/file"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/predicate"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/transform"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/columnorder"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
 )
 
@@ -98,6 +108,28 @@ func TestGenerateFieldMapping(t *testing.T) {
 			want:  []bool{true, true, true, true, true},
 			want2: 5,
 		},
+		{
+			name:            "include glob",
+			extractedHeader: []string{"stop_id", "stop_name", "stop_desc", "stop_lat", "parent_station"},
+			includedFields:  []string{"stop_*"},
+			want:            []bool{true, true, true, true, false},
+			want2:           4,
+		},
+		{
+			name:            "exclude glob and regexp",
+			extractedHeader: []string{"agency_id", "agency_url", "agency_fare_url", "agency_lang", "agency_phone"},
+			excludedFields:  []string{"*_url", "/^agency_(lang|phone)$/"},
+			want:            []bool{true, false, false, false, false},
+			want2:           1,
+		},
+		{
+			name:            "inclusion takes precedence over exclusion glob",
+			extractedHeader: []string{"agency_id", "agency_url", "agency_fare_url"},
+			includedFields:  []string{"agency_id", "agency_url"},
+			excludedFields:  []string{"*_url"},
+			want:            []bool{true, true, false},
+			want2:           2,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -112,3 +144,472 @@ func TestGenerateFieldMapping(t *testing.T) {
 		})
 	}
 }
+
+func TestFileExtractor_Run_Where(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	input := "route_id,route_short_name,route_type,route_color\n" +
+		"1,6,3,FF0000\n" +
+		"2,C,0,00FF00\n" +
+		"3,11,3,0000FF\n"
+
+	where, err := predicate.Parse("route_type=3 && route_short_name!=11")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	// route_type is filtered on, but not written out
+	fe := file.NewFileExtractorAll("routes.txt", reporter, false, false, nil, []string{"route_type"}).
+		WithRowProcessors(where)
+
+	var out bytes.Buffer
+	err = fe.Run(strings.NewReader(input), func() (io.Writer, func()) {
+		return &out, func() {}
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	want := "route_id,route_short_name,route_color\n1,6,FF0000\n"
+	if out.String() != want {
+		t.Errorf("Run() output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestFileExtractor_Run_WhereExcludeEmptyFieldsNoRows(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	input := "route_id,route_short_name,route_type\n" +
+		"1,6,3\n" +
+		"2,C,0\n"
+
+	where, err := predicate.Parse("route_type=7")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	fe := file.NewFileExtractorAll("routes.txt", reporter, false, true, nil, nil).
+		WithRowProcessors(where)
+
+	var out bytes.Buffer
+	err = fe.Run(strings.NewReader(input), func() (io.Writer, func()) {
+		return &out, func() {}
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	// No rows are left to find empty fields in, so the header is kept
+	want := "route_id,route_short_name,route_type\n"
+	if out.String() != want {
+		t.Errorf("Run() output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestFileExtractor_Run_ExcludeEmptyFieldsSpill(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	input := "trip_id,stop_id,stop_sequence,pickup_type,stop_headsign\n" +
+		"T1,P1,1,,\n" +
+		"T1,P2,2,,\"Bavarski dvor, Ljubljana\"\n" +
+		"T2,P2,1,,\n" +
+		"T2,P3,2,,\n"
+	want := "trip_id,stop_id,stop_sequence,stop_headsign\n" +
+		"T1,P1,1,\n" +
+		"T1,P2,2,\"Bavarski dvor, Ljubljana\"\n" +
+		"T2,P2,1,\n" +
+		"T2,P3,2,\n"
+
+	// all fields have data in the last row, so nothing is excluded
+	inputAllData := input + "T3,P4,1,1,Zalog\n"
+	wantAllData := inputAllData
+
+	tests := []struct {
+		name        string
+		input       string
+		want        string
+		memoryLimit int64
+	}{
+		{name: "in memory", input: input, want: want, memoryLimit: 1 << 20},
+		{name: "spilled after a few rows", input: input, want: want, memoryLimit: 100},
+		{name: "spilled from the first row", input: input, want: want, memoryLimit: 0},
+		{name: "spilled until all fields have data", input: inputAllData, want: wantAllData, memoryLimit: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fe := file.NewFileExtractorAll("stop_times.txt", reporter, false, true, nil, nil).
+				WithMemoryLimit(tt.memoryLimit)
+
+			var out bytes.Buffer
+			err := fe.Run(strings.NewReader(tt.input), func() (io.Writer, func()) {
+				return &out, func() {}
+			})
+			if err != nil {
+				t.Fatalf("Run() failed: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("Run() output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameFields(t *testing.T) {
+	header := []string{"stop_id", "stop_lat_wgs", "stop_lon_wgs", "stop_name"}
+	tests := []struct {
+		name    string
+		renames map[string]string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "rename",
+			renames: map[string]string{"stop_lat_wgs": "stop_lat", "stop_lon_wgs": "stop_lon"},
+			want:    []string{"stop_id", "stop_lat", "stop_lon", "stop_name"},
+		},
+		{
+			name:    "field not in header is ignored",
+			renames: map[string]string{"stop_desc": "stop_description"},
+			want:    header,
+		},
+		{
+			name:    "swap",
+			renames: map[string]string{"stop_lat_wgs": "stop_lon_wgs", "stop_lon_wgs": "stop_lat_wgs"},
+			want:    []string{"stop_id", "stop_lon_wgs", "stop_lat_wgs", "stop_name"},
+		},
+		{
+			name:    "collision with existing field",
+			renames: map[string]string{"stop_lat_wgs": "stop_name"},
+			wantErr: params.ErrRenameCollision,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := file.RenameFields(header, tt.renames)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RenameFields() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("RenameFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileExtractor_Run_RenamedFields(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	input := "stop_id,stop_lat_wgs,stop_lon_wgs,stop_code\n1,46.05,14.50,101\n"
+
+	where, err := predicate.Parse("stop_lat>46")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	// Included fields and row processors use the new names
+	fe := file.NewFileExtractorAll("stops.txt", reporter, false, false, []string{"stop_id", "stop_lat", "stop_lon"}, nil).
+		WithRenamedFields(map[string]string{"stop_lat_wgs": "stop_lat", "stop_lon_wgs": "stop_lon"}).
+		WithRowProcessors(where)
+
+	var out bytes.Buffer
+	err = fe.Run(strings.NewReader(input), func() (io.Writer, func()) {
+		return &out, func() {}
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	want := "stop_id,stop_lat,stop_lon\n1,46.05,14.50\n"
+	if out.String() != want {
+		t.Errorf("Run() output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestFileExtractor_Run_TransformsBeforeExcludeEmptyFields(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	input := "stop_id,stop_name,stop_desc,wheelchair_boarding\n" +
+		" P1 ,Konzorcij,Near the bank,\n" +
+		"P2,Bavarski dvor,,1\n"
+
+	var processors []rows.Processor
+	for _, op := range [][2]string{
+		{"stop_id", "trim"},
+		{"stop_desc", "null"},
+		{"wheelchair_boarding", "default,0"},
+	} {
+		tr, err := transform.Parse(op[0], op[1])
+		if err != nil {
+			t.Fatalf("Parse() failed: %v", err)
+		}
+		processors = append(processors, tr)
+	}
+	// stop_desc is emptied and excluded, wheelchair_boarding is filled and kept
+	fe := file.NewFileExtractorAll("stops.txt", reporter, false, true, nil, nil).
+		WithRowProcessors(processors...)
+
+	var out bytes.Buffer
+	err := fe.Run(strings.NewReader(input), func() (io.Writer, func()) {
+		return &out, func() {}
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	want := "stop_id,stop_name,wheelchair_boarding\nP1,Konzorcij,0\nP2,Bavarski dvor,1\n"
+	if out.String() != want {
+		t.Errorf("Run() output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestFileExtractor_Run_MalformedRows(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	tests := []struct {
+		name        string
+		input       string
+		strict      bool
+		want        string
+		wantRejects []file.Reject
+		wantErr     error
+	}{
+		{
+			name:  "ragged rows are skipped",
+			input: "stop_id,stop_name\r\nP1,Konzorcij\r\nP2\r\nP3,Bavarski dvor,extra\r\nP4,Kolodvor\r\n",
+			want:  "stop_id,stop_name\nP1,Konzorcij\nP4,Kolodvor\n",
+			wantRejects: []file.Reject{
+				{File: "stops.txt", Line: 3, Content: "P2", Reason: "wrong number of fields: 1 instead of 2"},
+				{File: "stops.txt", Line: 4, Content: "P3,Bavarski dvor,extra", Reason: "wrong number of fields: 3 instead of 2"},
+			},
+		},
+		{
+			name:  "multiline rejected row",
+			input: "stop_id,stop_name\nP1,\"Konzorcij\nCenter\",x\nP2,Kolodvor\n",
+			want:  "stop_id,stop_name\nP2,Kolodvor\n",
+			wantRejects: []file.Reject{
+				{File: "stops.txt", Line: 2, Content: "P1,\"Konzorcij\nCenter\",x", Reason: "wrong number of fields: 3 instead of 2"},
+			},
+		},
+		{
+			name:  "stray quotes are kept",
+			input: "stop_id,stop_name\nP1,Gostilna \"Pri Mihcu\"\n",
+			want:  "stop_id,stop_name\nP1,\"Gostilna \"\"Pri Mihcu\"\"\"\n",
+		},
+		{
+			name:  "stray quote in quoted field doesn't swallow rows",
+			input: "stop_id,stop_name\nP1,\"Gostilna \"Pri\"\",x\nP2,Kolodvor\n",
+			want:  "stop_id,stop_name\nP2,Kolodvor\n",
+			wantRejects: []file.Reject{
+				{File: "stops.txt", Line: 2, Content: "P1,\"Gostilna \"Pri\"\",x", Reason: "extraneous or missing \" in quoted-field"},
+			},
+		},
+		{
+			name:    "strict ragged row",
+			input:   "stop_id,stop_name\nP1,Konzorcij\nP2\n",
+			strict:  true,
+			wantErr: csv.ErrFieldCount,
+		},
+		{
+			name:    "strict stray quote",
+			input:   "stop_id,stop_name\nP1,Gostilna \"Pri Mihcu\"\n",
+			strict:  true,
+			wantErr: csv.ErrBareQuote,
+		},
+		{
+			name:    "strict missing quote",
+			input:   "stop_id,stop_name\nP1,\"Konzorcij\nP2,Kolodvor\n",
+			strict:  true,
+			wantErr: csv.ErrQuote,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rejects []file.Reject
+			fe := file.NewFileExtractorAll("stops.txt", reporter, false, false, nil, nil).
+				WithStrict(tt.strict).
+				WithRejectHandler(func(r file.Reject) { rejects = append(rejects, r) })
+
+			var out bytes.Buffer
+			err := fe.Run(strings.NewReader(tt.input), func() (io.Writer, func()) {
+				return &out, func() {}
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if out.String() != tt.want {
+				t.Errorf("Run() output = %q, want %q", out.String(), tt.want)
+			}
+			if !slices.Equal(rejects, tt.wantRejects) {
+				t.Errorf("Run() rejects = %+v, want %+v", rejects, tt.wantRejects)
+			}
+		})
+	}
+}
+
+func TestFileExtractor_Run_WriteError(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	errFull := errors.New("disk full")
+	fe := file.NewFileExtractorAll("stops.txt", reporter, false, false, nil, nil)
+	err := fe.Run(strings.NewReader("stop_id,stop_name\nP1,Konzorcij\n"), func() (io.Writer, func()) {
+		return failingWriter{errFull}, func() {}
+	})
+	if !errors.Is(err, errFull) {
+		t.Errorf("Run() error = %v, want %v", err, errFull)
+	}
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+func TestFileExtractor_Run_ColumnOrder(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	input := "stop_lon,stop_name,stop_desc,stop_id,stop_lat\n" +
+		"14.5,Konzorcij,,P1,46.05\n" +
+		"14.6,Kolodvor,,P2,46.06\n"
+
+	tests := []struct {
+		name   string
+		mode   string
+		custom []string
+		want   string
+	}{
+		{
+			name: "input",
+			mode: columnorder.Input,
+			want: "stop_lon,stop_name,stop_id,stop_lat\n14.5,Konzorcij,P1,46.05\n14.6,Kolodvor,P2,46.06\n",
+		},
+		{
+			name: "canonical",
+			mode: columnorder.Canonical,
+			want: "stop_id,stop_name,stop_lat,stop_lon\nP1,Konzorcij,46.05,14.5\nP2,Kolodvor,46.06,14.6\n",
+		},
+		{
+			name:   "custom",
+			mode:   columnorder.Custom,
+			custom: []string{"stops.txt,stop_name,stop_id"},
+			want:   "stop_name,stop_id,stop_lon,stop_lat\nKonzorcij,P1,14.5,46.05\nKolodvor,P2,14.6,46.06\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := columnorder.Parse(tt.mode, tt.custom)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			// The empty stop_desc is removed after reordering
+			fe := file.NewFileExtractorAll("stops.txt", reporter, false, true, nil, nil).
+				WithColumnOrder(order)
+
+			var out bytes.Buffer
+			err = fe.Run(strings.NewReader(input), func() (io.Writer, func()) {
+				return &out, func() {}
+			})
+			if err != nil {
+				t.Fatalf("Run() failed: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("Run() output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFileExtractor_Run_Sort(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	input := "trip_id,stop_id,stop_sequence,pickup_type\n" +
+		"T2,P3,10,\n" +
+		"T1,P2,2,\n" +
+		"T2,P2,9,\n" +
+		"T1,P1,1,\n" +
+		"T1,P9,,\n" +
+		"T2,P5,10,\n"
+	// The row without stop_sequence first, and T2,P3 before T2,P5 as in the input
+	want := "trip_id,stop_id,stop_sequence\n" +
+		"T1,P9,\n" +
+		"T1,P1,1\n" +
+		"T1,P2,2\n" +
+		"T2,P2,9\n" +
+		"T2,P3,10\n" +
+		"T2,P5,10\n"
+	keys := []params.SortKey{{Field: "trip_id"}, {Field: "stop_sequence", Numeric: true}}
+
+	tests := []struct {
+		name               string
+		excludeEmptyFields bool
+		memoryLimit        int64
+	}{
+		{name: "in memory", memoryLimit: 1 << 20},
+		{name: "runs on disk", memoryLimit: 100},
+		{name: "a run per row", memoryLimit: 0},
+		{name: "runs on disk with empty fields excluded", excludeEmptyFields: true, memoryLimit: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// pickup_type is empty, removed either way
+			excluded := []string{"pickup_type"}
+			if tt.excludeEmptyFields {
+				excluded = nil
+			}
+			fe := file.NewFileExtractorAll("stop_times.txt", reporter, false, tt.excludeEmptyFields, nil, excluded).
+				WithMemoryLimit(tt.memoryLimit).
+				WithSortKeys(keys)
+
+			var out bytes.Buffer
+			err := fe.Run(strings.NewReader(input), func() (io.Writer, func()) {
+				return &out, func() {}
+			})
+			if err != nil {
+				t.Fatalf("Run() failed: %v", err)
+			}
+			if out.String() != want {
+				t.Errorf("Run() output = %q, want %q", out.String(), want)
+			}
+		})
+	}
+}
+
+// A run per row, more runs than are merged at once
+func TestFileExtractor_Run_SortManyRuns(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	var input, want strings.Builder
+	input.WriteString("trip_id,stop_sequence,pickup_type\n")
+	want.WriteString("trip_id,stop_sequence,pickup_type\n")
+	const rows = 300
+	for i := range rows {
+		// Sequences count down and repeat, pickup_type keeps the input order of equal rows visible
+		fmt.Fprintf(&input, "T%d,%d,%d\n", i%2, (rows-i)/4, i)
+	}
+	for trip := range 2 {
+		for seq := 0; seq <= rows/4; seq++ {
+			for i := range rows {
+				if i%2 == trip && (rows-i)/4 == seq {
+					fmt.Fprintf(&want, "T%d,%d,%d\n", trip, seq, i)
+				}
+			}
+		}
+	}
+	fe := file.NewFileExtractorAll("stop_times.txt", reporter, false, false, nil, nil).
+		WithMemoryLimit(0).
+		WithSortKeys([]params.SortKey{{Field: "trip_id"}, {Field: "stop_sequence", Numeric: true}})
+	var out bytes.Buffer
+	err := fe.Run(strings.NewReader(input.String()), func() (io.Writer, func()) {
+		return &out, func() {}
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if out.String() != want.String() {
+		t.Errorf("Run() output = %q, want %q", out.String(), want.String())
+	}
+}
+
+func TestFileExtractor_Run_SortFieldExcluded(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	fe := file.NewFileExtractorAll("stops.txt", reporter, false, false, nil, []string{"stop_name"}).
+		WithSortKeys([]params.SortKey{{Field: "stop_name"}})
+	err := fe.Run(strings.NewReader("stop_id,stop_name\nP1,Konzorcij\n"), func() (io.Writer, func()) {
+		return io.Discard, func() {}
+	})
+	if err == nil {
+		t.Errorf("Run() succeeded, want an error for the excluded sort field")
+	}
+}
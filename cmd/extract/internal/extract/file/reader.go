@@ -0,0 +1,124 @@
+package file
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Reject is a row of an input file that was skipped because it couldn't be read.
+type Reject struct {
+	File string `json:"file"`
+	// Line of the input file the row starts on, counting from 1
+	Line int `json:"line"`
+	// The row as it is in the input file, without the line ending
+	Content string `json:"content"`
+	Reason  string `json:"reason"`
+}
+
+// Reader reads the rows of a GTFS file. In strict mode any malformed row is an error, otherwise malformed
+// rows, like rows with a different number of fields than the header, are skipped and reported as rejects.
+// Only stray quotes in unquoted fields are kept when not strict, since their fields are still clear.
+type Reader struct {
+	fileName string
+	strict   bool
+	onReject func(Reject)
+
+	csvReader *csv.Reader
+	raw       *rawBuffer
+	// input offset of the end of the last row read
+	offset int64
+	// whether the header was read
+	started bool
+}
+
+// NewReader creates a Reader of the file read from r. onReject is called for every skipped row, it can be nil.
+func NewReader(r io.Reader, fileName string, strict bool, onReject func(Reject)) *Reader {
+	raw := &rawBuffer{r: r}
+	csvReader := csv.NewReader(raw)
+	return &Reader{
+		fileName:  fileName,
+		strict:    strict,
+		onReject:  onReject,
+		csvReader: csvReader,
+		raw:       raw,
+	}
+}
+
+// Read returns the next row, or io.EOF at the end of the file. The first row is the header, every
+// later row must have as many fields as it. A malformed header is an error even if not strict.
+func (r *Reader) Read() ([]string, error) {
+	for {
+		record, err := r.csvReader.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		content := r.raw.take(r.csvReader.InputOffset() - r.offset)
+		r.offset = r.csvReader.InputOffset()
+
+		header := !r.started
+		r.started = true
+		var parseErr *csv.ParseError
+		if err == nil {
+			return record, nil
+		}
+		if r.strict || header || !errors.As(err, &parseErr) {
+			return nil, err
+		}
+
+		if record, ok := r.recover(content, parseErr); ok {
+			return record, nil
+		}
+
+		reason := parseErr.Err.Error()
+		if errors.Is(parseErr.Err, csv.ErrFieldCount) {
+			reason = fmt.Sprintf("%s: %d instead of %d", reason, len(record), r.csvReader.FieldsPerRecord)
+		}
+		if r.onReject != nil {
+			r.onReject(Reject{
+				File:    r.fileName,
+				Line:    parseErr.StartLine,
+				Content: string(bytes.TrimRight(content, "\r\n")),
+				Reason:  reason,
+			})
+		}
+	}
+}
+
+// recover reads a row with a stray quote in an unquoted field, like Gostilna "Pri Mihcu", which is
+// unambiguous. A missing or extra quote in a quoted field isn't, since it can swallow the rows after it.
+func (r *Reader) recover(content []byte, parseErr *csv.ParseError) ([]string, bool) {
+	if !errors.Is(parseErr.Err, csv.ErrBareQuote) {
+		return nil, false
+	}
+	lazyReader := csv.NewReader(bytes.NewReader(content))
+	lazyReader.LazyQuotes = true
+	records, err := lazyReader.ReadAll()
+	if err != nil || len(records) != 1 || len(records[0]) != r.csvReader.FieldsPerRecord {
+		return nil, false
+	}
+	return records[0], true
+}
+
+// rawBuffer keeps the bytes read from r, until they are taken, so a malformed row can be reported as it is.
+type rawBuffer struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (b *rawBuffer) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.buf = append(b.buf, p[:n]...)
+	return n, err
+}
+
+// take removes and returns the first n bytes not taken yet.
+func (b *rawBuffer) take(n int64) []byte {
+	n = min(n, int64(len(b.buf)))
+	taken := b.buf[:n]
+	// The csv.Reader reads ahead, the rest of the buffer belongs to later rows
+	b.buf = b.buf[n:]
+	return taken
+}
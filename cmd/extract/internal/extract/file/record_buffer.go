@@ -0,0 +1,127 @@
+package file
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// Approximate memory overhead of a buffered record and of each of its fields (slice and string headers)
+const (
+	recordOverhead = 24
+	fieldOverhead  = 16
+)
+
+// recordBuffer holds records in memory until they take more than limit bytes. Then all records are
+// spilled to a gzip compressed temporary file, and the following records are written there directly.
+type recordBuffer struct {
+	limit int64
+	size  int64
+
+	records [][]string
+
+	// set once spilled
+	spillFile *os.File
+	gzip      *gzip.Writer
+	csv       *csv.Writer
+}
+
+func newRecordBuffer(limit int64) *recordBuffer {
+	return &recordBuffer{limit: limit}
+}
+
+// Add appends a copy of record to the buffer.
+func (b *recordBuffer) Add(record []string) error {
+	if b.spillFile != nil {
+		return b.csv.Write(record)
+	}
+	b.records = append(b.records, slices.Clone(record))
+	b.size += recordOverhead
+	for _, field := range record {
+		b.size += fieldOverhead + int64(len(field))
+	}
+	if b.size > b.limit {
+		return b.spill()
+	}
+	return nil
+}
+
+// Spilled reports whether the records are on disk.
+func (b *recordBuffer) Spilled() bool {
+	return b.spillFile != nil
+}
+
+func (b *recordBuffer) spill() error {
+	f, err := os.CreateTemp("", "gtfs-tool-*.csv.gz")
+	if err != nil {
+		return fmt.Errorf("error creating spill file: %w", err)
+	}
+	b.spillFile = f
+	b.gzip = gzip.NewWriter(f)
+	b.csv = csv.NewWriter(b.gzip)
+	if err := b.csv.WriteAll(b.records); err != nil {
+		return fmt.Errorf("error writing spill file: %w", err)
+	}
+	b.records = nil
+	b.size = 0
+	return nil
+}
+
+// Each calls fn for every record in the order they were added. It must be called at most once,
+// since reading spilled records finishes the spill file.
+func (b *recordBuffer) Each(fn func(record []string) error) error {
+	if b.spillFile == nil {
+		for _, record := range b.records {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	b.csv.Flush()
+	if err := b.csv.Error(); err != nil {
+		return fmt.Errorf("error writing spill file: %w", err)
+	}
+	if err := b.gzip.Close(); err != nil {
+		return fmt.Errorf("error writing spill file: %w", err)
+	}
+	if _, err := b.spillFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error rewinding spill file: %w", err)
+	}
+	gzipReader, err := gzip.NewReader(b.spillFile)
+	if err != nil {
+		return fmt.Errorf("error reading spill file: %w", err)
+	}
+	defer gzipReader.Close()
+	csvReader := csv.NewReader(gzipReader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.ReuseRecord = true
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading spill file: %w", err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// Close releases the records and removes the spill file, if any.
+func (b *recordBuffer) Close() error {
+	b.records = nil
+	if b.spillFile == nil {
+		return nil
+	}
+	b.spillFile.Close()
+	err := os.Remove(b.spillFile.Name())
+	b.spillFile = nil
+	return err
+}
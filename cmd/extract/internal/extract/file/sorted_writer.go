@@ -0,0 +1,315 @@
+package file
+
+import (
+	"cmp"
+	"compress/gzip"
+	"container/heap"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+)
+
+// maxMergeRuns is the most runs merged at once, so merging doesn't run out of file descriptors.
+// More runs are merged in passes, each merging the first runs into a single run.
+const maxMergeRuns = 64
+
+// rowWriter writes the rows of an output file, the header first. A csv.Writer is one.
+type rowWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+// sortedWriter is a rowWriter that sorts the rows after the header by the sort keys, and writes them
+// to w when flushed. Rows are sorted in memory until they take more than limit bytes, then the sorted run
+// is spilled to a gzip compressed temporary file. Flushing merges the runs, so files larger than memory
+// can be sorted. Rows with equal keys keep their order.
+type sortedWriter struct {
+	w        *csv.Writer
+	fileName string
+	keys     []params.SortKey
+	limit    int64
+
+	// nil until the header is written
+	keyIndices []int
+
+	rows [][]string
+	size int64
+	// names of the spilled runs, in the order the rows were written
+	runs []string
+
+	flushed bool
+	err     error
+}
+
+func newSortedWriter(w *csv.Writer, fileName string, keys []params.SortKey, limit int64) *sortedWriter {
+	return &sortedWriter{w: w, fileName: fileName, keys: keys, limit: limit}
+}
+
+func (s *sortedWriter) Write(record []string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.keyIndices == nil {
+		s.err = s.writeHeader(record)
+		return s.err
+	}
+	s.rows = append(s.rows, slices.Clone(record))
+	s.size += recordOverhead
+	for _, field := range record {
+		s.size += fieldOverhead + int64(len(field))
+	}
+	if s.size > s.limit {
+		s.err = s.spill()
+	}
+	return s.err
+}
+
+func (s *sortedWriter) writeHeader(header []string) error {
+	s.keyIndices = make([]int, len(s.keys))
+	for i, key := range s.keys {
+		s.keyIndices[i] = slices.Index(header, key.Field)
+		if s.keyIndices[i] < 0 {
+			return fmt.Errorf("sort field %s is not in the output of file %s", key.Field, s.fileName)
+		}
+	}
+	return s.w.Write(header)
+}
+
+// Flush writes the sorted rows and removes the spilled runs. Rows written after flushing are lost.
+func (s *sortedWriter) Flush() {
+	if !s.flushed {
+		s.flushed = true
+		if s.err == nil {
+			s.err = s.merge()
+		}
+		s.removeRuns()
+	}
+	s.w.Flush()
+}
+
+func (s *sortedWriter) Error() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.w.Error()
+}
+
+func (s *sortedWriter) compare(a, b []string) int {
+	for i, key := range s.keys {
+		if c := compareValues(a[s.keyIndices[i]], b[s.keyIndices[i]], key.Numeric); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues compares values as text, or as numbers if numeric. Values that are not numbers are
+// ordered after numbers, empty values first.
+func compareValues(a, b string, numeric bool) int {
+	if !numeric || a == "" || b == "" {
+		return cmp.Compare(a, b)
+	}
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(x, y)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	default:
+		return cmp.Compare(a, b)
+	}
+}
+
+// spill sorts the rows in memory and writes them to a new run.
+func (s *sortedWriter) spill() error {
+	slices.SortStableFunc(s.rows, s.compare)
+	name, err := s.writeRun(func(write func([]string) error) error {
+		for _, row := range s.rows {
+			if err := write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, name)
+	s.rows = nil
+	s.size = 0
+	return nil
+}
+
+// writeRun writes the rows passed to write by fill to a new gzip compressed temporary file, and returns its name.
+func (s *sortedWriter) writeRun(fill func(write func([]string) error) error) (string, error) {
+	f, err := os.CreateTemp("", "gtfs-tool-sort-*.csv.gz")
+	if err != nil {
+		return "", fmt.Errorf("error creating sort run of file %s: %w", s.fileName, err)
+	}
+	gzipWriter := gzip.NewWriter(f)
+	csvWriter := csv.NewWriter(gzipWriter)
+	err = fill(csvWriter.Write)
+	if err == nil {
+		csvWriter.Flush()
+		err = csvWriter.Error()
+	}
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error writing sort run of file %s: %w", s.fileName, err)
+	}
+	return f.Name(), nil
+}
+
+// merge writes the rows in order. Without runs the rows are sorted in memory, otherwise the rows left
+// in memory are spilled too, and the runs are merged.
+func (s *sortedWriter) merge() error {
+	if len(s.runs) == 0 {
+		slices.SortStableFunc(s.rows, s.compare)
+		for _, row := range s.rows {
+			if err := s.w.Write(row); err != nil {
+				return err
+			}
+		}
+		s.rows = nil
+		return nil
+	}
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	for len(s.runs) > maxMergeRuns {
+		// The merged run replaces the first runs, so it stays before the later rows
+		first := s.runs[:maxMergeRuns]
+		name, err := s.writeRun(func(write func([]string) error) error {
+			return s.mergeRuns(first, write)
+		})
+		if err != nil {
+			return err
+		}
+		for _, f := range first {
+			os.Remove(f)
+		}
+		s.runs = append([]string{name}, s.runs[maxMergeRuns:]...)
+	}
+	return s.mergeRuns(s.runs, s.w.Write)
+}
+
+// mergeRuns passes the rows of the runs to write in order.
+func (s *sortedWriter) mergeRuns(names []string, write func([]string) error) error {
+	h := &runHeap{compare: s.compare}
+	for i, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("error opening sort run of file %s: %w", s.fileName, err)
+		}
+		defer f.Close()
+		gzipReader, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("error reading sort run of file %s: %w", s.fileName, err)
+		}
+		defer gzipReader.Close()
+		csvReader := csv.NewReader(gzipReader)
+		csvReader.FieldsPerRecord = -1
+		r := &run{index: i, reader: csvReader}
+		if ok, err := r.next(); err != nil {
+			return fmt.Errorf("error reading sort run of file %s: %w", s.fileName, err)
+		} else if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		r := h.runs[0]
+		if err := write(r.row); err != nil {
+			return err
+		}
+		ok, err := r.next()
+		if err != nil {
+			return fmt.Errorf("error reading sort run of file %s: %w", s.fileName, err)
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+func (s *sortedWriter) removeRuns() {
+	for _, name := range s.runs {
+		os.Remove(name)
+	}
+	s.runs = nil
+	s.rows = nil
+}
+
+// run is a sorted run being merged, with its current row.
+type run struct {
+	index  int
+	reader *csv.Reader
+	row    []string
+}
+
+// next reads the next row of the run, and reports whether there was one.
+func (r *run) next() (bool, error) {
+	row, err := r.reader.Read()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	r.row = row
+	return true, nil
+}
+
+// runHeap orders runs by their current rows. Runs of equal rows are ordered by their index, so rows with
+// equal keys keep the order they were written in, since earlier runs hold earlier rows.
+type runHeap struct {
+	runs    []*run
+	compare func(a, b []string) int
+}
+
+func (h *runHeap) Len() int {
+	return len(h.runs)
+}
+
+func (h *runHeap) Less(i, j int) bool {
+	if c := h.compare(h.runs[i].row, h.runs[j].row); c != 0 {
+		return c < 0
+	}
+	return h.runs[i].index < h.runs[j].index
+}
+
+func (h *runHeap) Swap(i, j int) {
+	h.runs[i], h.runs[j] = h.runs[j], h.runs[i]
+}
+
+func (h *runHeap) Push(x any) {
+	h.runs = append(h.runs, x.(*run))
+}
+
+func (h *runHeap) Pop() any {
+	last := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return last
+}
@@ -0,0 +1,4 @@
+// Package geo restricts a GTFS feed to a geographic area.
+// Trips that visit a stop inside the area are kept, optionally truncated to the part of the trip
+// inside the area, together with everything they reference.
+package geo
@@ -0,0 +1,133 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/subset"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/geometry"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+var ErrNothingInArea = errors.New("no trips visit a stop inside the area")
+
+// segment is the range of stop sequences of a trip inside the area
+type segment struct {
+	first, last int
+}
+
+// Apply keeps the trips visiting stops inside the area, and everything they reference.
+// If clipTrips is set, the stop times of each trip are truncated to the segment between
+// its first and last stop inside the area.
+func Apply(f *feed.Feed, area geometry.Area, clipTrips bool, log logging.LogReporter) error {
+	inArea, err := stopsInArea(f, area)
+	if err != nil {
+		return err
+	}
+
+	segments := map[string]segment{}
+	err = f.ReadTable("stop_times.txt", func(row feed.Row) error {
+		if !inArea.Has(row.Get("stop_id")) {
+			return nil
+		}
+		tripID := row.Get("trip_id")
+		seq, err := strconv.Atoi(row.Get("stop_sequence"))
+		if err != nil {
+			return fmt.Errorf("invalid stop_sequence of trip %s in stop_times.txt: %w", tripID, err)
+		}
+		s, ok := segments[tripID]
+		if !ok {
+			s = segment{first: seq, last: seq}
+		}
+		s.first = min(s.first, seq)
+		s.last = max(s.last, seq)
+		segments[tripID] = s
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if clipTrips {
+		// A truncated trip needs at least two stops to stay valid
+		for tripID, s := range segments {
+			if s.first == s.last {
+				delete(segments, tripID)
+			}
+		}
+	}
+	if len(segments) == 0 {
+		return ErrNothingInArea
+	}
+
+	log(logging.Verbose, "Area contains %d stops, visited by %d trips", len(inArea), len(segments))
+
+	// Must be registered before the subset, so only stops on the segments are kept
+	if clipTrips {
+		f.AddProcessors("stop_times.txt", clipStopTimes(segments))
+	}
+
+	trips := make([]string, 0, len(segments))
+	for tripID := range segments {
+		trips = append(trips, tripID)
+	}
+	_, err = subset.Apply(f, subset.Selection{Trips: trips}, log)
+	return err
+}
+
+// stopsInArea returns the stops inside the area. Stops without coordinates are inside
+// the area if their parent station is.
+func stopsInArea(f *feed.Feed, area geometry.Area) (rows.KeySet, error) {
+	inArea := rows.KeySet{}
+	withoutCoordinates := map[string]string{}
+	err := f.ReadTable("stops.txt", func(row feed.Row) error {
+		stopID := row.Get("stop_id")
+		lat, latErr := strconv.ParseFloat(row.Get("stop_lat"), 64)
+		lon, lonErr := strconv.ParseFloat(row.Get("stop_lon"), 64)
+		if latErr != nil || lonErr != nil {
+			withoutCoordinates[stopID] = row.Get("parent_station")
+			return nil
+		}
+		if area.Contains(lon, lat) {
+			inArea.Add(stopID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for stopID, parent := range withoutCoordinates {
+		if inArea.Has(parent) {
+			inArea.Add(stopID)
+		}
+	}
+	return inArea, nil
+}
+
+// clipStopTimes keeps the stop times inside the segment of their trip.
+func clipStopTimes(segments map[string]segment) rows.Processor {
+	return rows.ProcessorFunc(func(header []string) (rows.Func, error) {
+		tripIdx := slices.Index(header, "trip_id")
+		seqIdx := slices.Index(header, "stop_sequence")
+		if tripIdx < 0 || seqIdx < 0 {
+			return nil, fmt.Errorf("stop_times.txt must have trip_id and stop_sequence fields")
+		}
+		return func(record []string) (bool, error) {
+			if len(record) != len(header) {
+				return false, nil
+			}
+			s, ok := segments[record[tripIdx]]
+			if !ok {
+				return false, nil
+			}
+			seq, err := strconv.Atoi(record[seqIdx])
+			if err != nil {
+				return false, fmt.Errorf("invalid stop_sequence %q in stop_times.txt: %w", record[seqIdx], err)
+			}
+			return seq >= s.first && seq <= s.last, nil
+		}, nil
+	})
+}
@@ -0,0 +1,98 @@
+package geo_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed/feedtest"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/geo"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/geometry"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+// Stops A, B and C are in Ljubljana, D is in Maribor
+var testFeed = map[string]string{
+	"agency.txt": "agency_id,agency_name\nA1,LPP\n",
+	"routes.txt": "route_id,agency_id\nCITY,A1\nINTERCITY,A1\nREMOTE,A1\n",
+	"trips.txt":  "route_id,service_id,trip_id\nCITY,WD,T1\nINTERCITY,WD,T2\nREMOTE,WD,T3\n",
+	"stop_times.txt": "trip_id,stop_id,stop_sequence\n" +
+		"T1,A,1\nT1,B,2\n" +
+		"T2,C,1\nT2,B,2\nT2,D,3\n" +
+		"T3,D,1\nT3,E,2\n",
+	"stops.txt": "stop_id,stop_lat,stop_lon\n" +
+		"A,46.05,14.50\nB,46.06,14.51\nC,46.04,14.49\nD,46.55,15.64\nE,46.56,15.65\n",
+}
+
+func TestApply(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	ljubljana := geometry.BBox{MinLon: 14.4, MinLat: 46.0, MaxLon: 14.6, MaxLat: 46.1}
+	tests := []struct {
+		name          string
+		clipTrips     bool
+		wantRoutes    []string
+		wantStops     []string
+		wantStopTimes []string
+	}{
+		{
+			name:          "whole trips",
+			clipTrips:     false,
+			wantRoutes:    []string{"CITY", "INTERCITY"},
+			wantStops:     []string{"A", "B", "C", "D"},
+			wantStopTimes: []string{"A", "B", "B", "C", "D"},
+		},
+		{
+			name:          "clipped trips",
+			clipTrips:     true,
+			wantRoutes:    []string{"CITY", "INTERCITY"},
+			wantStops:     []string{"A", "B", "C"},
+			wantStopTimes: []string{"A", "B", "B", "C"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := feedtest.NewFeed(t, testFeed, nil)
+			if err := geo.Apply(f, ljubljana, tt.clipTrips, reporter); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if got := feedtest.Column(t, f, "routes.txt", "route_id"); !slices.Equal(got, tt.wantRoutes) {
+				t.Errorf("routes.txt = %v, want %v", got, tt.wantRoutes)
+			}
+			if got := feedtest.Column(t, f, "stops.txt", "stop_id"); !slices.Equal(got, tt.wantStops) {
+				t.Errorf("stops.txt = %v, want %v", got, tt.wantStops)
+			}
+			if got := feedtest.Column(t, f, "stop_times.txt", "stop_id"); !slices.Equal(got, tt.wantStopTimes) {
+				t.Errorf("stop_times.txt = %v, want %v", got, tt.wantStopTimes)
+			}
+		})
+	}
+}
+
+func TestApply_NothingInArea(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	f := feedtest.NewFeed(t, testFeed, nil)
+	err := geo.Apply(f, geometry.BBox{MinLon: 0, MinLat: 0, MaxLon: 1, MaxLat: 1}, false, reporter)
+	if !errors.Is(err, geo.ErrNothingInArea) {
+		t.Errorf("Apply() error = %v, want %v", err, geo.ErrNothingInArea)
+	}
+}
+
+// agency_id of routes is optional in a feed with a single agency, which must still be kept
+func TestApply_SingleAgency(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	files := map[string]string{
+		"agency.txt": "agency_id,agency_name\nLPP,Ljubljanski potniški promet\n",
+		"routes.txt": "route_id,route_type\nCITY,3\nINTERCITY,3\nREMOTE,3\n",
+	}
+	for _, name := range []string{"trips.txt", "stop_times.txt", "stops.txt"} {
+		files[name] = testFeed[name]
+	}
+	f := feedtest.NewFeed(t, files, nil)
+	ljubljana := geometry.BBox{MinLon: 14.4, MinLat: 46.0, MaxLon: 14.6, MaxLat: 46.1}
+	if err := geo.Apply(f, ljubljana, false, reporter); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+	if got, want := feedtest.Column(t, f, "agency.txt", "agency_id"), []string{"LPP"}; !slices.Equal(got, want) {
+		t.Errorf("agency.txt agency_id = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,296 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/file"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/pattern"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
+)
+
+// Reasons for dropping a file or removing a column in a Plan
+const (
+	ReasonExcluded = "excluded" // by the file or field inclusion and exclusion lists
+	ReasonEmpty    = "empty"    // by --exclude-empty-files or --exclude-empty-fields
+)
+
+// Plan describes what an extraction does to the input feed, without writing the output.
+type Plan struct {
+	Files []FilePlan `json:"files"`
+	// Files or file patterns of the file or field options that don't match any file of the input feed
+	MissingFiles []string `json:"missing_files"`
+	// Size of the output feed in bytes; compressed if the output is a zip archive
+	OutputBytes int64 `json:"output_bytes"`
+	Zipped      bool  `json:"zipped"`
+}
+
+// FilePlan describes what an extraction does to a single file of the input feed.
+type FilePlan struct {
+	Name string `json:"name"`
+	Kept bool   `json:"kept"`
+	// Why a file is dropped, ReasonExcluded or ReasonEmpty
+	Reason  string `json:"reason,omitempty"`
+	RowsIn  int    `json:"rows_in"`
+	RowsOut int    `json:"rows_out"`
+	// Malformed rows skipped, which are not in RowsOut
+	RowsRejected   int             `json:"rows_rejected"`
+	Columns        []string        `json:"columns"`
+	RemovedColumns []RemovedColumn `json:"removed_columns"`
+	// Fields or field patterns given for the file by the field options that don't match any field of the file
+	MissingFields []string `json:"missing_fields"`
+	// Uncompressed size of the output file in bytes
+	OutputBytes int64 `json:"output_bytes"`
+}
+
+// RemovedColumn is a column of an input file that is not in the output.
+type RemovedColumn struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Plan runs the extraction without writing the output, and reports what it would do.
+// If zipped, the output size is the size of the zip archive, otherwise the total size of the files.
+func (e *Extractor) Plan(source gtfsio.Source, zipped bool) (*Plan, error) {
+	if !e.params.IsParsedAndValid() {
+		return nil, fmt.Errorf("extract parameters are not parsed or valid")
+	}
+	params := e.params
+
+	size := &countingWriter{}
+	var sink gtfsio.Sink = discardSink{}
+	if zipped {
+		sink = gtfsio.NewZipSink(zip.NewWriter(size))
+	}
+	recorder := &recordingSink{sink: sink, files: map[string]*recordingWriter{}}
+	if err := e.Extract(source, recorder); err != nil {
+		return nil, err
+	}
+	if err := sink.Close(); err != nil {
+		return nil, err
+	}
+
+	rejected := map[string]int{}
+	for _, r := range e.Rejects() {
+		rejected[r.File]++
+	}
+
+	plan := &Plan{Zipped: zipped}
+	inputFeed := feed.New(source.Files(), params)
+	for _, f := range source.Files() {
+		rowsIn, err := countRows(f)
+		if err != nil {
+			return nil, err
+		}
+		// The header as the field options see it, after renames
+		header, err := inputFeed.Header(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		fp := FilePlan{Name: f.Name(), RowsIn: rowsIn, Columns: []string{}, RemovedColumns: []RemovedColumn{}, MissingFields: []string{}}
+
+		included := params.IncludedFieldsOf(f.Name())
+		excluded := params.ExcludedFieldsOf(f.Name())
+		// Only fields given for exactly this file, a pattern like *,*_url isn't expected to match in every file
+		for _, field := range slices.Concat(params.IncludedFields()[f.Name()], params.ExcludedFields()[f.Name()]) {
+			matchesField := slices.ContainsFunc(header, func(name string) bool { return pattern.Match(field, name) })
+			if !matchesField && !slices.Contains(fp.MissingFields, field) {
+				fp.MissingFields = append(fp.MissingFields, field)
+			}
+		}
+
+		out, written := recorder.files[f.Name()]
+		switch {
+		case !params.IsFileExtracted(f.Name()):
+			fp.Reason = ReasonExcluded
+		case !written:
+			fp.Reason = ReasonEmpty
+		default:
+			fp.Kept = true
+			fp.RowsOut = out.rows()
+			fp.RowsRejected = rejected[f.Name()]
+			fp.OutputBytes = out.n
+			if out.header != nil {
+				fp.Columns = out.header
+			}
+			mask, _ := file.GenerateFieldMapping(header, included, excluded)
+			for i, field := range header {
+				switch {
+				case !mask[i]:
+					fp.RemovedColumns = append(fp.RemovedColumns, RemovedColumn{Name: field, Reason: ReasonExcluded})
+				case !slices.Contains(fp.Columns, field) && out.header != nil:
+					fp.RemovedColumns = append(fp.RemovedColumns, RemovedColumn{Name: field, Reason: ReasonEmpty})
+				}
+			}
+			plan.OutputBytes += out.n
+		}
+		plan.Files = append(plan.Files, fp)
+	}
+	if zipped {
+		plan.OutputBytes = size.n
+	}
+
+	requested := slices.Concat(params.IncludedFiles(), params.ExcludedFiles())
+	for fileName := range params.IncludedFields() {
+		requested = append(requested, fileName)
+	}
+	for fileName := range params.ExcludedFields() {
+		requested = append(requested, fileName)
+	}
+	plan.MissingFiles = []string{}
+	for _, filePattern := range requested {
+		matchesFile := slices.ContainsFunc(source.Files(), func(f gtfsio.File) bool { return pattern.Match(filePattern, f.Name()) })
+		if !matchesFile && !slices.Contains(plan.MissingFiles, filePattern) {
+			plan.MissingFiles = append(plan.MissingFiles, filePattern)
+		}
+	}
+	slices.Sort(plan.MissingFiles)
+	return plan, nil
+}
+
+// WriteText writes the plan in a human readable form.
+func (p *Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, f := range p.Files {
+		if !f.Kept {
+			fmt.Fprintf(&b, "drop %s (%s, %d rows)\n", f.Name, f.Reason, f.RowsIn)
+		} else {
+			fmt.Fprintf(&b, "keep %s (%d of %d rows, %d bytes)\n", f.Name, f.RowsOut, f.RowsIn, f.OutputBytes)
+			if f.RowsRejected > 0 {
+				fmt.Fprintf(&b, "\tskip %d malformed rows\n", f.RowsRejected)
+			}
+			for _, c := range f.RemovedColumns {
+				fmt.Fprintf(&b, "\tremove column %s (%s)\n", c.Name, c.Reason)
+			}
+		}
+		for _, field := range f.MissingFields {
+			fmt.Fprintf(&b, "\tmissing field %s\n", field)
+		}
+	}
+	for _, fileName := range p.MissingFiles {
+		fmt.Fprintf(&b, "missing file %s\n", fileName)
+	}
+	if p.Zipped {
+		fmt.Fprintf(&b, "estimated output size: %d bytes (zip)\n", p.OutputBytes)
+	} else {
+		fmt.Fprintf(&b, "estimated output size: %d bytes\n", p.OutputBytes)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// countRows returns the number of data rows of a file, including malformed rows.
+func countRows(f gtfsio.File) (int, error) {
+	r, err := f.Open()
+	if err != nil {
+		return 0, fmt.Errorf("error opening file %s: %w", f.Name(), err)
+	}
+	defer r.Close()
+	// The header is not a data row
+	rows := -1
+	reader := file.NewReader(r, f.Name(), false, func(file.Reject) { rows++ })
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error reading file %s: %w", f.Name(), err)
+		}
+		rows++
+	}
+	return max(rows, 0), nil
+}
+
+// recordingSink passes files to another sink, and records the header, row count and size of each.
+type recordingSink struct {
+	sink  gtfsio.Sink
+	files map[string]*recordingWriter
+}
+
+func (s *recordingSink) Create(name string) (io.WriteCloser, error) {
+	w, err := s.sink.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	rw := &recordingWriter{w: w}
+	s.files[name] = rw
+	return rw, nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+// recordingWriter counts the CSV records written through it, and parses the first one as the header.
+type recordingWriter struct {
+	w       io.WriteCloser
+	n       int64
+	records int
+	quoted  bool
+	first   bytes.Buffer
+	header  []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if w.records == 0 {
+			w.first.WriteByte(c)
+		}
+		switch {
+		case c == '"':
+			w.quoted = !w.quoted
+		case c == '\n' && !w.quoted:
+			if w.records == 0 {
+				w.header, _ = csv.NewReader(&w.first).Read()
+			}
+			w.records++
+		}
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *recordingWriter) Close() error {
+	return w.w.Close()
+}
+
+// rows returns the number of data rows written.
+func (w *recordingWriter) rows() int {
+	return max(w.records-1, 0)
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// discardSink is a sink that throws away everything written to it.
+type discardSink struct{}
+
+func (discardSink) Create(name string) (io.WriteCloser, error) {
+	return nopCloser{io.Discard}, nil
+}
+
+func (discardSink) Close() error {
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,4 @@
+// Package prune removes orphaned and dangling entities from a GTFS feed.
+// Entities nothing refers to (stops no trip visits, unused shapes, services, routes and agencies)
+// are removed, as are rows referring to removed entities, until no more rows can be removed.
+package prune
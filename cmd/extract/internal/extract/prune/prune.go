@@ -0,0 +1,293 @@
+package prune
+
+import (
+	"slices"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/file"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+// entity is a kind of GTFS entity, identified by idField in the files that define it
+type entity struct {
+	files   []string
+	idField string
+}
+
+var (
+	agencies = &entity{files: []string{"agency.txt"}, idField: "agency_id"}
+	routes   = &entity{files: []string{"routes.txt"}, idField: "route_id"}
+	trips    = &entity{files: []string{"trips.txt"}, idField: "trip_id"}
+	stops    = &entity{files: []string{"stops.txt"}, idField: "stop_id"}
+	services = &entity{files: []string{"calendar.txt", "calendar_dates.txt"}, idField: "service_id"}
+	shapes   = &entity{files: []string{"shapes.txt"}, idField: "shape_id"}
+	levels   = &entity{files: []string{"levels.txt"}, idField: "level_id"}
+	fares    = &entity{files: []string{"fare_attributes.txt"}, idField: "fare_id"}
+
+	entities = []*entity{agencies, routes, trips, stops, services, shapes, levels, fares}
+)
+
+// reference is a set of fields in file referring to an entity.
+// Rows referring to a removed entity are removed. If keepsAlive is set, an entity nothing
+// refers to through any such reference is an orphan and is removed.
+type reference struct {
+	file       string
+	fields     []string
+	entity     *entity
+	keepsAlive bool
+}
+
+var references = []reference{
+	{"routes.txt", []string{"agency_id"}, agencies, true},
+	{"fare_attributes.txt", []string{"agency_id"}, agencies, false},
+	{"attributions.txt", []string{"agency_id"}, agencies, false},
+	{"trips.txt", []string{"route_id"}, routes, true},
+	{"fare_rules.txt", []string{"route_id"}, routes, false},
+	{"transfers.txt", []string{"from_route_id", "to_route_id"}, routes, false},
+	{"attributions.txt", []string{"route_id"}, routes, false},
+	{"route_networks.txt", []string{"route_id"}, routes, false},
+	{"stop_times.txt", []string{"trip_id"}, trips, true},
+	{"frequencies.txt", []string{"trip_id"}, trips, false},
+	{"transfers.txt", []string{"from_trip_id", "to_trip_id"}, trips, false},
+	{"attributions.txt", []string{"trip_id"}, trips, false},
+	// Stations, entrances and other nodes are kept alive through parent_station, see scan
+	{"stop_times.txt", []string{"stop_id"}, stops, true},
+	{"transfers.txt", []string{"from_stop_id", "to_stop_id"}, stops, false},
+	{"pathways.txt", []string{"from_stop_id", "to_stop_id"}, stops, false},
+	{"trips.txt", []string{"service_id"}, services, true},
+	{"trips.txt", []string{"shape_id"}, shapes, true},
+	{"stops.txt", []string{"level_id"}, levels, true},
+	{"fare_rules.txt", []string{"fare_id"}, fares, true},
+}
+
+// state is the result of scanning the feed once
+type state struct {
+	feed   *feed.Feed
+	params *params.ExtractParams
+
+	rowCounts map[string]int
+	defined   map[*entity]rows.KeySet
+	// per reference, the values found in its fields
+	referenced []rows.KeySet
+	// entities that are kept alive regardless of references
+	alwaysAlive map[*entity]rows.KeySet
+}
+
+// Apply prunes the feed until no more rows can be removed, and reports the number of removed rows per file.
+// Only files and fields that will be in the output can keep entities alive.
+func Apply(f *feed.Feed, p *params.ExtractParams, log logging.LogReporter) error {
+	var initial map[string]int
+	for iteration := 1; ; iteration++ {
+		s, err := scan(f, p)
+		if err != nil {
+			return err
+		}
+		if initial == nil {
+			initial = s.rowCounts
+		}
+		if !s.prune() {
+			log(logging.Verbose, "Pruning reached a fixed point after %d iterations", iteration)
+			report(initial, s.rowCounts, log)
+			return nil
+		}
+	}
+}
+
+func report(initial, final map[string]int, log logging.LogReporter) {
+	files := make([]string, 0, len(initial))
+	for fileName := range initial {
+		files = append(files, fileName)
+	}
+	slices.Sort(files)
+	for _, fileName := range files {
+		if removed := initial[fileName] - final[fileName]; removed > 0 {
+			log(logging.NoStatus, "Pruned %d of %d rows from %s", removed, initial[fileName], fileName)
+		}
+	}
+}
+
+// files returns all files the model knows about, in a deterministic order
+func files() []string {
+	var result []string
+	add := func(fileName string) {
+		if !slices.Contains(result, fileName) {
+			result = append(result, fileName)
+		}
+	}
+	for _, e := range entities {
+		for _, fileName := range e.files {
+			add(fileName)
+		}
+	}
+	for _, r := range references {
+		add(r.file)
+	}
+	return result
+}
+
+func scan(f *feed.Feed, p *params.ExtractParams) (*state, error) {
+	s := &state{
+		feed:        f,
+		params:      p,
+		rowCounts:   map[string]int{},
+		defined:     map[*entity]rows.KeySet{},
+		referenced:  make([]rows.KeySet, len(references)),
+		alwaysAlive: map[*entity]rows.KeySet{},
+	}
+	for _, e := range entities {
+		s.defined[e] = rows.KeySet{}
+		s.alwaysAlive[e] = rows.KeySet{}
+	}
+	for i := range references {
+		s.referenced[i] = rows.KeySet{}
+	}
+
+	singleAgency := false
+	parents := map[string]string{}
+	nodes := map[string]string{} // entrances, generic nodes and boarding areas, to their parent
+
+	for _, fileName := range files() {
+		err := f.ReadTable(fileName, func(row feed.Row) error {
+			s.rowCounts[fileName]++
+			for _, e := range entities {
+				if slices.Contains(e.files, fileName) {
+					s.defined[e].Add(row.Get(e.idField))
+				}
+			}
+			for i, r := range references {
+				if r.file != fileName {
+					continue
+				}
+				for _, field := range r.fields {
+					if v := row.Get(field); v != "" && fieldExtracted(p, fileName, field) {
+						s.referenced[i].Add(v)
+					}
+				}
+			}
+
+			switch fileName {
+			case "routes.txt":
+				// agency_id may be left out if the feed has a single agency
+				singleAgency = singleAgency || row.Get("agency_id") == ""
+			case "stops.txt":
+				stopID, parent := row.Get("stop_id"), row.Get("parent_station")
+				if parent == "" {
+					return nil
+				}
+				switch row.Get("location_type") {
+				case "2", "3", "4":
+					nodes[stopID] = parent
+				default:
+					parents[stopID] = parent
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if singleAgency {
+		s.alwaysAlive[agencies] = s.defined[agencies]
+	}
+
+	// Stations are alive if they have a stop that is alive, and nodes are alive if their parent is
+	alive := s.alive(stops)
+	for stopID := range alive {
+		for parent, ok := parents[stopID]; ok && !s.alwaysAlive[stops].Has(parent); parent, ok = parents[parent] {
+			s.alwaysAlive[stops].Add(parent)
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for node, parent := range nodes {
+			if !s.alwaysAlive[stops].Has(node) && (alive.Has(parent) || s.alwaysAlive[stops].Has(parent)) {
+				s.alwaysAlive[stops].Add(node)
+				changed = true
+			}
+		}
+	}
+	return s, nil
+}
+
+// alive returns the entities of kind e that are referenced through a reference keeping them alive.
+// If no such reference is in the output, all defined entities are alive.
+func (s *state) alive(e *entity) rows.KeySet {
+	result := rows.KeySet{}
+	applicable := false
+	for i, r := range references {
+		if r.entity != e || !r.keepsAlive || !s.fileExtracted(r.file) {
+			continue
+		}
+		applicable = true
+		for v := range s.referenced[i] {
+			result.Add(v)
+		}
+	}
+	if !applicable {
+		return s.defined[e]
+	}
+	for v := range s.alwaysAlive[e] {
+		result.Add(v)
+	}
+	return result
+}
+
+// prune registers the row processors removing orphans and dangling references found by the scan.
+// It reports whether any row will be removed.
+func (s *state) prune() bool {
+	removes := false
+	kept := map[*entity]rows.KeySet{}
+	for _, e := range entities {
+		alive := s.alive(e)
+		k := rows.KeySet{}
+		for id := range s.defined[e] {
+			if alive.Has(id) {
+				k.Add(id)
+			}
+		}
+		kept[e] = k
+		if len(k) < len(s.defined[e]) {
+			removes = true
+			for _, fileName := range e.files {
+				s.feed.AddProcessors(fileName, rows.KeepKeys(k, e.idField))
+			}
+		}
+	}
+
+	for i, r := range references {
+		if !s.entityExtracted(r.entity) {
+			// Can't tell whether the reference dangles if the entity isn't in the output
+			continue
+		}
+		dangling := false
+		for v := range s.referenced[i] {
+			if !kept[r.entity].Has(v) {
+				dangling = true
+				break
+			}
+		}
+		if dangling {
+			removes = true
+			s.feed.AddProcessors(r.file, rows.KeepKeys(kept[r.entity], r.fields...))
+		}
+	}
+	return removes
+}
+
+func (s *state) fileExtracted(fileName string) bool {
+	return s.feed.Has(fileName) && s.params.IsFileExtracted(fileName)
+}
+
+func (s *state) entityExtracted(e *entity) bool {
+	return slices.ContainsFunc(e.files, s.fileExtracted)
+}
+
+// fieldExtracted reports whether the field will be written to the output file.
+func fieldExtracted(p *params.ExtractParams, fileName, field string) bool {
+	mask, _ := file.GenerateFieldMapping([]string{field}, p.IncludedFieldsOf(fileName), p.ExcludedFieldsOf(fileName))
+	return mask[0]
+}
@@ -0,0 +1,102 @@
+package prune_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed/feedtest"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/prune"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+var testFeed = map[string]string{
+	"agency.txt": "agency_id,agency_name\nA1,LPP\nA2,Arriva\nA3,Nomago\n",
+	"routes.txt": "route_id,agency_id,route_type\nR6,A1,3\nR11,A1,3\nRT,A2,2\n",
+	"trips.txt":  "route_id,service_id,trip_id,shape_id\nR6,WD,T6a,S6\nR6,WE,T6b,S6\nR11,WD,T11a,S11\nRT,SA,TTa,\n",
+	"stop_times.txt": "trip_id,stop_id,stop_sequence\n" +
+		"T6a,P1,1\nT6a,P2,2\nT6b,P1,1\nT11a,P2,1\nT11a,P4,2\nTTa,P5,1\n",
+	"stops.txt": "stop_id,location_type,parent_station\n" +
+		"ST1,1,\nP1,0,ST1\nE1,2,ST1\nP2,0,\nP4,0,\nP5,0,\nP9,0,\n",
+	"calendar.txt":        "service_id,start_date,end_date\nWD,20250101,20251231\nWE,20250101,20251231\nSA,20250101,20251231\nOLD,20200101,20201231\n",
+	"calendar_dates.txt":  "service_id,date,exception_type\nWD,20250101,2\nOLD,20200101,2\n",
+	"shapes.txt":          "shape_id,shape_pt_sequence\nS6,1\nS11,1\nS99,1\n",
+	"frequencies.txt":     "trip_id,headway_secs\nT6a,600\nT11a,600\n",
+	"transfers.txt":       "from_stop_id,to_stop_id,transfer_type\nP1,P2,2\nP4,P5,2\n",
+	"fare_attributes.txt": "fare_id,price\nF1,1.30\nF2,2.50\n",
+	"fare_rules.txt":      "fare_id,route_id\nF1,R6\nF1,R11\nF2,RT\n",
+}
+
+func TestApply(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	type column struct {
+		file  string
+		field string
+	}
+	tests := []struct {
+		name       string
+		params     *params.ExtractParams
+		keepRoutes []string
+		want       map[column][]string
+	}{
+		{
+			name:   "orphans in an unfiltered feed",
+			params: params.NewExtractParamsParsed(nil, nil, false, false, false, nil, nil),
+			want: map[column][]string{
+				{"agency.txt", "agency_id"}:          {"A1", "A2"},
+				{"stops.txt", "stop_id"}:             {"E1", "P1", "P2", "P4", "P5", "ST1"},
+				{"calendar.txt", "service_id"}:       {"SA", "WD", "WE"},
+				{"calendar_dates.txt", "service_id"}: {"WD"},
+				{"shapes.txt", "shape_id"}:           {"S11", "S6"},
+				{"fare_attributes.txt", "fare_id"}:   {"F1", "F2"},
+			},
+		},
+		{
+			name:       "removed route cascades to everything only it used",
+			params:     params.NewExtractParamsParsed(nil, nil, false, false, false, nil, nil),
+			keepRoutes: []string{"R11"},
+			want: map[column][]string{
+				{"agency.txt", "agency_id"}:        {"A1"},
+				{"trips.txt", "trip_id"}:           {"T11a"},
+				{"stop_times.txt", "trip_id"}:      {"T11a", "T11a"},
+				{"stops.txt", "stop_id"}:           {"P2", "P4"},
+				{"calendar.txt", "service_id"}:     {"WD"},
+				{"shapes.txt", "shape_id"}:         {"S11"},
+				{"frequencies.txt", "trip_id"}:     {"T11a"},
+				{"transfers.txt", "from_stop_id"}:  nil,
+				{"fare_rules.txt", "route_id"}:     {"R11"},
+				{"fare_attributes.txt", "fare_id"}: {"F1"},
+			},
+		},
+		{
+			name:   "excluded shape references make all shapes orphans",
+			params: params.NewExtractParamsParsed(nil, nil, false, false, false, map[string][]string{"trips.txt": {"shape_id"}}, nil),
+			want: map[column][]string{
+				{"shapes.txt", "shape_id"}: nil,
+				{"trips.txt", "trip_id"}:   {"T11a", "T6a", "T6b", "TTa"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := feedtest.NewFeed(t, testFeed, tt.params)
+			if tt.keepRoutes != nil {
+				keys := rows.KeySet{}
+				for _, r := range tt.keepRoutes {
+					keys.Add(r)
+				}
+				f.AddProcessors("routes.txt", rows.KeepKeys(keys, "route_id"))
+			}
+			if err := prune.Apply(f, tt.params, reporter); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			for col, want := range tt.want {
+				got := feedtest.Column(t, f, col.file, col.field)
+				if !slices.Equal(got, want) {
+					t.Errorf("%s %s = %v, want %v", col.file, col.field, got, want)
+				}
+			}
+		})
+	}
+}
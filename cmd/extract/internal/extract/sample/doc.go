@@ -0,0 +1,5 @@
+// Package sample extracts a small, referentially consistent sample of a GTFS feed, for test fixtures.
+// It picks trips, either at random with a seed or the first trips of every route, and keeps them
+// together with everything they need, like the subset of selected trips. The same feed and options
+// always give the same sample.
+package sample
@@ -0,0 +1,62 @@
+package sample
+
+import (
+	"math/rand/v2"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/subset"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+// Options selects the trips of a sample.
+type Options struct {
+	// Number of trips to pick, or of trips of every route if PerRoute
+	Trips int
+	// Seed of the random choice of trips, unused if PerRoute
+	Seed int64
+	// Pick the first trips of every route in the order of trips.txt, instead of random trips
+	PerRoute bool
+}
+
+// Apply picks the trips of the sample among the trips left by earlier passes, and restricts the feed
+// to them and everything they need.
+func Apply(f *feed.Feed, opts Options, log logging.LogReporter) (*subset.Closure, error) {
+	var tripIDs []string
+	perRoute := map[string]int{}
+	err := f.ReadTable("trips.txt", func(row feed.Row) error {
+		if opts.PerRoute {
+			routeID := row.Get("route_id")
+			if perRoute[routeID] >= opts.Trips {
+				return nil
+			}
+			perRoute[routeID]++
+		}
+		tripIDs = append(tripIDs, row.Get("trip_id"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(tripIDs)
+	if !opts.PerRoute {
+		tripIDs = pick(tripIDs, opts.Trips, opts.Seed)
+	}
+	log(logging.Verbose, "Sampled %d of %d trips", len(tripIDs), total)
+
+	return subset.Apply(f, subset.Selection{Trips: tripIDs}, log)
+}
+
+// pick returns n of the IDs chosen at random, the same ones for the same IDs and seed.
+func pick(ids []string, n int, seed int64) []string {
+	if n >= len(ids) {
+		return ids
+	}
+	r := rand.New(rand.NewPCG(uint64(seed), 0))
+	// Partial Fisher-Yates shuffle, the first n IDs are the sample
+	for i := range n {
+		j := i + r.IntN(len(ids)-i)
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return ids[:n]
+}
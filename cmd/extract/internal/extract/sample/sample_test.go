@@ -0,0 +1,102 @@
+package sample_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed/feedtest"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/sample"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+var testFeed = map[string]string{
+	"agency.txt": "agency_id,agency_name\nA1,LPP\nA2,Arriva\n",
+	"routes.txt": "route_id,agency_id,route_type\nR6,A1,3\nR11,A1,3\nRT,A2,2\n",
+	"trips.txt":  "route_id,service_id,trip_id,shape_id\nR6,WD,T6a,S6\nR6,WE,T6b,S6\nR11,WD,T11a,S11\nRT,SA,TTa,\n",
+	"stop_times.txt": "trip_id,stop_id,stop_sequence\n" +
+		"T6a,P1,1\nT6a,P2,2\nT6b,P1,1\nT11a,P2,1\nT11a,P4,2\nTTa,P5,1\n",
+	"stops.txt": "stop_id,location_type,parent_station\n" +
+		"ST1,1,\nP1,0,ST1\nE1,2,ST1\nP2,0,\nP4,0,\nP5,0,\n",
+	"calendar.txt":    "service_id,start_date,end_date\nWD,20250101,20251231\nWE,20250101,20251231\nSA,20250101,20251231\n",
+	"shapes.txt":      "shape_id,shape_pt_sequence\nS6,1\nS11,1\n",
+	"frequencies.txt": "trip_id,headway_secs\nT6a,600\nT11a,600\n",
+	"transfers.txt":   "from_stop_id,to_stop_id,transfer_type\nP1,P2,2\nP4,P5,2\n",
+	"fare_rules.txt":  "fare_id,route_id\nF1,R6\nF1,R11\nF2,RT\n",
+}
+
+func TestApply_PerRoute(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	f := feedtest.NewFeed(t, testFeed, nil)
+	if _, err := sample.Apply(f, sample.Options{Trips: 1, PerRoute: true}, reporter); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+	type column struct {
+		file  string
+		field string
+	}
+	want := map[column][]string{
+		{"trips.txt", "trip_id"}:       {"T11a", "T6a", "TTa"},
+		{"stops.txt", "stop_id"}:       {"E1", "P1", "P2", "P4", "P5", "ST1"},
+		{"calendar.txt", "service_id"}: {"SA", "WD"},
+		{"frequencies.txt", "trip_id"}: {"T11a", "T6a"},
+	}
+	for col, want := range want {
+		if got := feedtest.Column(t, f, col.file, col.field); !slices.Equal(got, want) {
+			t.Errorf("%s %s = %v, want %v", col.file, col.field, got, want)
+		}
+	}
+}
+
+func TestApply_Random(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	sampleTrips := func(opts sample.Options) *feed.Feed {
+		f := feedtest.NewFeed(t, testFeed, nil)
+		if _, err := sample.Apply(f, opts, reporter); err != nil {
+			t.Fatalf("Apply() failed: %v", err)
+		}
+		return f
+	}
+
+	f := sampleTrips(sample.Options{Trips: 2, Seed: 42})
+	trips := feedtest.Column(t, f, "trips.txt", "trip_id")
+	if len(trips) != 2 {
+		t.Fatalf("trips.txt trip_id = %v, want 2 trips", trips)
+	}
+	// The rest of the feed only has what the sampled trips need
+	if got := slices.Compact(feedtest.Column(t, f, "stop_times.txt", "trip_id")); !slices.Equal(got, trips) {
+		t.Errorf("stop_times.txt trip_id = %v, want %v", got, trips)
+	}
+
+	for range 5 {
+		if got := feedtest.Column(t, sampleTrips(sample.Options{Trips: 2, Seed: 42}), "trips.txt", "trip_id"); !slices.Equal(got, trips) {
+			t.Errorf("trips.txt trip_id with the same seed = %v, want %v", got, trips)
+		}
+	}
+
+	all := feedtest.Column(t, sampleTrips(sample.Options{Trips: 10, Seed: 42}), "trips.txt", "trip_id")
+	if want := []string{"T11a", "T6a", "T6b", "TTa"}; !slices.Equal(all, want) {
+		t.Errorf("trips.txt trip_id with more trips than the feed = %v, want %v", all, want)
+	}
+}
+
+// agency_id of routes is optional in a feed with a single agency, which must still be kept
+func TestApply_SingleAgency(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	f := feedtest.NewFeed(t, map[string]string{
+		"agency.txt":     "agency_id,agency_name\nLPP,Ljubljanski potniški promet\n",
+		"routes.txt":     "route_id,route_type\nR6,3\nR11,3\n",
+		"trips.txt":      "route_id,service_id,trip_id\nR6,WD,T6a\nR11,WD,T11a\n",
+		"stop_times.txt": "trip_id,stop_id,stop_sequence\nT6a,P1,1\nT11a,P2,1\n",
+		"stops.txt":      "stop_id\nP1\nP2\n",
+	}, nil)
+	if _, err := sample.Apply(f, sample.Options{Trips: 1, Seed: 1}, reporter); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+	if got, want := feedtest.Column(t, f, "agency.txt", "agency_id"), []string{"LPP"}; !slices.Equal(got, want) {
+		t.Errorf("agency.txt agency_id = %v, want %v", got, want)
+	}
+	if got := feedtest.Column(t, f, "trips.txt", "trip_id"); len(got) != 1 {
+		t.Errorf("trips.txt trip_id = %v, want 1 trip", got)
+	}
+}
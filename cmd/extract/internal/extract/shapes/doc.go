@@ -0,0 +1,4 @@
+// Package shapes reduces the size of shapes.txt while keeping usable geometry.
+// Each shape is simplified with the Douglas-Peucker algorithm and its coordinates can be rounded
+// to a given number of decimal places.
+package shapes
@@ -0,0 +1,126 @@
+package shapes
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/geometry"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+// shapePoint is a single row of shapes.txt
+type shapePoint struct {
+	geometry.Point
+	sequence int
+	// raw shape_pt_sequence, used as the key of the point
+	rawSequence string
+}
+
+// Apply simplifies every shape so no removed point is further than tolerance meters from the simplified shape,
+// and rounds the coordinates of the remaining points to precision decimal places.
+// A tolerance of zero leaves the points as they are and a precision of zero leaves the coordinates as they are.
+//
+// Kept points keep their shape_dist_traveled, which is the distance along the original shape. Stop times
+// refer to the same distances, so they stay consistent with the shape without being changed.
+func Apply(f *feed.Feed, tolerance float64, precision int, log logging.LogReporter) error {
+	if tolerance > 0 {
+		kept, total, err := simplify(f, tolerance)
+		if err != nil {
+			return err
+		}
+		log(logging.Verbose, "Simplified shapes keep %d of %d points", len(kept), total)
+		f.AddProcessors("shapes.txt", rows.ProcessorFunc(func(header []string) (rows.Func, error) {
+			idIndex := slices.Index(header, "shape_id")
+			seqIndex := slices.Index(header, "shape_pt_sequence")
+			return func(record []string) (bool, error) {
+				if idIndex < 0 || seqIndex < 0 || idIndex >= len(record) || seqIndex >= len(record) {
+					return true, nil
+				}
+				return kept.Has(pointKey(record[idIndex], record[seqIndex])), nil
+			}, nil
+		}))
+	}
+	if precision > 0 {
+		f.AddProcessors("shapes.txt", roundCoordinates(precision))
+	}
+	return nil
+}
+
+// simplify reads all shapes and returns the keys of the points kept by the simplification,
+// together with the number of points read.
+func simplify(f *feed.Feed, tolerance float64) (rows.KeySet, int, error) {
+	shapes := map[string][]shapePoint{}
+	total := 0
+	err := f.ReadTable("shapes.txt", func(row feed.Row) error {
+		shapeID := row.Get("shape_id")
+		var p shapePoint
+		var err error
+		if p.Lat, err = strconv.ParseFloat(row.Get("shape_pt_lat"), 64); err != nil {
+			return fmt.Errorf("invalid shape_pt_lat of shape %s in shapes.txt: %w", shapeID, err)
+		}
+		if p.Lon, err = strconv.ParseFloat(row.Get("shape_pt_lon"), 64); err != nil {
+			return fmt.Errorf("invalid shape_pt_lon of shape %s in shapes.txt: %w", shapeID, err)
+		}
+		p.rawSequence = row.Get("shape_pt_sequence")
+		if p.sequence, err = strconv.Atoi(p.rawSequence); err != nil {
+			return fmt.Errorf("invalid shape_pt_sequence of shape %s in shapes.txt: %w", shapeID, err)
+		}
+		shapes[shapeID] = append(shapes[shapeID], p)
+		total++
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kept := rows.KeySet{}
+	for shapeID, points := range shapes {
+		// Points of a shape don't have to be ordered or even grouped in shapes.txt
+		slices.SortFunc(points, func(a, b shapePoint) int {
+			return cmp.Compare(a.sequence, b.sequence)
+		})
+		line := make([]geometry.Point, len(points))
+		for i, p := range points {
+			line[i] = p.Point
+		}
+		for i, keep := range geometry.Simplify(line, tolerance) {
+			if keep {
+				kept.Add(pointKey(shapeID, points[i].rawSequence))
+			}
+		}
+	}
+	return kept, total, nil
+}
+
+// roundCoordinates returns a processor rounding shape_pt_lat and shape_pt_lon to precision decimal places.
+func roundCoordinates(precision int) rows.Processor {
+	return rows.ProcessorFunc(func(header []string) (rows.Func, error) {
+		var indices []int
+		for _, field := range []string{"shape_pt_lat", "shape_pt_lon"} {
+			if i := slices.Index(header, field); i >= 0 {
+				indices = append(indices, i)
+			}
+		}
+		return func(record []string) (bool, error) {
+			for _, i := range indices {
+				if i >= len(record) || record[i] == "" {
+					continue
+				}
+				v, err := strconv.ParseFloat(record[i], 64)
+				if err != nil {
+					return false, fmt.Errorf("invalid %s in shapes.txt: %w", header[i], err)
+				}
+				record[i] = strconv.FormatFloat(geometry.Round(v, precision), 'f', -1, 64)
+			}
+			return true, nil
+		}, nil
+	})
+}
+
+func pointKey(shapeID, sequence string) string {
+	return shapeID + "\x00" + sequence
+}
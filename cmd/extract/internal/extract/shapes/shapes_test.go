@@ -0,0 +1,82 @@
+package shapes_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed/feedtest"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/shapes"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+// S1 is straight except for a corner at sequence 30, S2 is straight, and its rows are out of order
+var testFeed = map[string]string{
+	"shapes.txt": "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence,shape_dist_traveled\n" +
+		"S1,46.0000001,14.5000001,10,0\n" +
+		"S2,46.1,14.6,1,0\n" +
+		"S1,46.0,14.501,20,77.3\n" +
+		"S1,46.0,14.502,30,154.6\n" +
+		"S2,46.1,14.602,3,154.4\n" +
+		"S1,46.001,14.502,40,265.8\n" +
+		"S2,46.1,14.601,2,77.2\n" +
+		"S1,46.002,14.502,50,377.0\n",
+}
+
+func TestApply(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	tests := []struct {
+		name      string
+		tolerance float64
+		precision int
+		want      []string
+	}{
+		{
+			name:      "simplify",
+			tolerance: 5,
+			want: []string{
+				"S1,10,46.0000001,14.5000001,0",
+				"S2,1,46.1,14.6,0",
+				"S1,30,46.0,14.502,154.6",
+				"S2,3,46.1,14.602,154.4",
+				"S1,50,46.002,14.502,377.0",
+			},
+		},
+		{
+			name:      "round",
+			precision: 4,
+			want: []string{
+				"S1,10,46,14.5,0",
+				"S2,1,46.1,14.6,0",
+				"S1,20,46,14.501,77.3",
+				"S1,30,46,14.502,154.6",
+				"S2,3,46.1,14.602,154.4",
+				"S1,40,46.001,14.502,265.8",
+				"S2,2,46.1,14.601,77.2",
+				"S1,50,46.002,14.502,377.0",
+			},
+		},
+		{
+			name:      "simplify and round",
+			tolerance: 5,
+			precision: 4,
+			want: []string{
+				"S1,10,46,14.5,0",
+				"S2,1,46.1,14.6,0",
+				"S1,30,46,14.502,154.6",
+				"S2,3,46.1,14.602,154.4",
+				"S1,50,46.002,14.502,377.0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := feedtest.NewFeed(t, testFeed, nil)
+			if err := shapes.Apply(f, tt.tolerance, tt.precision, reporter); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if got := feedtest.Rows(t, f, "shapes.txt", "shape_id", "shape_pt_sequence", "shape_pt_lat", "shape_pt_lon", "shape_dist_traveled"); !slices.Equal(got, tt.want) {
+				t.Errorf("shapes.txt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
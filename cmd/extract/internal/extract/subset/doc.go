@@ -0,0 +1,5 @@
+// Package subset extracts a referentially consistent part of a GTFS feed.
+// Starting from selected agencies, routes or trips it computes every entity the selection needs
+// (trips, stop times, stops with their parent stations, services, shapes, frequencies, transfers,
+// pathways, levels and fares) and filters all files down to those entities.
+package subset
@@ -0,0 +1,278 @@
+package subset
+
+import (
+	"errors"
+	"maps"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+var ErrEmptySelection = errors.New("selection doesn't match any trips or routes in the feed")
+
+// Selection lists the entities to extract. Entities selected by any of the lists are kept.
+type Selection struct {
+	Agencies []string
+	Routes   []string
+	Trips    []string
+}
+
+// IsEmpty reports whether nothing is selected, in which case the feed is left as is.
+func (s Selection) IsEmpty() bool {
+	return len(s.Agencies) == 0 && len(s.Routes) == 0 && len(s.Trips) == 0
+}
+
+// Closure holds the IDs of all entities needed by a selection.
+type Closure struct {
+	Agencies rows.KeySet
+	Routes   rows.KeySet
+	Trips    rows.KeySet
+	Stops    rows.KeySet
+	Services rows.KeySet
+	Shapes   rows.KeySet
+	Levels   rows.KeySet
+	Zones    rows.KeySet
+	Fares    rows.KeySet
+}
+
+// stopInfo holds the references of a single stop to other entities
+type stopInfo struct {
+	parent       string
+	level        string
+	zone         string
+	locationType string
+}
+
+// Apply computes the closure of the selection and registers row processors on the feed,
+// which restrict every file to the entities in the closure.
+func Apply(f *feed.Feed, sel Selection, log logging.LogReporter) (*Closure, error) {
+	selectedAgencies := makeSet(sel.Agencies)
+	selectedTrips := makeSet(sel.Trips)
+	c := &Closure{
+		Agencies: rows.KeySet{},
+		Routes:   makeSet(sel.Routes),
+		Trips:    rows.KeySet{},
+		Stops:    rows.KeySet{},
+		Services: rows.KeySet{},
+		Shapes:   rows.KeySet{},
+		Levels:   rows.KeySet{},
+		Zones:    rows.KeySet{},
+		Fares:    rows.KeySet{},
+	}
+
+	// --------------------------------------
+	// Downwards: agencies -> routes -> trips
+	// --------------------------------------
+
+	routeAgency := map[string]string{}
+	agencyCount := 0
+	// agency_id of the first agency, routes without agency_id belong to it if it is the only one
+	loneAgency := ""
+	err := f.ReadTable("agency.txt", func(row feed.Row) error {
+		if agencyCount == 0 {
+			loneAgency = row.Get("agency_id")
+		}
+		agencyCount++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = f.ReadTable("routes.txt", func(row feed.Row) error {
+		routeID := row.Get("route_id")
+		agencyID := row.Get("agency_id")
+		// agency_id is optional when the feed has a single agency
+		if agencyID == "" && agencyCount == 1 {
+			agencyID = loneAgency
+		}
+		routeAgency[routeID] = agencyID
+		if selectedAgencies.Has(agencyID) {
+			c.Routes.Add(routeID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Routes of selected trips are added to the closure while reading trips, but don't select their other trips
+	selectedRoutes := maps.Clone(c.Routes)
+	err = f.ReadTable("trips.txt", func(row feed.Row) error {
+		tripID := row.Get("trip_id")
+		routeID := row.Get("route_id")
+		if !selectedRoutes.Has(routeID) && !selectedTrips.Has(tripID) {
+			return nil
+		}
+		c.Trips.Add(tripID)
+		c.Routes.Add(routeID)
+		addNonEmpty(c.Services, row.Get("service_id"))
+		addNonEmpty(c.Shapes, row.Get("shape_id"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// --------------------------------------
+	// Upwards: referenced agencies, stops, parent stations
+	// --------------------------------------
+
+	for routeID := range c.Routes {
+		agencyID, ok := routeAgency[routeID]
+		if !ok {
+			// selected route that isn't in the feed
+			delete(c.Routes, routeID)
+			continue
+		}
+		addNonEmpty(c.Agencies, agencyID)
+	}
+	if len(c.Routes) == 0 {
+		return nil, ErrEmptySelection
+	}
+
+	err = f.ReadTable("stop_times.txt", func(row feed.Row) error {
+		if c.Trips.Has(row.Get("trip_id")) {
+			addNonEmpty(c.Stops, row.Get("stop_id"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stops := map[string]stopInfo{}
+	children := map[string][]string{}
+	err = f.ReadTable("stops.txt", func(row feed.Row) error {
+		stopID := row.Get("stop_id")
+		info := stopInfo{
+			parent:       row.Get("parent_station"),
+			level:        row.Get("level_id"),
+			zone:         row.Get("zone_id"),
+			locationType: row.Get("location_type"),
+		}
+		stops[stopID] = info
+		if info.parent != "" {
+			children[info.parent] = append(children[info.parent], stopID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	closeStops(c, stops, children)
+
+	// --------------------------------------
+	// Fares
+	// --------------------------------------
+
+	err = f.ReadTable("fare_rules.txt", func(row feed.Row) error {
+		if keepFareRule(c, row) {
+			c.Fares.Add(row.Get("fare_id"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.register(f)
+
+	log(logging.Verbose, "Subset keeps %d agencies, %d routes, %d trips, %d stops, %d services, %d shapes",
+		len(c.Agencies), len(c.Routes), len(c.Trips), len(c.Stops), len(c.Services), len(c.Shapes))
+	return c, nil
+}
+
+// closeStops adds parent stations of the kept stops, and the entrances, generic nodes and
+// boarding areas of kept stations and platforms, so pathways stay usable.
+func closeStops(c *Closure, stops map[string]stopInfo, children map[string][]string) {
+	queue := make([]string, 0, len(c.Stops))
+	for stopID := range c.Stops {
+		queue = append(queue, stopID)
+	}
+	for len(queue) > 0 {
+		stopID := queue[0]
+		queue = queue[1:]
+		info, ok := stops[stopID]
+		if !ok {
+			continue
+		}
+		addNonEmpty(c.Levels, info.level)
+		addNonEmpty(c.Zones, info.zone)
+		if info.parent != "" && !c.Stops.Has(info.parent) {
+			c.Stops.Add(info.parent)
+			queue = append(queue, info.parent)
+		}
+		for _, child := range children[stopID] {
+			switch stops[child].locationType {
+			case "2", "3", "4":
+				if !c.Stops.Has(child) {
+					c.Stops.Add(child)
+					queue = append(queue, child)
+				}
+			}
+		}
+	}
+}
+
+func keepFareRule(c *Closure, row feed.Row) bool {
+	if routeID := row.Get("route_id"); routeID != "" && !c.Routes.Has(routeID) {
+		return false
+	}
+	for _, field := range []string{"origin_id", "destination_id", "contains_id"} {
+		if zone := row.Get(field); zone != "" && !c.Zones.Has(zone) {
+			return false
+		}
+	}
+	return true
+}
+
+// register adds the row processors restricting each file to the closure.
+func (c *Closure) register(f *feed.Feed) {
+	f.AddProcessors("agency.txt", rows.KeepKeys(c.Agencies, "agency_id"))
+	f.AddProcessors("routes.txt", rows.KeepKeys(c.Routes, "route_id"))
+	f.AddProcessors("trips.txt", rows.KeepKeys(c.Trips, "trip_id"))
+	f.AddProcessors("stop_times.txt", rows.KeepKeys(c.Trips, "trip_id"))
+	f.AddProcessors("stops.txt", rows.KeepKeys(c.Stops, "stop_id"))
+	f.AddProcessors("calendar.txt", rows.KeepKeys(c.Services, "service_id"))
+	f.AddProcessors("calendar_dates.txt", rows.KeepKeys(c.Services, "service_id"))
+	f.AddProcessors("shapes.txt", rows.KeepKeys(c.Shapes, "shape_id"))
+	f.AddProcessors("frequencies.txt", rows.KeepKeys(c.Trips, "trip_id"))
+	f.AddProcessors("transfers.txt",
+		rows.KeepKeys(c.Stops, "from_stop_id", "to_stop_id"),
+		rows.KeepKeys(c.Routes, "from_route_id", "to_route_id"),
+		rows.KeepKeys(c.Trips, "from_trip_id", "to_trip_id"),
+	)
+	f.AddProcessors("pathways.txt", rows.KeepKeys(c.Stops, "from_stop_id", "to_stop_id"))
+	f.AddProcessors("levels.txt", rows.KeepKeys(c.Levels, "level_id"))
+	f.AddProcessors("fare_rules.txt",
+		rows.KeepKeys(c.Routes, "route_id"),
+		rows.KeepKeys(c.Zones, "origin_id", "destination_id", "contains_id"),
+	)
+	if f.Has("fare_rules.txt") {
+		f.AddProcessors("fare_attributes.txt", rows.KeepKeys(c.Fares, "fare_id"))
+	} else {
+		// Without fare rules, fares apply to all routes of their agency
+		f.AddProcessors("fare_attributes.txt", rows.KeepKeys(c.Agencies, "agency_id"))
+	}
+	f.AddProcessors("attributions.txt",
+		rows.KeepKeys(c.Agencies, "agency_id"),
+		rows.KeepKeys(c.Routes, "route_id"),
+		rows.KeepKeys(c.Trips, "trip_id"),
+	)
+	f.AddProcessors("route_networks.txt", rows.KeepKeys(c.Routes, "route_id"))
+}
+
+func makeSet(keys []string) rows.KeySet {
+	s := make(rows.KeySet, len(keys))
+	for _, k := range keys {
+		s.Add(k)
+	}
+	return s
+}
+
+func addNonEmpty(s rows.KeySet, key string) {
+	if key != "" {
+		s.Add(key)
+	}
+}
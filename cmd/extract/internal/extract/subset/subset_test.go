@@ -0,0 +1,165 @@
+package subset_test
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed/feedtest"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/subset"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+var testFeed = map[string]string{
+	"agency.txt": "agency_id,agency_name\nA1,LPP\nA2,Arriva\n",
+	"routes.txt": "route_id,agency_id,route_type\nR6,A1,3\nR11,A1,3\nRT,A2,2\n",
+	"trips.txt":  "route_id,service_id,trip_id,shape_id\nR6,WD,T6a,S6\nR6,WE,T6b,S6\nR11,WD,T11a,S11\nRT,SA,TTa,\n",
+	"stop_times.txt": "trip_id,stop_id,stop_sequence\n" +
+		"T6a,P1,1\nT6a,P2,2\nT6b,P1,1\nT11a,P2,1\nT11a,P4,2\nTTa,P5,1\n",
+	"stops.txt": "stop_id,location_type,parent_station\n" +
+		"ST1,1,\nP1,0,ST1\nE1,2,ST1\nP2,0,\nP4,0,\nP5,0,\n",
+	"calendar.txt":    "service_id,start_date,end_date\nWD,20250101,20251231\nWE,20250101,20251231\nSA,20250101,20251231\n",
+	"shapes.txt":      "shape_id,shape_pt_sequence\nS6,1\nS11,1\n",
+	"frequencies.txt": "trip_id,headway_secs\nT6a,600\nT11a,600\n",
+	"transfers.txt":   "from_stop_id,to_stop_id,transfer_type\nP1,P2,2\nP4,P5,2\n",
+	"fare_rules.txt":  "fare_id,route_id\nF1,R6\nF1,R11\nF2,RT\n",
+}
+
+func TestApply(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	type column struct {
+		file  string
+		field string
+	}
+	tests := []struct {
+		name      string
+		selection subset.Selection
+		want      map[column][]string
+	}{
+		{
+			name:      "single route",
+			selection: subset.Selection{Routes: []string{"R6"}},
+			want: map[column][]string{
+				{"agency.txt", "agency_id"}:       {"A1"},
+				{"routes.txt", "route_id"}:        {"R6"},
+				{"trips.txt", "trip_id"}:          {"T6a", "T6b"},
+				{"stop_times.txt", "trip_id"}:     {"T6a", "T6a", "T6b"},
+				{"stops.txt", "stop_id"}:          {"E1", "P1", "P2", "ST1"},
+				{"calendar.txt", "service_id"}:    {"WD", "WE"},
+				{"shapes.txt", "shape_id"}:        {"S6"},
+				{"frequencies.txt", "trip_id"}:    {"T6a"},
+				{"transfers.txt", "from_stop_id"}: {"P1"},
+				{"fare_rules.txt", "route_id"}:    {"R6"},
+			},
+		},
+		{
+			name:      "agency",
+			selection: subset.Selection{Agencies: []string{"A2"}},
+			want: map[column][]string{
+				{"agency.txt", "agency_id"}:     {"A2"},
+				{"routes.txt", "route_id"}:      {"RT"},
+				{"stops.txt", "stop_id"}:        {"P5"},
+				{"calendar.txt", "service_id"}:  {"SA"},
+				{"shapes.txt", "shape_id"}:      nil,
+				{"transfers.txt", "to_stop_id"}: nil,
+			},
+		},
+		{
+			name:      "trip doesn't pull in the other trips of its route",
+			selection: subset.Selection{Trips: []string{"T6a"}},
+			want: map[column][]string{
+				{"routes.txt", "route_id"}:     {"R6"},
+				{"trips.txt", "trip_id"}:       {"T6a"},
+				{"stop_times.txt", "trip_id"}:  {"T6a", "T6a"},
+				{"calendar.txt", "service_id"}: {"WD"},
+				{"frequencies.txt", "trip_id"}: {"T6a"},
+			},
+		},
+		{
+			name:      "single trip pulls in its route",
+			selection: subset.Selection{Trips: []string{"T11a"}},
+			want: map[column][]string{
+				{"routes.txt", "route_id"}:      {"R11"},
+				{"trips.txt", "trip_id"}:        {"T11a"},
+				{"stops.txt", "stop_id"}:        {"P2", "P4"},
+				{"frequencies.txt", "trip_id"}:  {"T11a"},
+				{"transfers.txt", "to_stop_id"}: nil,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := feedtest.NewFeed(t, testFeed, nil)
+			if _, err := subset.Apply(f, tt.selection, reporter); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			for col, want := range tt.want {
+				got := feedtest.Column(t, f, col.file, col.field)
+				if !slices.Equal(got, want) {
+					t.Errorf("%s %s = %v, want %v", col.file, col.field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApply_EmptySelection(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	withoutAgencies := map[string]string{
+		"routes.txt": "route_id,route_type\nR6,3\n",
+		"trips.txt":  "route_id,service_id,trip_id\nR6,WD,T6a\n",
+	}
+	singleAgency := maps.Clone(withoutAgencies)
+	singleAgency["agency.txt"] = "agency_id,agency_name\nLPP,Ljubljanski potniški promet\n"
+	tests := []struct {
+		name      string
+		files     map[string]string
+		selection subset.Selection
+	}{
+		{"missing route", testFeed, subset.Selection{Routes: []string{"missing"}}},
+		{"missing agency of single agency feed", singleAgency, subset.Selection{Agencies: []string{"NOPE"}}},
+		{"agency of feed without agencies", withoutAgencies, subset.Selection{Agencies: []string{"LPP"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := feedtest.NewFeed(t, tt.files, nil)
+			_, err := subset.Apply(f, tt.selection, reporter)
+			if !errors.Is(err, subset.ErrEmptySelection) {
+				t.Errorf("Apply() error = %v, want %v", err, subset.ErrEmptySelection)
+			}
+		})
+	}
+}
+
+// agency_id of routes is optional in a feed with a single agency, which must still be kept
+func TestApply_SingleAgency(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	files := map[string]string{
+		"agency.txt": "agency_id,agency_name\nLPP,Ljubljanski potniški promet\n",
+		"routes.txt": "route_id,route_type\nR6,3\nR11,3\n",
+		"trips.txt":  "route_id,service_id,trip_id\nR6,WD,T6a\nR11,WD,T11a\n",
+	}
+	tests := []struct {
+		name       string
+		selection  subset.Selection
+		wantRoutes []string
+	}{
+		{"route", subset.Selection{Routes: []string{"R6"}}, []string{"R6"}},
+		{"agency", subset.Selection{Agencies: []string{"LPP"}}, []string{"R11", "R6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := feedtest.NewFeed(t, files, nil)
+			if _, err := subset.Apply(f, tt.selection, reporter); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if got, want := feedtest.Column(t, f, "agency.txt", "agency_id"), []string{"LPP"}; !slices.Equal(got, want) {
+				t.Errorf("agency.txt agency_id = %v, want %v", got, want)
+			}
+			if got := feedtest.Column(t, f, "routes.txt", "route_id"); !slices.Equal(got, tt.wantRoutes) {
+				t.Errorf("routes.txt route_id = %v, want %v", got, tt.wantRoutes)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+// Package window clips a GTFS feed to a service date window.
+// Calendars are clipped to the window, calendar date exceptions outside of it are dropped,
+// and services without any remaining active day are removed together with their trips and
+// everything that depends on those trips.
+package window
@@ -0,0 +1,211 @@
+package window
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+// DateLayout is the GTFS date format
+const DateLayout = "20060102"
+
+var weekdayFields = [7]string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// Window is a closed range of service dates. A zero From or To leaves that side open.
+type Window struct {
+	From time.Time
+	To   time.Time
+}
+
+// IsEmpty reports whether the window doesn't restrict anything.
+func (w Window) IsEmpty() bool {
+	return w.From.IsZero() && w.To.IsZero()
+}
+
+func (w Window) String() string {
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return "..."
+		}
+		return t.Format(DateLayout)
+	}
+	return format(w.From) + " - " + format(w.To)
+}
+
+// clip returns the intersection of the window with the range, and false if they don't overlap.
+func (w Window) clip(start, end time.Time) (time.Time, time.Time, bool) {
+	if !w.From.IsZero() && start.Before(w.From) {
+		start = w.From
+	}
+	if !w.To.IsZero() && end.After(w.To) {
+		end = w.To
+	}
+	return start, end, !start.After(end)
+}
+
+func (w Window) contains(date time.Time) bool {
+	return (w.From.IsZero() || !date.Before(w.From)) && (w.To.IsZero() || !date.After(w.To))
+}
+
+// calendarEntry is a calendar.txt row clipped to the window
+type calendarEntry struct {
+	start    time.Time
+	end      time.Time
+	weekdays [7]bool
+}
+
+// Apply clips the feed to the window by registering row processors on the feed.
+func Apply(f *feed.Feed, w Window, log logging.LogReporter) error {
+	calendars := map[string]*calendarEntry{}
+	services := rows.KeySet{}
+
+	err := f.ReadTable("calendar.txt", func(row feed.Row) error {
+		serviceID := row.Get("service_id")
+		services.Add(serviceID)
+		start, err := time.Parse(DateLayout, row.Get("start_date"))
+		if err != nil {
+			return fmt.Errorf("invalid start_date of service %s in calendar.txt: %w", serviceID, err)
+		}
+		end, err := time.Parse(DateLayout, row.Get("end_date"))
+		if err != nil {
+			return fmt.Errorf("invalid end_date of service %s in calendar.txt: %w", serviceID, err)
+		}
+		start, end, ok := w.clip(start, end)
+		if !ok {
+			return nil
+		}
+		entry := &calendarEntry{start: start, end: end}
+		for i, field := range weekdayFields {
+			entry.weekdays[i] = row.Get(field) == "1"
+		}
+		calendars[serviceID] = entry
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Exceptions inside the window
+	added := rows.KeySet{}
+	removed := map[string][]time.Time{}
+	err = f.ReadTable("calendar_dates.txt", func(row feed.Row) error {
+		serviceID := row.Get("service_id")
+		services.Add(serviceID)
+		date, err := time.Parse(DateLayout, row.Get("date"))
+		if err != nil {
+			return fmt.Errorf("invalid date of service %s in calendar_dates.txt: %w", serviceID, err)
+		}
+		if !w.contains(date) {
+			return nil
+		}
+		switch row.Get("exception_type") {
+		case "1":
+			added.Add(serviceID)
+		case "2":
+			removed[serviceID] = append(removed[serviceID], date)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// A calendar entry is only kept if at least one of its days isn't removed by an exception
+	for serviceID, entry := range calendars {
+		if !entry.hasActiveDay(removed[serviceID]) {
+			delete(calendars, serviceID)
+		}
+	}
+
+	active := rows.KeySet{}
+	for serviceID := range services {
+		if _, ok := calendars[serviceID]; ok || added.Has(serviceID) {
+			active.Add(serviceID)
+		}
+	}
+
+	// Trips of removed services, and everything depending on them
+	trips := rows.KeySet{}
+	removedTrips := 0
+	err = f.ReadTable("trips.txt", func(row feed.Row) error {
+		if active.Has(row.Get("service_id")) {
+			trips.Add(row.Get("trip_id"))
+		} else {
+			removedTrips++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f.AddProcessors("calendar.txt", clipCalendar(calendars))
+	f.AddProcessors("calendar_dates.txt", rows.KeepKeys(active, "service_id"), keepDatesIn(w))
+	f.AddProcessors("trips.txt", rows.KeepKeys(trips, "trip_id"))
+	f.AddProcessors("stop_times.txt", rows.KeepKeys(trips, "trip_id"))
+	f.AddProcessors("frequencies.txt", rows.KeepKeys(trips, "trip_id"))
+	f.AddProcessors("transfers.txt", rows.KeepKeys(trips, "from_trip_id", "to_trip_id"))
+	f.AddProcessors("attributions.txt", rows.KeepKeys(trips, "trip_id"))
+
+	log(logging.Verbose, "Service window keeps %d of %d services, removes %d trips",
+		len(active), len(services), removedTrips)
+	return nil
+}
+
+// hasActiveDay reports whether the entry has a day of service that isn't in removed.
+func (c *calendarEntry) hasActiveDay(removed []time.Time) bool {
+	for d := c.start; !d.After(c.end); d = d.AddDate(0, 0, 1) {
+		if c.weekdays[d.Weekday()] && !slices.ContainsFunc(removed, d.Equal) {
+			return true
+		}
+	}
+	return false
+}
+
+// clipCalendar keeps the calendar rows of entries and rewrites their dates to the clipped range.
+func clipCalendar(entries map[string]*calendarEntry) rows.Processor {
+	return rows.ProcessorFunc(func(header []string) (rows.Func, error) {
+		serviceIdx := slices.Index(header, "service_id")
+		startIdx := slices.Index(header, "start_date")
+		endIdx := slices.Index(header, "end_date")
+		if serviceIdx < 0 || startIdx < 0 || endIdx < 0 {
+			return nil, fmt.Errorf("calendar.txt must have service_id, start_date and end_date fields")
+		}
+		return func(record []string) (bool, error) {
+			if len(record) != len(header) {
+				return false, nil
+			}
+			entry, ok := entries[record[serviceIdx]]
+			if !ok {
+				return false, nil
+			}
+			record[startIdx] = entry.start.Format(DateLayout)
+			record[endIdx] = entry.end.Format(DateLayout)
+			return true, nil
+		}, nil
+	})
+}
+
+// keepDatesIn keeps calendar_dates.txt rows whose date is inside the window.
+func keepDatesIn(w Window) rows.Processor {
+	return rows.ProcessorFunc(func(header []string) (rows.Func, error) {
+		dateIdx := slices.Index(header, "date")
+		if dateIdx < 0 {
+			return nil, fmt.Errorf("calendar_dates.txt must have a date field")
+		}
+		return func(record []string) (bool, error) {
+			if dateIdx >= len(record) {
+				return false, nil
+			}
+			date, err := time.Parse(DateLayout, record[dateIdx])
+			if err != nil {
+				return false, fmt.Errorf("invalid date %q in calendar_dates.txt: %w", record[dateIdx], err)
+			}
+			return w.contains(date), nil
+		}, nil
+	})
+}
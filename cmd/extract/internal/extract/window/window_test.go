@@ -0,0 +1,102 @@
+package window_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/feed/feedtest"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/extract/window"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+)
+
+var testFeed = map[string]string{
+	"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+		"WD,1,1,1,1,1,0,0,20250101,20251231\n" +
+		"WE,0,0,0,0,0,1,1,20250101,20251231\n" +
+		"SUMMER,1,1,1,1,1,1,1,20250701,20250831\n",
+	"calendar_dates.txt": "service_id,date,exception_type\n" +
+		"WD,20250101,2\n" +
+		"WE,20250101,1\n" +
+		"EXTRA,20250103,1\n" +
+		"EXTRA,20250110,1\n",
+	"trips.txt":       "route_id,service_id,trip_id\nR1,WD,T1\nR1,WE,T2\nR1,SUMMER,T3\nR1,EXTRA,T4\n",
+	"stop_times.txt":  "trip_id,stop_id,stop_sequence\nT1,S1,1\nT2,S1,1\nT3,S1,1\nT4,S1,1\n",
+	"frequencies.txt": "trip_id,headway_secs\nT1,600\nT3,600\n",
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse(window.DateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestApply(t *testing.T) {
+	var reporter logging.LogReporter = func(level logging.StatusLevel, format string, a ...any) {}
+	tests := []struct {
+		name          string
+		window        window.Window
+		wantCalendar  []string
+		wantDates     []string
+		wantTrips     []string
+		wantFrequency []string
+	}{
+		{
+			name:          "first week of january",
+			window:        window.Window{From: date("20250101"), To: date("20250107")},
+			wantCalendar:  []string{"WD,20250101,20250107", "WE,20250101,20250107"},
+			wantDates:     []string{"WD,20250101", "WE,20250101", "EXTRA,20250103"},
+			wantTrips:     []string{"T1", "T2", "T4"},
+			wantFrequency: []string{"T1"},
+		},
+		{
+			name:          "only a holiday removed from weekday service",
+			window:        window.Window{From: date("20250101"), To: date("20250101")},
+			wantCalendar:  nil,
+			wantDates:     []string{"WE,20250101"},
+			wantTrips:     []string{"T2"},
+			wantFrequency: nil,
+		},
+		{
+			name:          "open start",
+			window:        window.Window{To: date("20250102")},
+			wantCalendar:  []string{"WD,20250101,20250102"},
+			wantDates:     []string{"WD,20250101", "WE,20250101"},
+			wantTrips:     []string{"T1", "T2"},
+			wantFrequency: []string{"T1"},
+		},
+		{
+			name:          "summer",
+			window:        window.Window{From: date("20250801")},
+			wantCalendar:  []string{"WD,20250801,20251231", "WE,20250801,20251231", "SUMMER,20250801,20250831"},
+			wantDates:     nil,
+			wantTrips:     []string{"T1", "T2", "T3"},
+			wantFrequency: []string{"T1", "T3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := feedtest.NewFeed(t, testFeed, nil)
+			if err := window.Apply(f, tt.window, reporter); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if got := feedtest.Rows(t, f, "calendar.txt", "service_id", "start_date", "end_date"); !slices.Equal(got, tt.wantCalendar) {
+				t.Errorf("calendar.txt = %v, want %v", got, tt.wantCalendar)
+			}
+			if got := feedtest.Rows(t, f, "calendar_dates.txt", "service_id", "date"); !slices.Equal(got, tt.wantDates) {
+				t.Errorf("calendar_dates.txt = %v, want %v", got, tt.wantDates)
+			}
+			if got := feedtest.Rows(t, f, "trips.txt", "trip_id"); !slices.Equal(got, tt.wantTrips) {
+				t.Errorf("trips.txt = %v, want %v", got, tt.wantTrips)
+			}
+			if got := feedtest.Rows(t, f, "stop_times.txt", "trip_id"); !slices.Equal(got, tt.wantTrips) {
+				t.Errorf("stop_times.txt = %v, want %v", got, tt.wantTrips)
+			}
+			if got := feedtest.Rows(t, f, "frequencies.txt", "trip_id"); !slices.Equal(got, tt.wantFrequency) {
+				t.Errorf("frequencies.txt = %v, want %v", got, tt.wantFrequency)
+			}
+		})
+	}
+}
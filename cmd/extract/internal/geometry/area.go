@@ -0,0 +1,189 @@
+package geometry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidBBox    = errors.New("invalid bounding box; must be minlon,minlat,maxlon,maxlat")
+	ErrInvalidGeoJSON = errors.New("invalid GeoJSON; must contain Polygon or MultiPolygon geometries")
+)
+
+// Area is a geographic area that can tell whether a WGS84 point lies inside it.
+type Area interface {
+	Contains(lon, lat float64) bool
+}
+
+// BBox is a bounding box in WGS84 coordinates.
+type BBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// ParseBBox parses a bounding box in the format minlon,minlat,maxlon,maxlat.
+func ParseBBox(s string) (BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BBox{}, ErrInvalidBBox
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return BBox{}, fmt.Errorf("%w: %v", ErrInvalidBBox, err)
+		}
+		v[i] = f
+	}
+	b := BBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if b.MinLon > b.MaxLon || b.MinLat > b.MaxLat {
+		return BBox{}, fmt.Errorf("%w: minimum is larger than maximum", ErrInvalidBBox)
+	}
+	return b, nil
+}
+
+func (b BBox) Contains(lon, lat float64) bool {
+	return lon >= b.MinLon && lon <= b.MaxLon && lat >= b.MinLat && lat <= b.MaxLat
+}
+
+// ring is a closed sequence of [lon, lat] points
+type ring [][2]float64
+
+// polygon is an outer ring with optional holes
+type polygon []ring
+
+// Polygons is a set of polygons with holes, as read from GeoJSON. A point is inside if it is inside any of the polygons.
+type Polygons []polygon
+
+func (p Polygons) Contains(lon, lat float64) bool {
+	for _, poly := range p {
+		if len(poly) == 0 || !poly[0].contains(lon, lat) {
+			continue
+		}
+		inHole := false
+		for _, hole := range poly[1:] {
+			if hole.contains(lon, lat) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// contains uses the even-odd rule, casting a ray in the positive longitude direction
+func (r ring) contains(lon, lat float64) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi := r[i][0], r[i][1]
+		xj, yj := r[j][0], r[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// geoJSON covers the GeoJSON objects that can hold polygons
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Geometries  []geoJSON       `json:"geometries"`
+	Features    []geoJSON       `json:"features"`
+}
+
+// LoadGeoJSON reads all Polygon and MultiPolygon geometries from a GeoJSON file.
+func LoadGeoJSON(path string) (Polygons, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGeoJSON(data)
+}
+
+// ParseGeoJSON reads all Polygon and MultiPolygon geometries from GeoJSON data, which may be
+// a geometry, a Feature, a FeatureCollection or a GeometryCollection.
+func ParseGeoJSON(data []byte) (Polygons, error) {
+	var root geoJSON
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+	}
+	var result Polygons
+	if err := collectPolygons(&root, &result); err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, ErrInvalidGeoJSON
+	}
+	return result, nil
+}
+
+func collectPolygons(g *geoJSON, result *Polygons) error {
+	switch g.Type {
+	case "FeatureCollection":
+		for i := range g.Features {
+			if err := collectPolygons(&g.Features[i], result); err != nil {
+				return err
+			}
+		}
+	case "GeometryCollection":
+		for i := range g.Geometries {
+			if err := collectPolygons(&g.Geometries[i], result); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if g.Geometry != nil {
+			return collectPolygons(g.Geometry, result)
+		}
+	case "Polygon":
+		var coords [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+		}
+		p, err := toPolygon(coords)
+		if err != nil {
+			return err
+		}
+		*result = append(*result, p)
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+		}
+		for _, c := range coords {
+			p, err := toPolygon(c)
+			if err != nil {
+				return err
+			}
+			*result = append(*result, p)
+		}
+	}
+	// Other geometries can't contain points, so they are ignored
+	return nil
+}
+
+func toPolygon(coords [][][]float64) (polygon, error) {
+	p := make(polygon, 0, len(coords))
+	for _, c := range coords {
+		r := make(ring, 0, len(c))
+		for _, position := range c {
+			if len(position) < 2 {
+				return nil, fmt.Errorf("%w: position must have at least 2 coordinates", ErrInvalidGeoJSON)
+			}
+			r = append(r, [2]float64{position[0], position[1]})
+		}
+		if len(r) < 3 {
+			return nil, fmt.Errorf("%w: ring must have at least 3 positions", ErrInvalidGeoJSON)
+		}
+		p = append(p, r)
+	}
+	return p, nil
+}
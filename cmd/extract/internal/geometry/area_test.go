@@ -0,0 +1,88 @@
+package geometry_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/geometry"
+)
+
+func TestParseBBox(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    geometry.BBox
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			input: "14.4,46.0,14.6,46.1",
+			want:  geometry.BBox{MinLon: 14.4, MinLat: 46.0, MaxLon: 14.6, MaxLat: 46.1},
+		},
+		{name: "too few values", input: "14.4,46.0,14.6", wantErr: true},
+		{name: "not a number", input: "14.4,46.0,abc,46.1", wantErr: true},
+		{name: "min larger than max", input: "14.6,46.0,14.4,46.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := geometry.ParseBBox(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBBox() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, geometry.ErrInvalidBBox) {
+					t.Errorf("ParseBBox() error = %v, want %v", err, geometry.ErrInvalidBBox)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseBBox() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolygons_Contains(t *testing.T) {
+	// A square with a square hole in the middle, and a separate triangle
+	geojson := `{
+		"type": "FeatureCollection",
+		"features": [
+			{"type": "Feature", "properties": {}, "geometry": {"type": "Polygon", "coordinates": [
+				[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]],
+				[[4, 4], [6, 4], [6, 6], [4, 6], [4, 4]]
+			]}},
+			{"type": "Feature", "properties": {}, "geometry": {"type": "MultiPolygon", "coordinates": [
+				[[[20, 0], [30, 0], [20, 10], [20, 0]]]
+			]}}
+		]
+	}`
+	area, err := geometry.ParseGeoJSON([]byte(geojson))
+	if err != nil {
+		t.Fatalf("ParseGeoJSON() failed: %v", err)
+	}
+	tests := []struct {
+		name     string
+		lon, lat float64
+		want     bool
+	}{
+		{name: "inside square", lon: 1, lat: 1, want: true},
+		{name: "inside hole", lon: 5, lat: 5, want: false},
+		{name: "outside", lon: 15, lat: 5, want: false},
+		{name: "inside triangle", lon: 21, lat: 2, want: true},
+		{name: "outside triangle, inside its bbox", lon: 29, lat: 9, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := area.Contains(tt.lon, tt.lat); got != tt.want {
+				t.Errorf("Contains(%v, %v) = %v, want %v", tt.lon, tt.lat, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGeoJSON_NoPolygons(t *testing.T) {
+	_, err := geometry.ParseGeoJSON([]byte(`{"type": "Point", "coordinates": [14.5, 46.05]}`))
+	if !errors.Is(err, geometry.ErrInvalidGeoJSON) {
+		t.Errorf("ParseGeoJSON() error = %v, want %v", err, geometry.ErrInvalidGeoJSON)
+	}
+}
@@ -0,0 +1,3 @@
+// Package geometry provides the geographic primitives used by the extract command:
+// areas for geographic extraction (bounding boxes and GeoJSON polygons) and polyline simplification for shapes.
+package geometry
@@ -0,0 +1,80 @@
+package geometry
+
+import "math"
+
+// earthRadius is the mean Earth radius in meters
+const earthRadius = 6371008.8
+
+// Point is a WGS84 point.
+type Point struct {
+	Lon, Lat float64
+}
+
+// Simplify simplifies a polyline with the Douglas-Peucker algorithm and reports which points are kept.
+// No removed point is further than tolerance meters from the simplified polyline.
+// The first and last point are always kept.
+func Simplify(points []Point, tolerance float64) []bool {
+	keep := make([]bool, len(points))
+	if len(points) == 0 {
+		return keep
+	}
+	keep[0] = true
+	keep[len(points)-1] = true
+	if len(points) < 3 {
+		return keep
+	}
+
+	projected := project(points)
+	// ranges of points still to simplify, as indices of their end points
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		r := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		first, last := r[0], r[1]
+
+		farthest, maxDist := -1, tolerance
+		for i := first + 1; i < last; i++ {
+			if d := segmentDistance(projected[i], projected[first], projected[last]); d > maxDist {
+				farthest, maxDist = i, d
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+		keep[farthest] = true
+		stack = append(stack, [2]int{first, farthest}, [2]int{farthest, last})
+	}
+	return keep
+}
+
+// project converts points to meters on an equirectangular projection centered on the first point,
+// which is accurate enough for the extent of a single shape.
+func project(points []Point) [][2]float64 {
+	toRad := math.Pi / 180
+	cosLat := math.Cos(points[0].Lat * toRad)
+	projected := make([][2]float64, len(points))
+	for i, p := range points {
+		projected[i] = [2]float64{
+			(p.Lon - points[0].Lon) * toRad * cosLat * earthRadius,
+			(p.Lat - points[0].Lat) * toRad * earthRadius,
+		}
+	}
+	return projected
+}
+
+// segmentDistance returns the distance of p from the segment between a and b.
+func segmentDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if lengthSq := dx*dx + dy*dy; lengthSq > 0 {
+		t = ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / lengthSq
+		t = max(0, min(1, t))
+	}
+	return math.Hypot(p[0]-(a[0]+t*dx), p[1]-(a[1]+t*dy))
+}
+
+// Round rounds a coordinate to the given number of decimal places.
+func Round(v float64, precision int) float64 {
+	scale := math.Pow10(precision)
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,62 @@
+package geometry_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/geometry"
+)
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		name      string
+		points    []geometry.Point
+		tolerance float64
+		want      []bool
+	}{
+		{
+			name:      "empty",
+			points:    nil,
+			tolerance: 10,
+			want:      []bool{},
+		},
+		{
+			name:      "end points are always kept",
+			points:    []geometry.Point{{14.5, 46}, {14.5, 46}},
+			tolerance: 10,
+			want:      []bool{true, true},
+		},
+		{
+			name:      "collinear",
+			points:    []geometry.Point{{14.5, 46}, {14.501, 46}, {14.502, 46}, {14.503, 46}},
+			tolerance: 1,
+			want:      []bool{true, false, false, true},
+		},
+		{
+			// 0.00001° of latitude is about 1.1 m
+			name:      "deviation within tolerance",
+			points:    []geometry.Point{{14.5, 46}, {14.501, 46.00001}, {14.502, 46}},
+			tolerance: 5,
+			want:      []bool{true, false, true},
+		},
+		{
+			name:      "deviation over tolerance",
+			points:    []geometry.Point{{14.5, 46}, {14.501, 46.00001}, {14.502, 46}},
+			tolerance: 0.5,
+			want:      []bool{true, true, true},
+		},
+		{
+			name:      "corner",
+			points:    []geometry.Point{{14.5, 46}, {14.501, 46}, {14.502, 46}, {14.502, 46.001}, {14.502, 46.002}},
+			tolerance: 5,
+			want:      []bool{true, false, true, false, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := geometry.Simplify(tt.points, tt.tolerance); !slices.Equal(got, tt.want) {
+				t.Errorf("Simplify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
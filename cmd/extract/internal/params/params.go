@@ -4,8 +4,19 @@ import (
 	"errors"
 	"fmt"
 	"iter"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/geometry"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/pattern"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/predicate"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/transform"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/columnorder"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/schema"
 )
 
 var (
@@ -16,8 +27,80 @@ var (
 	ErrFieldOverlap            = errors.New("a field cannot be both included and excluded")
 	ErrNotParsed               = errors.New("parameters not parsed")
 	ErrParsingFailed           = errors.New("parsing parameters failed")
+	ErrInvalidWhere            = errors.New("invalid where format; must be filename,expression")
+	ErrInvalidTransformFormat  = errors.New("invalid transform format; must be filename,field,operation[,argument]")
+	ErrInvalidDate             = errors.New("invalid date; must be in YYYYMMDD format")
+	ErrInvalidDateWindow       = errors.New("from-date must not be after to-date")
+	ErrMutuallyExclusiveArea   = errors.New("bbox and polygon flags are mutually exclusive")
+	ErrClipWithoutArea         = errors.New("clip-trips flag requires bbox or polygon")
+	ErrInvalidTolerance        = errors.New("simplify-shapes tolerance must not be negative")
+	ErrInvalidPrecision        = errors.New("shape-precision must not be negative")
+	ErrInvalidJobs             = errors.New("jobs must not be negative")
+	ErrInvalidRename           = errors.New("invalid rename fields format; must be filename,old=new,...")
+	ErrRenameCollision         = errors.New("renamed field collides with another field")
+	ErrInvalidMemoryLimit      = errors.New("invalid memory limit; must be a number of bytes with an optional KiB, MiB or GiB suffix")
+	ErrSimplifyExcludedShapes  = errors.New("simplify-shapes and shape-precision flags cannot be used with exclude-shapes")
+	ErrInvalidSort             = errors.New("invalid sort format; must be filename,field1,field2,...")
+	ErrInvalidDedupe           = errors.New("invalid dedupe policy; must be keep-first, keep-last or fail")
+	ErrInvalidSample           = errors.New("sample-trips must not be negative")
+	ErrPerRouteWithoutSample   = errors.New("sample-per-route flag requires sample-trips")
+	ErrMinimalExcludedFiles    = errors.New("minimal flag cannot be used with exclude-files, use include-files to keep more files")
+	ErrRemovesRequired         = errors.New("extraction would remove files or fields required by the GTFS reference; use allow-invalid to extract anyway")
 )
 
+// DedupePolicy decides which of the rows with the same primary key is kept.
+type DedupePolicy string
+
+const (
+	DedupeOff       DedupePolicy = ""
+	DedupeKeepFirst DedupePolicy = "keep-first"
+	DedupeKeepLast  DedupePolicy = "keep-last"
+	// Rows with the same primary key and different values are an error
+	DedupeFail DedupePolicy = "fail"
+)
+
+// minimalFiles are the files of --minimal, those a consumer needs for a valid feed
+var minimalFiles = []string{
+	"agency.txt",
+	"stops.txt",
+	"routes.txt",
+	"trips.txt",
+	"stop_times.txt",
+	"calendar.txt",
+	"calendar_dates.txt",
+	"feed_info.txt",
+}
+
+// minimalDroppedFields are the conditionally required fields of minimalFiles that are only required
+// for optional features --minimal drops, like shape_id for continuous stops
+var minimalDroppedFields = map[string][]string{
+	"trips.txt":      {"shape_id"},
+	"stop_times.txt": {"start_pickup_drop_off_window", "end_pickup_drop_off_window"},
+}
+
+// minimalAddedFields are the optional fields of minimalFiles that kept fields depend on. Without location_type,
+// stations and entrances referenced by parent_station would read as stops, which makes the feed invalid.
+var minimalAddedFields = map[string][]string{
+	"stops.txt": {"location_type"},
+}
+
+// SortKey is a field the rows of an output file are sorted by.
+type SortKey struct {
+	Field string
+	// Compare values as numbers, like stop_sequence, instead of as text
+	Numeric bool
+}
+
+// canonicalSorts are the sort keys of --sort-canonical, the order consumers usually expect
+var canonicalSorts = []string{
+	"stop_times.txt,trip_id,stop_sequence",
+	"shapes.txt,shape_id,shape_pt_sequence",
+	"calendar_dates.txt,service_id,date",
+}
+
+// DefaultMemoryLimit is the default number of bytes of rows buffered in memory per file while excluding empty fields.
+const DefaultMemoryLimit = 256 << 20
+
 // ExtractParams holds the parameters for the extract command, including file and field filters.
 // It also provides methods for parsing and validating these parameters.
 type ExtractParams struct {
@@ -38,6 +121,85 @@ type ExtractParams struct {
 	// format filename,fieldnames
 	_includedFields []string
 
+	// set after parsing, file name -> old field name -> new field name
+	renamedFields map[string]map[string]string
+	// format filename,old=new,...
+	_renamedFields []string
+
+	// set after parsing
+	transforms map[string][]*transform.Transform
+	// format filename,field,operation[,argument]
+	_transforms []string
+
+	// set after parsing
+	where map[string][]*predicate.Predicate
+	// format filename,expression
+	_where []string
+
+	keepAgencies []string
+	keepRoutes   []string
+	keepTrips    []string
+
+	// set after parsing, zero if not given
+	fromDate time.Time
+	toDate   time.Time
+	// format YYYYMMDD
+	_fromDate string
+	_toDate   string
+
+	// set after parsing, nil if not given
+	area      geometry.Area
+	clipTrips bool
+	// format minlon,minlat,maxlon,maxlat
+	_bbox string
+	// path to a GeoJSON file
+	_polygon string
+
+	// number of trips sampled, or of trips of every route if samplePerRoute, zero if not sampled
+	sampleTrips    int
+	sampleSeed     int64
+	samplePerRoute bool
+
+	pruneOrphans bool
+
+	// in meters, zero if shapes aren't simplified
+	simplifyShapes float64
+	// decimal places, zero if coordinates aren't rounded
+	shapePrecision int
+
+	// number of files extracted concurrently, zero for the number of CPUs
+	jobs int
+
+	// set after parsing
+	memoryLimit int64
+	// format number with optional KiB, MiB or GiB suffix, empty for DefaultMemoryLimit
+	_memoryLimit string
+
+	// fail on malformed rows instead of skipping them
+	strict bool
+
+	// DedupeOff to keep duplicate rows
+	dedupe DedupePolicy
+
+	// set after parsing, file name -> sort keys
+	sorts map[string][]SortKey
+	// format filename,field1,field2,...
+	_sorts         []string
+	canonicalSorts bool
+
+	// set after parsing
+	columnOrder *columnorder.Order
+	// input, canonical or custom
+	_columnOrder string
+	// format filename,field1,field2,...
+	_customColumnOrder []string
+
+	// keep only required and conditionally required files and fields
+	minimal bool
+
+	// remove required files and fields with only a warning
+	allowInvalid bool
+
 	parsed bool
 }
 
@@ -105,6 +267,131 @@ func internalNew(
 	}
 }
 
+// WithRenamedFields sets the fields to rename, in the format filename,old=new,... Fields are renamed as soon as
+// the header is read, so all other options refer to fields by their new names. Must be called before parsing.
+func (e *ExtractParams) WithRenamedFields(renamedFields []string) *ExtractParams {
+	e._renamedFields = renamedFields
+	return e
+}
+
+// WithTransforms sets the value transformations, in the format filename,field,operation[,argument].
+// Must be called before parsing.
+func (e *ExtractParams) WithTransforms(transforms []string) *ExtractParams {
+	e._transforms = transforms
+	return e
+}
+
+// WithWhere sets the row filters, in the format filename,expression. Must be called before parsing.
+func (e *ExtractParams) WithWhere(where []string) *ExtractParams {
+	e._where = where
+	return e
+}
+
+// WithKeep sets the agencies, routes and trips to extract, together with everything they reference.
+func (e *ExtractParams) WithKeep(agencies, routes, trips []string) *ExtractParams {
+	e.keepAgencies = agencies
+	e.keepRoutes = routes
+	e.keepTrips = trips
+	return e
+}
+
+// WithDateWindow sets the service date window (dates in YYYYMMDD format, empty for an open side).
+// Must be called before parsing.
+func (e *ExtractParams) WithDateWindow(from, to string) *ExtractParams {
+	e._fromDate = from
+	e._toDate = to
+	return e
+}
+
+// WithArea sets the geographic area to extract, either as a bounding box or a GeoJSON polygon file,
+// and whether trips are truncated to the area. Must be called before parsing.
+func (e *ExtractParams) WithArea(bbox, polygonFile string, clipTrips bool) *ExtractParams {
+	e._bbox = bbox
+	e._polygon = polygonFile
+	e.clipTrips = clipTrips
+	return e
+}
+
+// WithSample sets the number of trips sampled, chosen at random with the seed, or the first trips of
+// every route if perRoute. Zero trips disables sampling.
+func (e *ExtractParams) WithSample(trips int, seed int64, perRoute bool) *ExtractParams {
+	e.sampleTrips = trips
+	e.sampleSeed = seed
+	e.samplePerRoute = perRoute
+	return e
+}
+
+// WithPruneOrphans sets whether unreferenced entities are removed from the output.
+func (e *ExtractParams) WithPruneOrphans(pruneOrphans bool) *ExtractParams {
+	e.pruneOrphans = pruneOrphans
+	return e
+}
+
+// WithShapeSimplification sets the tolerance in meters of shape simplification (zero to disable it)
+// and the number of decimal places shape coordinates are rounded to (zero to disable rounding).
+func (e *ExtractParams) WithShapeSimplification(tolerance float64, precision int) *ExtractParams {
+	e.simplifyShapes = tolerance
+	e.shapePrecision = precision
+	return e
+}
+
+// WithJobs sets the number of files extracted concurrently, zero for the number of CPUs. Must be called before parsing.
+func (e *ExtractParams) WithJobs(jobs int) *ExtractParams {
+	e.jobs = jobs
+	return e
+}
+
+// WithMemoryLimit sets how many bytes of rows of a file are buffered in memory while excluding empty fields,
+// as a number with an optional KiB, MiB or GiB suffix. Must be called before parsing.
+func (e *ExtractParams) WithMemoryLimit(limit string) *ExtractParams {
+	e._memoryLimit = limit
+	return e
+}
+
+// WithStrict sets whether a malformed row of an input file is an error, instead of being skipped.
+func (e *ExtractParams) WithStrict(strict bool) *ExtractParams {
+	e.strict = strict
+	return e
+}
+
+// WithDedupe sets how duplicate rows are removed: exact duplicates are always removed, and of the rows
+// with the same primary key the first or the last is kept, or they are an error. DedupeOff keeps duplicates.
+func (e *ExtractParams) WithDedupe(policy DedupePolicy) *ExtractParams {
+	e.dedupe = policy
+	return e
+}
+
+// WithSort sets the fields the rows of output files are sorted by, in the format filename,field1,field2,...
+// If canonical, files without sort fields of their own are sorted in the usual order, like stop_times.txt
+// by trip_id and stop_sequence. Must be called before parsing.
+func (e *ExtractParams) WithSort(sorts []string, canonical bool) *ExtractParams {
+	e._sorts = sorts
+	e.canonicalSorts = canonical
+	return e
+}
+
+// WithColumnOrder sets the order of the columns of the output files: input, canonical or custom,
+// with custom lists in the format filename,field1,field2,... Must be called before parsing.
+func (e *ExtractParams) WithColumnOrder(mode string, custom []string) *ExtractParams {
+	e._columnOrder = mode
+	e._customColumnOrder = custom
+	return e
+}
+
+// WithMinimal sets whether only the files and fields required by the GTFS reference are extracted, together
+// with the included files and fields. Must be called before parsing.
+func (e *ExtractParams) WithMinimal(minimal bool) *ExtractParams {
+	e.minimal = minimal
+	return e
+}
+
+// WithAllowInvalid sets whether removing files or fields required by the GTFS reference is allowed,
+// with only a warning, instead of being an error. Must be called before parsing.
+func (e *ExtractParams) WithAllowInvalid(allowInvalid bool) *ExtractParams {
+	e.allowInvalid = allowInvalid
+	return e
+}
+
 func (e *ExtractParams) ExcludedFiles() []string {
 	return e.excludedFiles
 }
@@ -121,6 +408,26 @@ func (e *ExtractParams) IncludedFields() map[string][]string {
 	return e.includedFields
 }
 
+// IncludedFieldsOf returns the field patterns included in the given file, from all file patterns matching it.
+func (e *ExtractParams) IncludedFieldsOf(fileName string) []string {
+	return fieldsOf(e.includedFields, fileName)
+}
+
+// ExcludedFieldsOf returns the field patterns excluded from the given file, from all file patterns matching it.
+func (e *ExtractParams) ExcludedFieldsOf(fileName string) []string {
+	return fieldsOf(e.excludedFields, fileName)
+}
+
+func fieldsOf(fields map[string][]string, fileName string) []string {
+	var result []string
+	for filePattern, fieldPatterns := range fields {
+		if pattern.Match(filePattern, fileName) {
+			result = append(result, fieldPatterns...)
+		}
+	}
+	return result
+}
+
 func (e *ExtractParams) ExcludeEmptyFiles() bool {
 	return e.excludeEmptyFiles
 }
@@ -133,6 +440,129 @@ func (e *ExtractParams) ExcludeShapes() bool {
 	return e.excludeShapes
 }
 
+// RenamedFields returns the renames of fields of the given file, from old to new name.
+func (e *ExtractParams) RenamedFields(fileName string) map[string]string {
+	return e.renamedFields[fileName]
+}
+
+// Where returns the parsed row filters of the given file. Multiple filters must all match for a row to be kept.
+func (e *ExtractParams) Where(fileName string) []*predicate.Predicate {
+	return e.where[fileName]
+}
+
+// Transforms returns the parsed value transformations of the given file, in the order they are applied.
+func (e *ExtractParams) Transforms(fileName string) []*transform.Transform {
+	return e.transforms[fileName]
+}
+
+// RowProcessors returns the row processors given by the parameters for the file, in the order they are applied.
+// Transformations come first, so row filters see the transformed values.
+func (e *ExtractParams) RowProcessors(fileName string) []rows.Processor {
+	var processors []rows.Processor
+	for _, t := range e.transforms[fileName] {
+		processors = append(processors, t)
+	}
+	for _, p := range e.where[fileName] {
+		processors = append(processors, p)
+	}
+	return processors
+}
+
+func (e *ExtractParams) KeepAgencies() []string {
+	return e.keepAgencies
+}
+
+func (e *ExtractParams) KeepRoutes() []string {
+	return e.keepRoutes
+}
+
+func (e *ExtractParams) KeepTrips() []string {
+	return e.keepTrips
+}
+
+// DateWindow returns the service date window. Zero values mean that side of the window is open.
+func (e *ExtractParams) DateWindow() (from time.Time, to time.Time) {
+	return e.fromDate, e.toDate
+}
+
+// Area returns the geographic area to extract, or nil if the extraction isn't geographic.
+func (e *ExtractParams) Area() geometry.Area {
+	return e.area
+}
+
+func (e *ExtractParams) ClipTrips() bool {
+	return e.clipTrips
+}
+
+// SampleTrips returns the number of trips sampled, or of trips of every route if SamplePerRoute,
+// zero if the feed isn't sampled.
+func (e *ExtractParams) SampleTrips() int {
+	return e.sampleTrips
+}
+
+// SampleSeed returns the seed of the random choice of sampled trips.
+func (e *ExtractParams) SampleSeed() int64 {
+	return e.sampleSeed
+}
+
+// SamplePerRoute reports whether the first trips of every route are sampled, instead of random trips.
+func (e *ExtractParams) SamplePerRoute() bool {
+	return e.samplePerRoute
+}
+
+func (e *ExtractParams) PruneOrphans() bool {
+	return e.pruneOrphans
+}
+
+// SimplifyShapes returns the tolerance of shape simplification in meters, zero if shapes aren't simplified.
+func (e *ExtractParams) SimplifyShapes() float64 {
+	return e.simplifyShapes
+}
+
+// ShapePrecision returns the number of decimal places shape coordinates are rounded to, zero if they aren't rounded.
+func (e *ExtractParams) ShapePrecision() int {
+	return e.shapePrecision
+}
+
+// Jobs returns the number of files extracted concurrently. It is at least 1 after parsing.
+func (e *ExtractParams) Jobs() int {
+	return e.jobs
+}
+
+// MemoryLimit returns how many bytes of rows of a file are buffered in memory while excluding empty fields,
+// before they are spilled to disk.
+func (e *ExtractParams) MemoryLimit() int64 {
+	return e.memoryLimit
+}
+
+// Strict reports whether a malformed row of an input file is an error, instead of being skipped.
+func (e *ExtractParams) Strict() bool {
+	return e.strict
+}
+
+// Dedupe returns how duplicate rows are removed, DedupeOff if they are kept.
+func (e *ExtractParams) Dedupe() DedupePolicy {
+	return e.dedupe
+}
+
+// SortKeys returns the fields the rows of the output file are sorted by, nil if they keep the input order.
+func (e *ExtractParams) SortKeys(fileName string) []SortKey {
+	return e.sorts[fileName]
+}
+
+// ColumnOrder returns the order of the columns of the output files, nil before parsing.
+func (e *ExtractParams) ColumnOrder() *columnorder.Order {
+	return e.columnOrder
+}
+
+// IsFileExtracted reports whether the file is written to the output, according to the file inclusion and exclusion lists.
+func (e *ExtractParams) IsFileExtracted(fileName string) bool {
+	if len(e.includedFiles) > 0 {
+		return pattern.MatchAny(e.includedFiles, fileName)
+	}
+	return !pattern.MatchAny(e.excludedFiles, fileName)
+}
+
 func (e *ExtractParams) ParseAndValidate() error {
 	if e.parsed {
 		return nil
@@ -153,6 +583,130 @@ func (e *ExtractParams) ParseAndValidate() error {
 		}
 	}
 
+	if e.excludedFields == nil {
+		e.excludedFields = make(map[string][]string)
+	}
+	if e.includedFields == nil {
+		e.includedFields = make(map[string][]string)
+	}
+
+	if e.minimal {
+		if len(e.excludedFiles) > 0 {
+			return errors.Join(ErrParsingFailed, ErrMinimalExcludedFiles)
+		}
+		e.addMinimal()
+	}
+
+	if e.renamedFields == nil && len(e._renamedFields) > 0 {
+		e.renamedFields, err = parseRenameList(e._renamedFields)
+		if err != nil {
+			return errors.Join(ErrParsingFailed, fmt.Errorf("error parsing renamed fields: %w", err))
+		}
+	}
+
+	if e.transforms == nil && len(e._transforms) > 0 {
+		e.transforms, err = parseTransformList(e._transforms)
+		if err != nil {
+			return errors.Join(ErrParsingFailed, fmt.Errorf("error parsing transforms: %w", err))
+		}
+	}
+
+	if e.where == nil && len(e._where) > 0 {
+		e.where, err = parseWhereList(e._where)
+		if err != nil {
+			return errors.Join(ErrParsingFailed, fmt.Errorf("error parsing where: %w", err))
+		}
+	}
+
+	if e.fromDate, err = parseOptionalDate(e._fromDate); err != nil {
+		return errors.Join(ErrParsingFailed, fmt.Errorf("error parsing from-date: %w", err))
+	}
+	if e.toDate, err = parseOptionalDate(e._toDate); err != nil {
+		return errors.Join(ErrParsingFailed, fmt.Errorf("error parsing to-date: %w", err))
+	}
+	if !e.fromDate.IsZero() && !e.toDate.IsZero() && e.fromDate.After(e.toDate) {
+		return errors.Join(ErrParsingFailed, ErrInvalidDateWindow)
+	}
+
+	if e._bbox != "" && e._polygon != "" {
+		return errors.Join(ErrParsingFailed, ErrMutuallyExclusiveArea)
+	}
+	if e._bbox != "" {
+		bbox, err := geometry.ParseBBox(e._bbox)
+		if err != nil {
+			return errors.Join(ErrParsingFailed, fmt.Errorf("error parsing bbox: %w", err))
+		}
+		e.area = bbox
+	}
+	if e._polygon != "" {
+		polygons, err := geometry.LoadGeoJSON(e._polygon)
+		if err != nil {
+			return errors.Join(ErrParsingFailed, fmt.Errorf("error loading polygon %s: %w", e._polygon, err))
+		}
+		e.area = polygons
+	}
+	if e.clipTrips && e.area == nil {
+		return errors.Join(ErrParsingFailed, ErrClipWithoutArea)
+	}
+
+	if e.sampleTrips < 0 {
+		return errors.Join(ErrParsingFailed, ErrInvalidSample)
+	}
+	if e.samplePerRoute && e.sampleTrips == 0 {
+		return errors.Join(ErrParsingFailed, ErrPerRouteWithoutSample)
+	}
+
+	if e.simplifyShapes < 0 {
+		return errors.Join(ErrParsingFailed, ErrInvalidTolerance)
+	}
+	if e.jobs < 0 {
+		return errors.Join(ErrParsingFailed, ErrInvalidJobs)
+	}
+	if e.jobs == 0 {
+		e.jobs = runtime.NumCPU()
+	}
+
+	e.memoryLimit = DefaultMemoryLimit
+	if e._memoryLimit != "" {
+		limit, err := parseSize(e._memoryLimit)
+		if err != nil {
+			return errors.Join(ErrParsingFailed, fmt.Errorf("error parsing memory-limit: %w", err))
+		}
+		e.memoryLimit = limit
+	}
+
+	switch e.dedupe {
+	case DedupeOff, DedupeKeepFirst, DedupeKeepLast, DedupeFail:
+	default:
+		return errors.Join(ErrParsingFailed, fmt.Errorf("%w: %s", ErrInvalidDedupe, e.dedupe))
+	}
+
+	if e.sorts, err = parseSortList(e._sorts); err != nil {
+		return errors.Join(ErrParsingFailed, err)
+	}
+	if e.canonicalSorts {
+		canonical, _ := parseSortList(canonicalSorts)
+		for fileName, keys := range canonical {
+			// Explicit sorts of a file replace the canonical ones
+			if _, ok := e.sorts[fileName]; !ok {
+				e.sorts[fileName] = keys
+			}
+		}
+	}
+
+	order, err := columnorder.Parse(e._columnOrder, e._customColumnOrder)
+	if err != nil {
+		return errors.Join(ErrParsingFailed, err)
+	}
+	e.columnOrder = order
+
+	if e.shapePrecision < 0 {
+		return errors.Join(ErrParsingFailed, ErrInvalidPrecision)
+	}
+	if (e.simplifyShapes > 0 || e.shapePrecision > 0) && e.ExcludeShapes() {
+		return errors.Join(ErrParsingFailed, ErrSimplifyExcludedShapes)
+	}
+
 	included := e.includedFields
 	excluded := e.excludedFields
 
@@ -169,8 +723,23 @@ func (e *ExtractParams) ParseAndValidate() error {
 		return errors.Join(ErrParsingFailed, ErrMutuallyExclusiveFiles)
 	}
 
+	for _, p := range slices.Concat(e.includedFiles, e.excludedFiles) {
+		if err := pattern.Validate(p); err != nil {
+			return errors.Join(ErrParsingFailed, err)
+		}
+	}
+	for _, fields := range []map[string][]string{included, excluded} {
+		for fileName, fieldNames := range fields {
+			for _, p := range append([]string{fileName}, fieldNames...) {
+				if err := pattern.Validate(p); err != nil {
+					return errors.Join(ErrParsingFailed, err)
+				}
+			}
+		}
+	}
+
 	// check if exclusion of shapes.txt is done with file exclusion
-	if slices.Contains(e.ExcludedFiles(), "shapes.txt") {
+	if pattern.MatchAny(e.ExcludedFiles(), "shapes.txt") {
 		if e.ExcludeShapes() {
 			return errors.Join(ErrParsingFailed, ErrMutuallyExclusiveShapes)
 		}
@@ -189,10 +758,76 @@ func (e *ExtractParams) ParseAndValidate() error {
 	return nil
 }
 
+// AllowInvalid returns whether removing files or fields required by the GTFS reference is allowed.
+func (e *ExtractParams) AllowInvalid() bool {
+	return e.allowInvalid
+}
+
 func (e *ExtractParams) IsParsedAndValid() bool {
 	return e.parsed
 }
 
+// addMinimal includes the files of --minimal, their required and conditionally required fields and the optional
+// fields those depend on, in addition to the included files and fields.
+func (e *ExtractParams) addMinimal() {
+	e.includedFiles = append(slices.Clone(e.includedFiles), minimalFiles...)
+	for _, fileName := range minimalFiles {
+		file, _ := schema.Lookup(fileName)
+		for _, field := range file.Fields {
+			if field.Presence != schema.Optional && !slices.Contains(minimalDroppedFields[fileName], field.Name) {
+				e.includedFields[fileName] = append(e.includedFields[fileName], field.Name)
+			}
+		}
+		e.includedFields[fileName] = append(e.includedFields[fileName], minimalAddedFields[fileName]...)
+	}
+}
+
+// RemovedRequired returns the files and fields of the GTFS reference that are required or conditionally
+// required, and that the file and field selection or renames remove from a file of the input feed with the
+// given header, after renames. Fields not in the header are not removed, so they are not returned. With
+// --minimal, conditionally required ones are removed on purpose and not returned either.
+func (e *ExtractParams) RemovedRequired(fileName string, header []string) (required, conditional []string) {
+	file, ok := schema.Lookup(fileName)
+	// Removing shapes as a whole is fine
+	if !ok || (e.ExcludeShapes() && fileName == "shapes.txt") {
+		return nil, nil
+	}
+	add := func(presence schema.Presence, what string) {
+		switch {
+		case presence == schema.Required:
+			required = append(required, what)
+		case presence == schema.ConditionallyRequired && !e.minimal:
+			conditional = append(conditional, what)
+		}
+	}
+	if !e.IsFileExtracted(fileName) {
+		add(file.Presence, fmt.Sprintf("%s file %s", file.Presence, fileName))
+		return required, conditional
+	}
+	included := e.IncludedFieldsOf(fileName)
+	excluded := e.ExcludedFieldsOf(fileName)
+	for _, field := range file.Fields {
+		if e.ExcludeShapes() && fileName == "trips.txt" && field.Name == "shape_id" {
+			continue
+		}
+		if newName, ok := e.renamedFields[fileName][field.Name]; ok && slices.Contains(header, newName) {
+			add(field.Presence, fmt.Sprintf("%s field %s of %s, renamed to %s", field.Presence, field.Name, fileName, newName))
+			continue
+		}
+		if !slices.Contains(header, field.Name) {
+			continue
+		}
+		// Inclusion takes precedence over exclusion, like when extracting
+		if pattern.MatchAny(included, field.Name) {
+			continue
+		}
+		if pattern.MatchAny(excluded, field.Name) || len(included) > 0 {
+			add(field.Presence, fmt.Sprintf("%s field %s of %s", field.Presence, field.Name, fileName))
+		}
+	}
+	return required, conditional
+}
+
 func parseFieldsFieldList(fieldList []string) (map[string][]string, error) {
 	result := make(map[string][]string)
 	for _, ef := range fieldList {
@@ -204,7 +839,10 @@ func parseFieldsFieldList(fieldList []string) (map[string][]string, error) {
 			return nil, ErrInvalidExclude
 		}
 
-		result[filename] = []string{}
+		// The same file can be given more than once
+		if _, ok := result[filename]; !ok {
+			result[filename] = []string{}
+		}
 		i := 0
 		for ; ; i++ {
 			fieldname, ok := next()
@@ -220,3 +858,129 @@ func parseFieldsFieldList(fieldList []string) (map[string][]string, error) {
 	}
 	return result, nil
 }
+
+func parseRenameList(renameList []string) (map[string]map[string]string, error) {
+	result := make(map[string]map[string]string)
+	for _, r := range renameList {
+		parts := strings.Split(r, ",")
+		filename := parts[0]
+		if filename == "" || len(parts) < 2 {
+			return nil, ErrInvalidRename
+		}
+		if result[filename] == nil {
+			result[filename] = make(map[string]string)
+		}
+		renames := result[filename]
+		for _, rename := range parts[1:] {
+			oldName, newName, ok := strings.Cut(rename, "=")
+			oldName, newName = strings.TrimSpace(oldName), strings.TrimSpace(newName)
+			if !ok || oldName == "" || newName == "" {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidRename, rename)
+			}
+			if _, ok := renames[oldName]; ok {
+				return nil, fmt.Errorf("%w: %s renamed more than once in %s", ErrInvalidRename, oldName, filename)
+			}
+			for other, target := range renames {
+				if target == newName {
+					return nil, fmt.Errorf("%w: both %s and %s renamed to %s in %s", ErrRenameCollision, other, oldName, newName, filename)
+				}
+			}
+			renames[oldName] = newName
+		}
+	}
+	return result, nil
+}
+
+// parseSortList parses sorts in the format filename,field1,field2,... The fields of a later sort of a file
+// follow the fields of an earlier one, since the config gives a list of fields as one sort per field.
+// Fields of the GTFS reference that are numbers are compared as numbers.
+func parseSortList(sortList []string) (map[string][]SortKey, error) {
+	result := make(map[string][]SortKey)
+	for _, s := range sortList {
+		parts := strings.Split(s, ",")
+		if len(parts) < 2 || slices.Contains(parts, "") {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSort, s)
+		}
+		file, _ := schema.Lookup(parts[0])
+		keys := make([]SortKey, 0, len(parts)-1)
+		for _, fieldName := range parts[1:] {
+			key := SortKey{Field: fieldName}
+			if file != nil {
+				field, _ := file.Field(fieldName)
+				key.Numeric = field.Type == schema.Integer || field.Type == schema.Float
+			}
+			keys = append(keys, key)
+		}
+		result[parts[0]] = append(result[parts[0]], keys...)
+	}
+	return result, nil
+}
+
+func parseTransformList(transformList []string) (map[string][]*transform.Transform, error) {
+	result := make(map[string][]*transform.Transform)
+	for _, t := range transformList {
+		// The argument may contain commas itself, so only split off the file and field names
+		parts := strings.SplitN(t, ",", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, ErrInvalidTransformFormat
+		}
+		parsed, err := transform.Parse(parts[1], parts[2])
+		if err != nil {
+			return nil, err
+		}
+		result[parts[0]] = append(result[parts[0]], parsed)
+	}
+	return result, nil
+}
+
+func parseWhereList(whereList []string) (map[string][]*predicate.Predicate, error) {
+	result := make(map[string][]*predicate.Predicate)
+	for _, w := range whereList {
+		// The expression may contain commas itself, so only split on the first one
+		filename, expression, ok := strings.Cut(w, ",")
+		if !ok || filename == "" || strings.TrimSpace(expression) == "" {
+			return nil, ErrInvalidWhere
+		}
+		p, err := predicate.Parse(expression)
+		if err != nil {
+			return nil, err
+		}
+		result[filename] = append(result[filename], p)
+	}
+	return result, nil
+}
+
+func parseOptionalDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("20060102", date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidDate, date)
+	}
+	return t, nil
+}
+
+// parseSize parses a number of bytes with an optional binary suffix, like 512MiB. The B and i of the suffix are optional.
+func parseSize(s string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(s))
+	number = strings.TrimSuffix(number, "B")
+	number = strings.TrimSuffix(number, "I")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(number, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidMemoryLimit, s)
+	}
+	return n * multiplier, nil
+}
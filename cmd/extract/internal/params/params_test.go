@@ -1,6 +1,8 @@
 package params
 
 import (
+	"errors"
+	"maps"
 	"slices"
 	"testing"
 )
@@ -48,6 +50,189 @@ func Test_parseFieldsFieldList(t *testing.T) {
 	}
 }
 
+func Test_parseWhereList(t *testing.T) {
+	tests := []struct {
+		name      string
+		whereList []string
+		wantFiles map[string]int
+		wantErr   bool
+	}{
+		{
+			name:      "expressions for multiple files",
+			whereList: []string{"routes.txt,route_type=3", "stops.txt,wheelchair_boarding!=2", "routes.txt,agency_id=1"},
+			wantFiles: map[string]int{"routes.txt": 2, "stops.txt": 1},
+			wantErr:   false,
+		},
+		{
+			name:      "expression containing commas",
+			whereList: []string{"stops.txt,stop_name~'^(a,b)'"},
+			wantFiles: map[string]int{"stops.txt": 1},
+			wantErr:   false,
+		},
+		{
+			name:      "missing expression",
+			whereList: []string{"routes.txt"},
+			wantErr:   true,
+		},
+		{
+			name:      "missing file name",
+			whereList: []string{",route_type=3"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid expression",
+			whereList: []string{"routes.txt,route_type"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotErr := parseWhereList(tt.whereList)
+			if (gotErr != nil) != tt.wantErr {
+				t.Fatalf("parseWhereList() error = %v, wantErr %v", gotErr, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.wantFiles) {
+				t.Errorf("parseWhereList() = %v, want files %v", got, tt.wantFiles)
+			}
+			for file, n := range tt.wantFiles {
+				if len(got[file]) != n {
+					t.Errorf("parseWhereList() has %d expressions for %s, want %d", len(got[file]), file, n)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseRenameList(t *testing.T) {
+	tests := []struct {
+		name       string
+		renameList []string
+		want       map[string]map[string]string
+		wantErr    error
+	}{
+		{
+			name:       "renames in multiple files",
+			renameList: []string{"stops.txt,stop_lat_wgs=stop_lat,stop_lon_wgs=stop_lon", "routes.txt,line=route_short_name"},
+			want: map[string]map[string]string{
+				"stops.txt":  {"stop_lat_wgs": "stop_lat", "stop_lon_wgs": "stop_lon"},
+				"routes.txt": {"line": "route_short_name"},
+			},
+		},
+		{name: "missing renames", renameList: []string{"stops.txt"}, wantErr: ErrInvalidRename},
+		{name: "missing new name", renameList: []string{"stops.txt,stop_lat_wgs="}, wantErr: ErrInvalidRename},
+		{name: "renamed twice", renameList: []string{"stops.txt,a=b", "stops.txt,a=c"}, wantErr: ErrInvalidRename},
+		{name: "two fields with the same new name", renameList: []string{"stops.txt,a=c,b=c"}, wantErr: ErrRenameCollision},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRenameList(tt.renameList)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseRenameList() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("parseRenameList() = %v, want %v", got, tt.want)
+			}
+			for file, renames := range tt.want {
+				if !maps.Equal(got[file], renames) {
+					t.Errorf("parseRenameList() renames of %s = %v, want %v", file, got[file], renames)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseSortList(t *testing.T) {
+	tests := []struct {
+		name     string
+		sortList []string
+		want     map[string][]SortKey
+		wantErr  error
+	}{
+		{
+			name:     "numeric fields of the reference",
+			sortList: []string{"stop_times.txt,trip_id,stop_sequence", "lpp_extra.txt,seq"},
+			want: map[string][]SortKey{
+				"stop_times.txt": {{Field: "trip_id"}, {Field: "stop_sequence", Numeric: true}},
+				"lpp_extra.txt":  {{Field: "seq"}},
+			},
+		},
+		{
+			name:     "later sort adds fields",
+			sortList: []string{"stop_times.txt,trip_id", "stop_times.txt,stop_sequence"},
+			want:     map[string][]SortKey{"stop_times.txt": {{Field: "trip_id"}, {Field: "stop_sequence", Numeric: true}}},
+		},
+		{name: "missing fields", sortList: []string{"stops.txt"}, wantErr: ErrInvalidSort},
+		{name: "empty field", sortList: []string{"stops.txt,,stop_id"}, wantErr: ErrInvalidSort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSortList(tt.sortList)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseSortList() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("parseSortList() = %v, want %v", got, tt.want)
+			}
+			for file, keys := range tt.want {
+				if !slices.Equal(got[file], keys) {
+					t.Errorf("parseSortList() keys of %s = %v, want %v", file, got[file], keys)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractParams_SortCanonical(t *testing.T) {
+	p := NewExtractParamsParsed(nil, nil, false, false, false, nil, nil).
+		WithSort([]string{"shapes.txt,shape_id"}, true)
+	if err := p.ParseAndValidate(); err != nil {
+		t.Fatalf("ParseAndValidate() failed: %v", err)
+	}
+	if got, want := p.SortKeys("stop_times.txt"), []SortKey{{"trip_id", false}, {"stop_sequence", true}}; !slices.Equal(got, want) {
+		t.Errorf("SortKeys(stop_times.txt) = %v, want %v", got, want)
+	}
+	if got, want := p.SortKeys("shapes.txt"), []SortKey{{"shape_id", false}}; !slices.Equal(got, want) {
+		t.Errorf("SortKeys(shapes.txt) = %v, want %v", got, want)
+	}
+	if got := p.SortKeys("stops.txt"); got != nil {
+		t.Errorf("SortKeys(stops.txt) = %v, want nil", got)
+	}
+}
+
+func Test_parseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "1024", want: 1024},
+		{input: "512B", want: 512},
+		{input: "64KiB", want: 64 << 10},
+		{input: "256MiB", want: 256 << 20},
+		{input: "256m", want: 256 << 20},
+		{input: "2 GB", want: 2 << 30},
+		{input: "0", want: 0},
+		{input: "-1", wantErr: true},
+		{input: "lots", wantErr: true},
+		{input: "1TiB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_ParseAndValidate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -86,6 +271,96 @@ func Test_ParseAndValidate(t *testing.T) {
 
 			wantErr: true,
 		},
+		{
+			name:    "from-date after to-date",
+			params:  &ExtractParams{_fromDate: "20250201", _toDate: "20250101"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid date format",
+			params:  &ExtractParams{_fromDate: "2025-01-01"},
+			wantErr: true,
+		},
+		{
+			name:    "open ended date window",
+			params:  &ExtractParams{_toDate: "20250101"},
+			wantErr: false,
+		},
+		{
+			name:    "negative simplification tolerance",
+			params:  &ExtractParams{simplifyShapes: -1},
+			wantErr: true,
+		},
+		{
+			name:    "shape simplification with excluded shapes",
+			params:  &ExtractParams{simplifyShapes: 5, excludeShapes: true},
+			wantErr: true,
+		},
+		{
+			name:    "shape rounding with excluded shapes",
+			params:  &ExtractParams{shapePrecision: 5, excludeShapes: true},
+			wantErr: true,
+		},
+		{
+			name:    "file pattern matching shapes.txt",
+			params:  &ExtractParams{excludedFiles: []string{"s*.txt"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid file pattern",
+			params:  &ExtractParams{includedFiles: []string{"fare_[.txt"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid field pattern",
+			params:  &ExtractParams{_excludedFields: []string{"*,/(/"}},
+			wantErr: true,
+		},
+		{
+			name:    "file and field patterns",
+			params:  &ExtractParams{_excludedFields: []string{"*,*_url", "/^fare_/,/_name$/"}, excludedFiles: []string{"fare_*.txt"}},
+			wantErr: false,
+		},
+		{
+			name:    "minimal with excluded files",
+			params:  &ExtractParams{excludedFiles: []string{"shapes.txt"}, minimal: true},
+			wantErr: true,
+		},
+		{
+			name:    "invalid dedupe policy",
+			params:  &ExtractParams{dedupe: "keep-middle"},
+			wantErr: true,
+		},
+		{
+			name:    "dedupe policy",
+			params:  &ExtractParams{dedupe: DedupeKeepLast},
+			wantErr: false,
+		},
+		{
+			name:    "negative sample trips",
+			params:  &ExtractParams{sampleTrips: -1},
+			wantErr: true,
+		},
+		{
+			name:    "sample per route without trips",
+			params:  &ExtractParams{samplePerRoute: true},
+			wantErr: true,
+		},
+		{
+			name:    "sample per route",
+			params:  &ExtractParams{sampleTrips: 2, samplePerRoute: true},
+			wantErr: false,
+		},
+		{
+			name:    "invalid column order",
+			params:  &ExtractParams{_columnOrder: "alphabetical"},
+			wantErr: true,
+		},
+		{
+			name:    "custom column order",
+			params:  &ExtractParams{_columnOrder: "custom", _customColumnOrder: []string{"stops.txt,stop_name,stop_id"}},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -98,6 +373,36 @@ func Test_ParseAndValidate(t *testing.T) {
 	}
 }
 
+func TestExtractParams_FieldsOf(t *testing.T) {
+	p := NewExtractParams(nil, nil, false, false, false,
+		[]string{"*,*_url", "stops.txt,stop_desc", "fare_*.txt,fare_media_name"},
+		[]string{"routes.txt,route_*"})
+	if err := p.ParseAndValidate(); err != nil {
+		t.Fatalf("ParseAndValidate() failed: %v", err)
+	}
+	tests := []struct {
+		fileName     string
+		wantExcluded []string
+		wantIncluded []string
+	}{
+		{"stops.txt", []string{"*_url", "stop_desc"}, nil},
+		{"fare_media.txt", []string{"*_url", "fare_media_name"}, nil},
+		{"routes.txt", []string{"*_url"}, []string{"route_*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			excluded := p.ExcludedFieldsOf(tt.fileName)
+			slices.Sort(excluded)
+			if !slices.Equal(excluded, tt.wantExcluded) {
+				t.Errorf("ExcludedFieldsOf(%s) = %v, want %v", tt.fileName, excluded, tt.wantExcluded)
+			}
+			if included := p.IncludedFieldsOf(tt.fileName); !slices.Equal(included, tt.wantIncluded) {
+				t.Errorf("IncludedFieldsOf(%s) = %v, want %v", tt.fileName, included, tt.wantIncluded)
+			}
+		})
+	}
+}
+
 func mapsEqual(a, b map[string][]string) bool {
 	if len(a) != len(b) {
 		return false
@@ -110,3 +415,107 @@ func mapsEqual(a, b map[string][]string) bool {
 	}
 	return true
 }
+
+func TestExtractParams_RemovedRequired(t *testing.T) {
+	p := NewExtractParams([]string{"calendar_dates.txt"}, nil, false, false, true,
+		[]string{"*,*_url", "stops.txt,parent_station"}, []string{"stop_times.txt,trip_id,stop_id"}).
+		WithRenamedFields([]string{"routes.txt,route_type=lpp_route_type"})
+	if err := p.ParseAndValidate(); err != nil {
+		t.Fatalf("ParseAndValidate() failed: %v", err)
+	}
+	tests := []struct {
+		fileName        string
+		header          []string
+		wantRequired    []string
+		wantConditional []string
+	}{
+		{
+			fileName:     "agency.txt",
+			header:       []string{"agency_id", "agency_name", "agency_url", "agency_timezone"},
+			wantRequired: []string{"required field agency_url of agency.txt"},
+		},
+		{
+			// Not in the header, so not removed
+			fileName: "feed_info.txt",
+			header:   []string{"feed_publisher_name", "feed_lang"},
+		},
+		{
+			fileName: "stops.txt",
+			header:   []string{"stop_id", "stop_name"},
+		},
+		{
+			fileName:        "stops.txt",
+			header:          []string{"stop_id", "stop_name", "parent_station"},
+			wantConditional: []string{"conditionally required field parent_station of stops.txt"},
+		},
+		{
+			fileName:        "stop_times.txt",
+			header:          []string{"trip_id", "arrival_time", "stop_id", "stop_sequence"},
+			wantRequired:    []string{"required field stop_sequence of stop_times.txt"},
+			wantConditional: []string{"conditionally required field arrival_time of stop_times.txt"},
+		},
+		{
+			fileName:     "routes.txt",
+			header:       []string{"route_id", "lpp_route_type"},
+			wantRequired: []string{"required field route_type of routes.txt, renamed to lpp_route_type"},
+		},
+		{
+			fileName:        "calendar_dates.txt",
+			header:          []string{"service_id", "date", "exception_type"},
+			wantConditional: []string{"conditionally required file calendar_dates.txt"},
+		},
+		{
+			// Removing shapes as a whole is fine
+			fileName: "shapes.txt",
+			header:   []string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence"},
+		},
+		{
+			fileName: "lpp_extra.txt",
+			header:   []string{"extra_url"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			required, conditional := p.RemovedRequired(tt.fileName, tt.header)
+			if !slices.Equal(required, tt.wantRequired) {
+				t.Errorf("RemovedRequired(%s) required = %v, want %v", tt.fileName, required, tt.wantRequired)
+			}
+			if !slices.Equal(conditional, tt.wantConditional) {
+				t.Errorf("RemovedRequired(%s) conditional = %v, want %v", tt.fileName, conditional, tt.wantConditional)
+			}
+		})
+	}
+}
+
+func TestExtractParams_Minimal(t *testing.T) {
+	p := NewExtractParams(nil, []string{"shapes.txt"}, false, false, false,
+		nil, []string{"stops.txt,wheelchair_boarding"}).
+		WithMinimal(true)
+	if err := p.ParseAndValidate(); err != nil {
+		t.Fatalf("ParseAndValidate() failed: %v", err)
+	}
+	for fileName, want := range map[string]bool{
+		"stop_times.txt": true,
+		"feed_info.txt":  true,
+		"shapes.txt":     true,
+		"pathways.txt":   false,
+		"lpp_extra.txt":  false,
+	} {
+		if got := p.IsFileExtracted(fileName); got != want {
+			t.Errorf("IsFileExtracted(%s) = %v, want %v", fileName, got, want)
+		}
+	}
+
+	stopFields := p.IncludedFieldsOf("stops.txt")
+	slices.Sort(stopFields)
+	if want := []string{"location_type", "parent_station", "stop_id", "stop_lat", "stop_lon", "stop_name", "wheelchair_boarding"}; !slices.Equal(stopFields, want) {
+		t.Errorf("IncludedFieldsOf(stops.txt) = %v, want %v", stopFields, want)
+	}
+	if want := []string{"route_id", "service_id", "trip_id"}; !slices.Equal(p.IncludedFieldsOf("trips.txt"), want) {
+		t.Errorf("IncludedFieldsOf(trips.txt) = %v, want %v", p.IncludedFieldsOf("trips.txt"), want)
+	}
+	// Conditionally required fields are removed on purpose
+	if required, conditional := p.RemovedRequired("trips.txt", []string{"route_id", "service_id", "trip_id", "shape_id"}); len(required) > 0 || len(conditional) > 0 {
+		t.Errorf("RemovedRequired(trips.txt) = %v, %v, want none", required, conditional)
+	}
+}
@@ -0,0 +1,9 @@
+// Package pattern matches file and field names against the selectors of the file and field options
+// of the extract command. A selector is one of:
+//
+//	stops.txt        an exact name
+//	fare_*.txt       a glob, as in path.Match: * matches any run of characters, ? a single one,
+//	                 and [a-z] a character class
+//	/^stop_(lat|lon)$/  a regular expression between slashes; it matches anywhere in the name
+//	                 unless anchored, and can't contain commas, which separate the values of the options
+package pattern
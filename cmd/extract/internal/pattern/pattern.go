@@ -0,0 +1,65 @@
+package pattern
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidPattern = errors.New("invalid pattern")
+
+// compiled caches the regular expressions of patterns, since the same few patterns are matched against
+// every file and field name
+var compiled sync.Map // string -> *regexp.Regexp
+
+// Validate checks that the pattern is a valid glob or regular expression.
+func Validate(pattern string) error {
+	if isRegexp(pattern) {
+		_, err := compile(pattern)
+		return err
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%w %q: %w", ErrInvalidPattern, pattern, err)
+	}
+	return nil
+}
+
+// Match reports whether name matches the pattern. Invalid patterns match nothing, they should be
+// rejected with Validate beforehand.
+func Match(pattern, name string) bool {
+	if isRegexp(pattern) {
+		re, err := compile(pattern)
+		return err == nil && re.MatchString(name)
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// MatchAny reports whether name matches any of the patterns.
+func MatchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if Match(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRegexp(pattern string) bool {
+	return len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := compiled.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrInvalidPattern, pattern, err)
+	}
+	compiled.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,62 @@
+package pattern_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/pattern"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"stops.txt", "stops.txt", true},
+		{"stops.txt", "stop_times.txt", false},
+		{"fare_*.txt", "fare_leg_rules.txt", true},
+		{"fare_*.txt", "fare_attributes.txt", true},
+		{"fare_*.txt", "feed_info.txt", false},
+		{"*", "agency.txt", true},
+		{"*_url", "agency_fare_url", true},
+		{"*_url", "agency_url_text", false},
+		{"stop_?at", "stop_lat", true},
+		{"stop_[lx]on", "stop_lon", true},
+		{"/^stop_(lat|lon)$/", "stop_lat", true},
+		{"/^stop_(lat|lon)$/", "parent_stop_lat", false},
+		{"/url/", "agency_url_text", true},
+		{"/", "/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			if got := pattern.Match(tt.pattern, tt.name); got != tt.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{"stops.txt", false},
+		{"fare_*.txt", false},
+		{"/^stop_(lat|lon)$/", false},
+		{"stop_[lat", true},
+		{"/(/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			err := pattern.Validate(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, pattern.ErrInvalidPattern) {
+				t.Errorf("Validate(%q) error = %v, want %v", tt.pattern, err, pattern.ErrInvalidPattern)
+			}
+		})
+	}
+}
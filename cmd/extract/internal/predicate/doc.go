@@ -0,0 +1,15 @@
+// Package predicate implements the row filter expressions used by the --where option of the extract command.
+//
+// An expression compares fields of a row with constant values and combines the comparisons with
+// boolean operators, for example:
+//
+//	route_type=3 || (route_type>=700 && route_type<800)
+//	wheelchair_boarding!=2 && stop_name~"^Ljubljana"
+//
+// Supported comparison operators are = (or ==), !=, <, <=, >, >=, ~ (regex match) and !~ (regex non-match).
+// Ordering operators compare numerically when both sides are numbers and lexicographically otherwise,
+// which also orders GTFS dates (YYYYMMDD) correctly. Empty values never match them, so
+// shape_dist_traveled<100 skips rows without a distance. Comparisons are combined with &&, || and !,
+// and can be grouped with parentheses. Values containing spaces or operator characters must be quoted
+// with single or double quotes.
+package predicate
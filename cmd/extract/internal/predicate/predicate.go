@@ -0,0 +1,332 @@
+package predicate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+)
+
+var (
+	ErrSyntax       = errors.New("invalid where expression")
+	ErrUnknownField = errors.New("field used in where expression is not in the file header")
+)
+
+// Predicate is a parsed where expression. It implements rows.Processor.
+type Predicate struct {
+	source string
+	root   node
+}
+
+// Parse parses a where expression.
+func Parse(expression string) (*Predicate, error) {
+	p := &parser{src: expression}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	return &Predicate{source: expression, root: root}, nil
+}
+
+// String returns the expression the predicate was parsed from.
+func (p *Predicate) String() string {
+	return p.source
+}
+
+// Bind resolves the fields used by the expression against the header.
+func (p *Predicate) Bind(header []string) (rows.Func, error) {
+	eval, err := p.root.bind(header)
+	if err != nil {
+		return nil, fmt.Errorf("%w (expression %q)", err, p.source)
+	}
+	return func(record []string) (bool, error) {
+		return eval(record), nil
+	}, nil
+}
+
+type evalFunc func(record []string) bool
+
+type node interface {
+	bind(header []string) (evalFunc, error)
+}
+
+type orNode struct{ left, right node }
+type andNode struct{ left, right node }
+type notNode struct{ operand node }
+
+type comparisonNode struct {
+	field string
+	op    string
+	value string
+	// set for numeric constants, used by ordering operators
+	number   float64
+	isNumber bool
+	// set for ~ and !~
+	re *regexp.Regexp
+}
+
+func (n *orNode) bind(header []string) (evalFunc, error) {
+	l, r, err := bindPair(header, n.left, n.right)
+	if err != nil {
+		return nil, err
+	}
+	return func(record []string) bool { return l(record) || r(record) }, nil
+}
+
+func (n *andNode) bind(header []string) (evalFunc, error) {
+	l, r, err := bindPair(header, n.left, n.right)
+	if err != nil {
+		return nil, err
+	}
+	return func(record []string) bool { return l(record) && r(record) }, nil
+}
+
+func (n *notNode) bind(header []string) (evalFunc, error) {
+	o, err := n.operand.bind(header)
+	if err != nil {
+		return nil, err
+	}
+	return func(record []string) bool { return !o(record) }, nil
+}
+
+func bindPair(header []string, left, right node) (evalFunc, evalFunc, error) {
+	l, err := left.bind(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := right.bind(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, r, nil
+}
+
+func (n *comparisonNode) bind(header []string) (evalFunc, error) {
+	idx := slices.Index(header, n.field)
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownField, n.field)
+	}
+	value := func(record []string) string {
+		// Ragged rows are treated as having empty trailing fields
+		if idx < len(record) {
+			return record[idx]
+		}
+		return ""
+	}
+	switch n.op {
+	case "=":
+		return func(record []string) bool { return value(record) == n.value }, nil
+	case "!=":
+		return func(record []string) bool { return value(record) != n.value }, nil
+	case "~":
+		return func(record []string) bool { return n.re.MatchString(value(record)) }, nil
+	case "!~":
+		return func(record []string) bool { return !n.re.MatchString(value(record)) }, nil
+	}
+	return func(record []string) bool {
+		v := value(record)
+		// A missing value is neither smaller nor larger than anything
+		if strings.TrimSpace(v) == "" {
+			return false
+		}
+		c := n.compare(v)
+		switch n.op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default: // ">="
+			return c >= 0
+		}
+	}, nil
+}
+
+// compare compares the field value with the constant of the comparison.
+func (n *comparisonNode) compare(v string) int {
+	if n.isNumber {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			switch {
+			case f < n.number:
+				return -1
+			case f > n.number:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	return strings.Compare(v, n.value)
+}
+
+// --------------------------------------
+// Parsing
+// --------------------------------------
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, a ...any) error {
+	return fmt.Errorf("%w %q at offset %d: %s", ErrSyntax, p.src, p.pos, fmt.Sprintf(format, a...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// consume skips whitespace and consumes token if it is next in the input.
+func (p *parser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.consume("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("missing closing parenthesis")
+		}
+		return inner, nil
+	}
+	// "!=" and "!~" can't start an operand, so a leading "!" is always a negation
+	if p.consume("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+// Longer operators first, so "<=" is not read as "<"
+var operators = []string{"==", "!=", "!~", "<=", ">=", "=", "<", ">", "~"}
+
+func (p *parser) parseComparison() (node, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && isFieldChar(p.src[p.pos]) {
+		p.pos++
+	}
+	field := p.src[start:p.pos]
+	if field == "" {
+		return nil, p.errorf("expected field name")
+	}
+
+	p.skipSpace()
+	op := ""
+	for _, o := range operators {
+		if strings.HasPrefix(p.src[p.pos:], o) {
+			op = o
+			p.pos += len(o)
+			break
+		}
+	}
+	if op == "" {
+		return nil, p.errorf("expected comparison operator after field %s", field)
+	}
+	if op == "==" {
+		op = "="
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &comparisonNode{field: field, op: op, value: value}
+	switch op {
+	case "~", "!~":
+		n.re, err = regexp.Compile(value)
+		if err != nil {
+			return nil, p.errorf("invalid regular expression %q: %v", value, err)
+		}
+	case "<", "<=", ">", ">=":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			n.number, n.isNumber = f, true
+		}
+	}
+	return n, nil
+}
+
+// parseValue reads a quoted or bare value. Bare values end at whitespace, a closing
+// parenthesis or a boolean operator.
+func (p *parser) parseValue() (string, error) {
+	p.skipSpace()
+	if p.pos < len(p.src) && (p.src[p.pos] == '"' || p.src[p.pos] == '\'') {
+		quote := p.src[p.pos]
+		end := strings.IndexByte(p.src[p.pos+1:], quote)
+		if end < 0 {
+			return "", p.errorf("unterminated quoted value")
+		}
+		value := p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return value, nil
+	}
+	start := p.pos
+	for p.pos < len(p.src) {
+		rest := p.src[p.pos:]
+		if rest[0] == ' ' || rest[0] == '\t' || rest[0] == ')' ||
+			strings.HasPrefix(rest, "&&") || strings.HasPrefix(rest, "||") {
+			break
+		}
+		p.pos++
+	}
+	// An empty bare value is allowed, "field=" matches empty fields
+	return p.src[start:p.pos], nil
+}
+
+func isFieldChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,160 @@
+package predicate_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/predicate"
+)
+
+func TestPredicate(t *testing.T) {
+	header := []string{"stop_id", "stop_name", "wheelchair_boarding", "route_type"}
+	tests := []struct {
+		name       string
+		expression string
+		record     []string
+		want       bool
+	}{
+		{
+			name:       "equality",
+			expression: "route_type=3",
+			record:     []string{"1", "Bavarski dvor", "1", "3"},
+			want:       true,
+		},
+		{
+			name:       "double equals",
+			expression: "route_type==3",
+			record:     []string{"1", "Bavarski dvor", "1", "700"},
+			want:       false,
+		},
+		{
+			name:       "inequality",
+			expression: "wheelchair_boarding!=2",
+			record:     []string{"1", "Bavarski dvor", "2", "3"},
+			want:       false,
+		},
+		{
+			name:       "empty value",
+			expression: "wheelchair_boarding=",
+			record:     []string{"1", "Bavarski dvor", "", "3"},
+			want:       true,
+		},
+		{
+			name:       "numeric comparison",
+			expression: "route_type>=700 && route_type<800",
+			record:     []string{"1", "Bavarski dvor", "", "715"},
+			want:       true,
+		},
+		{
+			name:       "numeric comparison is not lexicographic",
+			expression: "route_type<20",
+			record:     []string{"1", "Bavarski dvor", "", "3"},
+			want:       true,
+		},
+		{
+			name:       "empty value is not less",
+			expression: "wheelchair_boarding<1",
+			record:     []string{"1", "Bavarski dvor", "", "3"},
+			want:       false,
+		},
+		{
+			name:       "empty value is not greater or equal",
+			expression: "wheelchair_boarding>=''",
+			record:     []string{"1", "Bavarski dvor", "", "3"},
+			want:       false,
+		},
+		{
+			name:       "missing trailing value is not less",
+			expression: "route_type<100",
+			record:     []string{"1", "Bavarski dvor", ""},
+			want:       false,
+		},
+		{
+			name:       "negated comparison matches empty value",
+			expression: "!(wheelchair_boarding<1)",
+			record:     []string{"1", "Bavarski dvor", "", "3"},
+			want:       true,
+		},
+		{
+			name:       "regex match",
+			expression: `stop_name~"^Bavarski"`,
+			record:     []string{"1", "Bavarski dvor", "", "3"},
+			want:       true,
+		},
+		{
+			name:       "regex non-match",
+			expression: "stop_name!~'dvor$'",
+			record:     []string{"1", "Bavarski dvor", "", "3"},
+			want:       false,
+		},
+		{
+			name:       "or with grouping",
+			expression: "(route_type=3 && wheelchair_boarding=1) || stop_id=2",
+			record:     []string{"2", "Bavarski dvor", "0", "3"},
+			want:       true,
+		},
+		{
+			name:       "negation",
+			expression: "!(route_type=3)",
+			record:     []string{"2", "Bavarski dvor", "0", "3"},
+			want:       false,
+		},
+		{
+			name:       "quoted value with spaces",
+			expression: "stop_name='Bavarski dvor'",
+			record:     []string{"2", "Bavarski dvor", "0", "3"},
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := predicate.Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			f, err := p.Bind(header)
+			if err != nil {
+				t.Fatalf("Bind() failed: %v", err)
+			}
+			got, err := f(tt.record)
+			if err != nil {
+				t.Fatalf("evaluation failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.expression, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+	}{
+		{name: "missing operator", expression: "route_type"},
+		{name: "missing field", expression: "=3"},
+		{name: "unclosed parenthesis", expression: "(route_type=3"},
+		{name: "unterminated quote", expression: "stop_name='abc"},
+		{name: "invalid regex", expression: "stop_name~'('"},
+		{name: "dangling operator", expression: "route_type=3 &&"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := predicate.Parse(tt.expression)
+			if !errors.Is(err, predicate.ErrSyntax) {
+				t.Errorf("Parse(%q) error = %v, want %v", tt.expression, err, predicate.ErrSyntax)
+			}
+		})
+	}
+}
+
+func TestBind_UnknownField(t *testing.T) {
+	p, err := predicate.Parse("route_color=FF0000")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if _, err := p.Bind([]string{"route_id", "route_type"}); !errors.Is(err, predicate.ErrUnknownField) {
+		t.Errorf("Bind() error = %v, want %v", err, predicate.ErrUnknownField)
+	}
+}
@@ -0,0 +1,3 @@
+// Package rows defines the row processing contract shared by the extract command.
+// Row processors filter or rewrite data rows of a single GTFS file before fields are masked out.
+package rows
@@ -0,0 +1,78 @@
+package rows
+
+import "slices"
+
+// Func is a bound row processor. It may rewrite fields of record in place and
+// reports whether the row should be kept.
+type Func func(record []string) (bool, error)
+
+// Processor is a row processor that has not yet seen the header of the file it is applied to.
+// Bind is called once per read of the file, so any state the returned Func needs should be created there.
+type Processor interface {
+	Bind(header []string) (Func, error)
+}
+
+// BindAll binds all processors to the header and chains them into a single Func.
+// A nil Func is returned if there are no processors.
+func BindAll(header []string, processors []Processor) (Func, error) {
+	if len(processors) == 0 {
+		return nil, nil
+	}
+	funcs := make([]Func, 0, len(processors))
+	for _, p := range processors {
+		f, err := p.Bind(header)
+		if err != nil {
+			return nil, err
+		}
+		funcs = append(funcs, f)
+	}
+	return func(record []string) (bool, error) {
+		for _, f := range funcs {
+			keep, err := f(record)
+			if err != nil || !keep {
+				return false, err
+			}
+		}
+		return true, nil
+	}, nil
+}
+
+// ProcessorFunc adapts a function to the Processor interface.
+type ProcessorFunc func(header []string) (Func, error)
+
+func (f ProcessorFunc) Bind(header []string) (Func, error) {
+	return f(header)
+}
+
+// KeySet is a set of entity IDs, like stop_id or trip_id values.
+type KeySet map[string]struct{}
+
+func (s KeySet) Add(key string) {
+	s[key] = struct{}{}
+}
+
+func (s KeySet) Has(key string) bool {
+	_, ok := s[key]
+	return ok
+}
+
+// KeepKeys returns a processor that keeps rows whose values of the given fields are all in keys.
+// Empty values and fields missing from the header don't restrict the row.
+func KeepKeys(keys KeySet, fields ...string) Processor {
+	return ProcessorFunc(func(header []string) (Func, error) {
+		var indices []int
+		for _, field := range fields {
+			if i := slices.Index(header, field); i >= 0 {
+				indices = append(indices, i)
+			}
+		}
+		return func(record []string) (bool, error) {
+			for _, i := range indices {
+				if i < len(record) && record[i] != "" && !keys.Has(record[i]) {
+					return false, nil
+				}
+			}
+			return true, nil
+		}, nil
+	})
+}
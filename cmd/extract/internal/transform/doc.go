@@ -0,0 +1,12 @@
+// Package transform implements the value transformations used by the --transform option of the extract command.
+//
+// A transformation changes the value of a single field of every row, for example:
+//
+//	trim                  removes leading and trailing white space
+//	upper, lower          changes the case
+//	replace,/ +/ /        replaces matches of a regular expression; the first character is the delimiter,
+//	                      and the replacement can refer to groups with $1
+//	default,0             sets a value if the field is empty
+//	set,3                 overwrites the value with a constant
+//	null                  clears the value
+package transform
@@ -0,0 +1,118 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/rows"
+)
+
+var (
+	ErrInvalidTransform = errors.New("invalid transformation")
+	ErrUnknownField     = errors.New("transformed field is not in the file header")
+)
+
+// Transform is a parsed transformation of a single field. It implements rows.Processor.
+type Transform struct {
+	field  string
+	source string
+	apply  func(value string) string
+}
+
+// Parse parses a transformation of field, given as an operation with an optional argument separated by a comma,
+// like "trim" or "default,0".
+func Parse(field, operation string) (*Transform, error) {
+	name, arg, hasArg := strings.Cut(operation, ",")
+	t := &Transform{field: field, source: operation}
+	requireArg := func(want bool) error {
+		if hasArg != want {
+			if want {
+				return fmt.Errorf("%w: %s needs an argument", ErrInvalidTransform, name)
+			}
+			return fmt.Errorf("%w: %s doesn't take an argument", ErrInvalidTransform, name)
+		}
+		return nil
+	}
+
+	var err error
+	switch name {
+	case "trim":
+		err = requireArg(false)
+		t.apply = strings.TrimSpace
+	case "upper":
+		err = requireArg(false)
+		t.apply = strings.ToUpper
+	case "lower":
+		err = requireArg(false)
+		t.apply = strings.ToLower
+	case "null":
+		err = requireArg(false)
+		t.apply = func(string) string { return "" }
+	case "set":
+		err = requireArg(true)
+		t.apply = func(string) string { return arg }
+	case "default":
+		err = requireArg(true)
+		t.apply = func(value string) string {
+			if value == "" {
+				return arg
+			}
+			return value
+		}
+	case "replace":
+		if err = requireArg(true); err == nil {
+			t.apply, err = parseReplace(arg)
+		}
+	default:
+		err = fmt.Errorf("%w: unknown operation %q", ErrInvalidTransform, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseReplace parses /regex/replacement/, where / can be any character not used in regex and replacement.
+func parseReplace(arg string) (func(string) string, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("%w: replace needs /regex/replacement/", ErrInvalidTransform)
+	}
+	delimiter := arg[:1]
+	parts := strings.Split(arg[1:], delimiter)
+	if len(parts) != 3 || parts[2] != "" {
+		return nil, fmt.Errorf("%w: replace needs %sregex%sreplacement%s", ErrInvalidTransform, delimiter, delimiter, delimiter)
+	}
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTransform, err)
+	}
+	replacement := parts[1]
+	return func(value string) string {
+		return re.ReplaceAllString(value, replacement)
+	}, nil
+}
+
+// Field returns the name of the transformed field.
+func (t *Transform) Field() string {
+	return t.field
+}
+
+func (t *Transform) String() string {
+	return t.field + "," + t.source
+}
+
+func (t *Transform) Bind(header []string) (rows.Func, error) {
+	i := slices.Index(header, t.field)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownField, t.field)
+	}
+	return func(record []string) (bool, error) {
+		if i < len(record) {
+			record[i] = t.apply(record[i])
+		}
+		return true, nil
+	}, nil
+}
@@ -0,0 +1,80 @@
+package transform_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/transform"
+)
+
+func TestTransform(t *testing.T) {
+	header := []string{"stop_id", "stop_name", "wheelchair_boarding"}
+	tests := []struct {
+		name      string
+		field     string
+		operation string
+		record    []string
+		want      string
+	}{
+		{name: "trim", field: "stop_id", operation: "trim", record: []string{" P1 ", "", ""}, want: "P1"},
+		{name: "upper", field: "stop_name", operation: "upper", record: []string{"", "Bavarski dvor", ""}, want: "BAVARSKI DVOR"},
+		{name: "lower", field: "stop_name", operation: "lower", record: []string{"", "Bavarski Dvor", ""}, want: "bavarski dvor"},
+		{name: "replace", field: "stop_name", operation: "replace,/ +/ /", record: []string{"", "Bavarski   dvor", ""}, want: "Bavarski dvor"},
+		{name: "replace with groups and other delimiter", field: "stop_name", operation: "replace,|(\\w+) (\\w+)|$2 $1|", record: []string{"", "Bavarski dvor", ""}, want: "dvor Bavarski"},
+		{name: "replace with commas", field: "stop_name", operation: "replace,/, /,/", record: []string{"", "Ljubljana, Bavarski dvor", ""}, want: "Ljubljana,Bavarski dvor"},
+		{name: "default of empty value", field: "wheelchair_boarding", operation: "default,0", record: []string{"", "", ""}, want: "0"},
+		{name: "default keeps value", field: "wheelchair_boarding", operation: "default,0", record: []string{"", "", "1"}, want: "1"},
+		{name: "set", field: "wheelchair_boarding", operation: "set,2", record: []string{"", "", "1"}, want: "2"},
+		{name: "null", field: "stop_name", operation: "null", record: []string{"", "Bavarski dvor", ""}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := transform.Parse(tt.field, tt.operation)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			f, err := tr.Bind(header)
+			if err != nil {
+				t.Fatalf("Bind() failed: %v", err)
+			}
+			keep, err := f(tt.record)
+			if err != nil || !keep {
+				t.Fatalf("transformation = %v, %v, want true, nil", keep, err)
+			}
+			if got := tt.record[slices.Index(header, tt.field)]; got != tt.want {
+				t.Errorf("%s = %q, want %q", tr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+	}{
+		{name: "unknown operation", operation: "shout"},
+		{name: "missing argument", operation: "default"},
+		{name: "unexpected argument", operation: "trim,all"},
+		{name: "replace without delimiters", operation: "replace,abc"},
+		{name: "invalid regex", operation: "replace,/(/x/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := transform.Parse("stop_name", tt.operation); !errors.Is(err, transform.ErrInvalidTransform) {
+				t.Errorf("Parse(%q) error = %v, want %v", tt.operation, err, transform.ErrInvalidTransform)
+			}
+		})
+	}
+}
+
+func TestBind_UnknownField(t *testing.T) {
+	tr, err := transform.Parse("route_color", "upper")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if _, err := tr.Bind([]string{"route_id", "route_type"}); !errors.Is(err, transform.ErrUnknownField) {
+		t.Errorf("Bind() error = %v, want %v", err, transform.ErrUnknownField)
+	}
+}
@@ -0,0 +1,31 @@
+package extract
+
+import (
+	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract/internal/params"
+	"github.com/spf13/cobra"
+)
+
+// PruneCmd represents the prune command, which removes unreferenced entities from GTFS data
+// without any other filtering. It is the same as extract with only --prune-orphans given.
+var PruneCmd = &cobra.Command{
+	Use:   "prune [flags]... input-gtfs output-gtfs",
+	Short: "Remove unreferenced entities from GTFS data",
+	Long: `Removes entities nothing refers to, like stops no trip visits, shapes no trip uses,
+services no trip runs on and agencies without routes, together with rows referring to removed
+entities, until nothing more can be removed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runExtractor(args[0], args[1])
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		_params = params.NewExtractParams(nil, nil, false, false, false, nil, nil).
+			WithPruneOrphans(true).
+			WithStrict(_strict)
+		return newExtractor()
+	},
+
+	Args: cobra.ExactArgs(2),
+}
+
+func init() {
+	addInputFlags(PruneCmd)
+}
@@ -1,8 +1,12 @@
 package mergeparams
 
+import "github.com/InternatManhole/dujpp-gtfs-tool/internal/columnorder"
+
 type MergeParams struct {
 	prefixes []string
 	force    bool
+	// nil to keep the union of the input headers in order of appearance
+	columnOrder *columnorder.Order
 }
 
 func NewMergeParams(prefixes []string, force bool) *MergeParams {
@@ -12,6 +16,12 @@ func NewMergeParams(prefixes []string, force bool) *MergeParams {
 	}
 }
 
+// WithColumnOrder sets the order of the columns of the merged files.
+func (m *MergeParams) WithColumnOrder(order *columnorder.Order) *MergeParams {
+	m.columnOrder = order
+	return m
+}
+
 func (m *MergeParams) GetPrefixes() []string {
 	return m.prefixes
 }
@@ -19,3 +29,7 @@ func (m *MergeParams) GetPrefixes() []string {
 func (m *MergeParams) IsForce() bool {
 	return m.force
 }
+
+func (m *MergeParams) GetColumnOrder() *columnorder.Order {
+	return m.columnOrder
+}
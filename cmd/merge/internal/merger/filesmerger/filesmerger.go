@@ -7,7 +7,9 @@ import (
 	"strings"
 
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/merge/internal/mergeparams"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/columnorder"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/schema"
 	"github.com/samber/lo"
 )
 
@@ -22,6 +24,10 @@ type FilesMerger struct {
 	// Add fields as necessary for merging files
 	prefixes []string
 	force    bool
+
+	// name of the merged file, for the column order
+	fileName    string
+	columnOrder *columnorder.Order
 }
 
 func NewFilesMerger(prefixes []string, force bool) *FilesMerger {
@@ -38,6 +44,14 @@ func NewFilesMergerWithParams(params mergeparams.MergeParams) *FilesMerger {
 	}
 }
 
+// WithColumnOrder sets the order of the columns of the merged file fileName, nil to keep
+// the union of the input headers in order of appearance.
+func (fm *FilesMerger) WithColumnOrder(fileName string, order *columnorder.Order) *FilesMerger {
+	fm.fileName = fileName
+	fm.columnOrder = order
+	return fm
+}
+
 func (fm *FilesMerger) MergeFiles(inputFiles []io.Reader, writerCreate func() (io.Writer, func())) error {
 	logger := logging.GetLogger()
 	// All the input files refer to the same GTFS file from different archives.
@@ -61,10 +75,15 @@ func (fm *FilesMerger) MergeFiles(inputFiles []io.Reader, writerCreate func() (i
 
 	// The common headers after merging
 	unionHeader := lo.Union(headers...)
+	if fm.columnOrder != nil {
+		orderedHeader := make([]string, len(unionHeader))
+		columnorder.Reorder(orderedHeader, unionHeader, fm.columnOrder.Permutation(fm.fileName, unionHeader))
+		unionHeader = orderedHeader
+	}
 
 	// Mask of unionHeader indicating which columns are ID fields
 	idFieldsMask := lo.Map(unionHeader, func(columnName string, index int) bool {
-		return strings.HasSuffix(columnName, "_id")
+		return isIDField(fm.fileName, columnName)
 	})
 
 	logger.Verbose("Final file will have unified header: \"%s\"", unionHeader)
@@ -80,8 +99,8 @@ func (fm *FilesMerger) MergeFiles(inputFiles []io.Reader, writerCreate func() (i
 		return err
 	}
 
-	// map[columnName]set-of-ids seen so far
-	readIds := make(map[string]map[string]any)
+	// map[idKey]id->index of the first input file with the id
+	readIds := make(map[string]map[string]int)
 
 	// Count blank prefixes and ensure at most one and only valid for first file
 	blankCount := 0
@@ -131,13 +150,15 @@ func (fm *FilesMerger) MergeFiles(inputFiles []io.Reader, writerCreate func() (i
 					fullRecord[i] = ""
 				}
 
-				if idFieldsMask[i] {
+				if idFieldsMask[i] && fullRecord[i] != "" {
 					// Remember the ID field for conflict checking
-					if _, exists := readIds[colName]; !exists {
-						readIds[colName] = make(map[string]any)
+					key := idKey(fm.fileName, colName)
+					if _, exists := readIds[key]; !exists {
+						readIds[key] = make(map[string]int)
 					}
-					// If this value already exists, we have a conflict
-					if _, exists := readIds[colName][fullRecord[i]]; exists {
+					// If this value exists in an earlier input, we have a conflict. The same value
+					// in the same input is not, like stops with the same parent_station.
+					if firstIndex, exists := readIds[key][fullRecord[i]]; exists && firstIndex < fileIndex {
 						if fm.force {
 							// Ignore conflict, keep original id (allow duplicate)
 							continue
@@ -150,9 +171,9 @@ func (fm *FilesMerger) MergeFiles(inputFiles []io.Reader, writerCreate func() (i
 							// Ambiguous: conflict detected and current input has blank prefix
 							return ErrorCannotDisambiguate
 						}
-					} else {
+					} else if !exists {
 						// No conflict, record the ID
-						readIds[colName][fullRecord[i]] = nil
+						readIds[key][fullRecord[i]] = fileIndex
 					}
 				}
 			}
@@ -165,3 +186,26 @@ func (fm *FilesMerger) MergeFiles(inputFiles []io.Reader, writerCreate func() (i
 
 	return nil
 }
+
+// isIDField reports whether the values of the column of the file identify entities, so the same value
+// in different inputs is a conflict. Fields of the GTFS reference are IDs if the schema says so, like
+// parent_station of stops.txt, other fields if their name ends in _id.
+func isIDField(fileName, columnName string) bool {
+	if file, ok := schema.Lookup(fileName); ok {
+		if field, ok := file.Field(columnName); ok {
+			return field.Type == schema.ID
+		}
+	}
+	return strings.HasSuffix(columnName, "_id")
+}
+
+// idKey returns the key of the IDs of the column of the file. Columns referring to the same field
+// share it, like stop_id and parent_station of stops.txt, so a renamed ID is renamed in all of them.
+func idKey(fileName, columnName string) string {
+	if file, ok := schema.Lookup(fileName); ok {
+		if field, ok := file.Field(columnName); ok && field.References != nil && field.References.File == fileName {
+			return field.References.Field
+		}
+	}
+	return columnName
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/merge/internal/mergeparams"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/columnorder"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
 )
 
@@ -222,3 +223,55 @@ func equalStrings(a, b []string) bool {
 	}
 	return true
 }
+
+func TestMergeFiles_ColumnOrder(t *testing.T) {
+	in := []io.Reader{
+		strings.NewReader("stop_name,stop_id\nKonzorcij,P1\n"),
+		strings.NewReader("stop_lon,stop_id,lpp_code\n14.5,P2,600011\n"),
+	}
+	var out bytes.Buffer
+	writerCreate := func() (io.Writer, func()) {
+		return &out, func() {}
+	}
+
+	order, err := columnorder.Parse(columnorder.Canonical, nil)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	params := *mergeparams.NewMergeParams([]string{"", "p2_"}, false)
+	fm := NewFilesMergerWithParams(params).WithColumnOrder("stops.txt", order)
+	if err := fm.MergeFiles(in, writerCreate); err != nil {
+		t.Fatalf("MergeFiles failed: %v", err)
+	}
+
+	want := "stop_id,stop_name,stop_lon,lpp_code\nP1,Konzorcij,,\nP2,,14.5,600011\n"
+	if out.String() != want {
+		t.Errorf("MergeFiles output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestMergeFiles_SchemaIDFields(t *testing.T) {
+	in := []io.Reader{
+		strings.NewReader("stop_id,stop_name,parent_station,lpp_code\nS1,Konzorcij,,600011\nP1,Konzorcij,S1,600012\nP2,Konzorcij,S1,600012\n"),
+		strings.NewReader("stop_id,stop_name,parent_station,lpp_code\nS1,Bavarski dvor,,600011\nP3,Bavarski dvor,S1,600021\n"),
+	}
+	var out bytes.Buffer
+	writerCreate := func() (io.Writer, func()) {
+		return &out, func() {}
+	}
+
+	params := *mergeparams.NewMergeParams([]string{"", "p2_"}, false)
+	fm := NewFilesMergerWithParams(params).WithColumnOrder("stops.txt", nil)
+	if err := fm.MergeFiles(in, writerCreate); err != nil {
+		t.Fatalf("MergeFiles failed: %v", err)
+	}
+
+	// parent_station refers to stop_id, so it is prefixed with it, and repeats within an input without
+	// conflict; stop_name and lpp_code are not IDs, and empty parent stations stay empty
+	want := "stop_id,stop_name,parent_station,lpp_code\n" +
+		"S1,Konzorcij,,600011\nP1,Konzorcij,S1,600012\nP2,Konzorcij,S1,600012\n" +
+		"p2_S1,Bavarski dvor,,600011\nP3,Bavarski dvor,p2_S1,600021\n"
+	if out.String() != want {
+		t.Errorf("MergeFiles output = %q, want %q", out.String(), want)
+	}
+}
@@ -1,11 +1,11 @@
 package merger
 
 import (
-	"archive/zip"
 	"io"
 
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/merge/internal/mergeparams"
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/merge/internal/merger/filesmerger"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
 )
 
@@ -19,7 +19,8 @@ func NewMerger(params *mergeparams.MergeParams) *Merger {
 	}
 }
 
-func (m *Merger) Merge(inputArchives []*zip.Reader, outputArchive *zip.Writer) error {
+// Merge merges the input feeds into the output feed. Feeds can be zip archives or directories.
+func (m *Merger) Merge(inputArchives []gtfsio.Source, outputArchive gtfsio.Sink) error {
 	logger := logging.GetLogger()
 	// Plan:
 	// 1. Read the same .txt files from all inputArchives
@@ -32,13 +33,13 @@ func (m *Merger) Merge(inputArchives []*zip.Reader, outputArchive *zip.Writer) e
 	// First collect all unique file names across all input archives
 	allFileNames := map[string][]io.Reader{} // used as a set
 	for _, inputArchive := range inputArchives {
-		for _, file := range inputArchive.File {
+		for _, file := range inputArchive.Files() {
 			rc, err := file.Open()
 			if err != nil {
-				logger.Error("Failed to open file %s: %v", file.Name, err)
+				logger.Error("Failed to open file %s: %v", file.Name(), err)
 				return err
 			}
-			allFileNames[file.Name] = append(allFileNames[file.Name], rc)
+			allFileNames[file.Name()] = append(allFileNames[file.Name()], rc)
 			defer rc.Close()
 		}
 	}
@@ -46,14 +47,15 @@ func (m *Merger) Merge(inputArchives []*zip.Reader, outputArchive *zip.Writer) e
 	// For each unique file name, merge contents from all input archives
 	for fileName, rcs := range allFileNames {
 		logger.Info("Merging file: %s, in %d archives", fileName, len(rcs))
-		fileMerger := filesmerger.NewFilesMergerWithParams(*m.params)
+		fileMerger := filesmerger.NewFilesMergerWithParams(*m.params).
+			WithColumnOrder(fileName, m.params.GetColumnOrder())
 		err := fileMerger.MergeFiles(rcs, func() (io.Writer, func()) {
 			w, err := outputArchive.Create(fileName)
 			if err != nil {
 				logger.Error("Failed to create file %s in output archive: %v", fileName, err)
 				return nil, func() {}
 			}
-			return w, func() {}
+			return w, func() { w.Close() }
 		})
 		if err != nil {
 			logger.Error("Failed to merge file %s: %v", fileName, err)
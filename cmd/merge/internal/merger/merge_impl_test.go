@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/merge/internal/mergeparams"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
 )
 
@@ -55,7 +56,7 @@ func TestMerger_Merge_Stops(t *testing.T) {
 	params := mergeparams.NewMergeParams([]string{"p1_", "p2_"}, false)
 	m := NewMerger(params)
 
-	if err := m.Merge([]*zip.Reader{zr1, zr2}, zw); err != nil {
+	if err := m.Merge([]gtfsio.Source{gtfsio.NewZipSource(zr1), gtfsio.NewZipSource(zr2)}, gtfsio.NewZipSink(zw)); err != nil {
 		t.Fatalf("Merge failed: %v", err)
 	}
 
@@ -138,7 +139,7 @@ func TestMerger_Merge_Stops(t *testing.T) {
 		params2 := mergeparams.NewMergeParams([]string{"", "p2_"}, false)
 		m2 := NewMerger(params2)
 
-		if err := m2.Merge([]*zip.Reader{zr1, zr2}, zw2); err != nil {
+		if err := m2.Merge([]gtfsio.Source{gtfsio.NewZipSource(zr1), gtfsio.NewZipSource(zr2)}, gtfsio.NewZipSink(zw2)); err != nil {
 			t.Fatalf("Merge failed for blank-prefix subtest: %v", err)
 		}
 		if err := zw2.Close(); err != nil {
@@ -212,7 +213,7 @@ func TestMerger_Merge_Stops(t *testing.T) {
 		params3 := mergeparams.NewMergeParams([]string{"p1_", "p2_"}, true)
 		m3 := NewMerger(params3)
 
-		if err := m3.Merge([]*zip.Reader{zr1, zr2}, zw3); err != nil {
+		if err := m3.Merge([]gtfsio.Source{gtfsio.NewZipSource(zr1), gtfsio.NewZipSource(zr2)}, gtfsio.NewZipSink(zw3)); err != nil {
 			t.Fatalf("Merge failed for force-mode subtest: %v", err)
 		}
 		if err := zw3.Close(); err != nil {
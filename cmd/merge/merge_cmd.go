@@ -1,19 +1,26 @@
 package merge
 
 import (
-	"archive/zip"
-	"os"
+	"errors"
 
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/merge/internal/mergeparams"
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/merge/internal/merger"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/columnorder"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
 	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 )
 
+var ErrMultipleStdinInputs = errors.New("only one input GTFS feed can be read from standard input")
+
 var (
-	_prefixes []string
-	_force    bool
+	_prefixes       []string
+	_force          bool
+	_input_encoding string
+
+	_column_order        string
+	_custom_column_order []string
 
 	_inputs []string
 	_output string
@@ -33,46 +40,55 @@ var MergeCmd = &cobra.Command{
 		logger.Info("Input GTFS files: %v", _inputs)
 		_output = args[len(args)-1]
 		logger.Info("Output GTFS file: %s", _output)
+		if lo.Count(_inputs, gtfsio.Stdio) > 1 {
+			return ErrMultipleStdinInputs
+		}
 		return nil
 	},
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := logging.GetLogger()
 
-		mergeParams := mergeparams.NewMergeParams(_prefixes, _force)
+		enc, err := gtfsio.LookupEncoding(_input_encoding)
+		if err != nil {
+			return err
+		}
+
+		order, err := columnorder.Parse(_column_order, _custom_column_order)
+		if err != nil {
+			return err
+		}
+
+		mergeParams := mergeparams.NewMergeParams(_prefixes, _force).
+			WithColumnOrder(order)
 		merger := merger.NewMerger(mergeParams)
 		logger.Verbose("Using prefixes: %v", _prefixes)
 
-		inputZips := lo.Map(_inputs, func(inPath string, index int) *zip.ReadCloser {
-			zr, err := zip.OpenReader(inPath)
+		inputs := make([]gtfsio.Source, 0, len(_inputs))
+		defer func() {
+			lo.ForEach(inputs, func(s gtfsio.Source, i int) {
+				s.Close()
+			})
+		}()
+		for _, inPath := range _inputs {
+			source, err := gtfsio.OpenSource(inPath)
 			if err != nil {
-				logger.Error("Failed to open input GTFS zip file %s: %v", inPath, err)
-				return nil
+				logger.Error("Failed to open input GTFS feed %s: %v", inPath, err)
+				return err
 			}
-			return zr
-		})
-		defer lo.ForEach(inputZips, func(z *zip.ReadCloser, i int) {
-			if z != nil {
-				z.Close()
-			}
-		})
+			inputs = append(inputs, gtfsio.DecodeSource(source, enc))
+		}
 
-		outputFile, err := os.Create(_output)
+		output, err := gtfsio.CreateSink(_output)
 		if err != nil {
-			logger.Error("Failed to create output GTFS zip file %s: %v", _output, err)
-			return nil
+			logger.Error("Failed to create output GTFS feed %s: %v", _output, err)
+			return err
 		}
-		defer outputFile.Close()
-
-		outputZip := zip.NewWriter(outputFile)
-		defer outputZip.Close()
 
-		err = merger.Merge(lo.Map(inputZips, func(z *zip.ReadCloser, i int) *zip.Reader {
-			if z == nil {
-				return nil
-			}
-			return &z.Reader
-		}), outputZip)
+		err = merger.Merge(inputs, output)
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
 		if err != nil {
 			logger.Error("Merge failed: %v", err)
 			return err
@@ -92,4 +108,10 @@ func init() {
 		"List of prefixes to add to each source GTFS file's entries. If provided, the number of prefixes must match the number of input GTFS files or one prefix will be used for all input files. Only the first prefix may be blank (no prefix).")
 	fl.BoolVarP(&_force, "force", "f", false,
 		"Force merge feeds even if there are conflicting IDs")
+	fl.StringVar(&_input_encoding, "input-encoding", gtfsio.AutoEncoding,
+		"Encoding of the input files, like utf-8, utf-16, windows-1250 or iso-8859-2; auto detects it for every file from the BOM or the first 64 KiB, and fails on invalid UTF-8 later in a file detected as UTF-8. The output is always UTF-8 without BOM")
+	fl.StringVar(&_column_order, "column-order", columnorder.Input,
+		"Order of the columns of the merged files: input (union of the input headers), canonical (as in the GTFS reference, unknown columns last) or custom")
+	fl.StringArrayVar(&_custom_column_order, "custom-column-order", []string{},
+		"Column order of a file for --column-order custom (format: filename,field1,field2,...); other columns follow in input order")
 }
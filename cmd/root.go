@@ -4,10 +4,13 @@ Copyright © 2025 InternatBlackhole
 package cmd
 
 import (
+	"errors"
 	"os"
+	"strings"
 
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/extract"
 	"github.com/InternatManhole/dujpp-gtfs-tool/cmd/merge"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/config"
 	"github.com/InternatManhole/dujpp-gtfs-tool/internal/logging"
 	"github.com/spf13/cobra"
 )
@@ -26,7 +29,13 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
-	PreRunE: func(cmd *cobra.Command, args []string) error {
+	// Persistent, so the logger is also set up for the subcommands
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Before anything else reads the flags, since the config sets the ones not given on the command line
+		if err := applyConfig(cmd); err != nil {
+			return err
+		}
+
 		var logLevel logging.StatusLevel
 		if _verboseverbose {
 			logLevel = logging.EvenMoreVerbose
@@ -49,9 +58,28 @@ func Execute() {
 	}
 }
 
+// applyConfig sets the flags of cmd from the selected profile of the config file, if one is given.
+func applyConfig(cmd *cobra.Command) error {
+	if _cfgFile == "" {
+		if _profile != "" {
+			return errors.New("profile flag requires config flag")
+		}
+		return nil
+	}
+	cfg, err := config.Load(_cfgFile)
+	if err != nil {
+		return err
+	}
+	// Sections are keyed by the path of the command, like export sqlite
+	command := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	return cfg.Apply(_profile, command, cmd.Flags())
+}
+
 var (
 	_verbose        bool
 	_verboseverbose bool
+	_cfgFile        string
+	_profile        string
 )
 
 func init() {
@@ -59,8 +87,6 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dujpp-gtfs-tool.yaml)")
-
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	// rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
@@ -68,8 +94,12 @@ func init() {
 	fl := rootCmd.PersistentFlags()
 	fl.BoolVarP(&_verbose, "verbose", "v", false, "Enable verbose output")
 	fl.BoolVar(&_verboseverbose, "verboseverbose", false, "Enable very verbose output")
+	fl.StringVar(&_cfgFile, "config", "", "YAML or JSON profile file with flag values in sections named after the command, like extract or export sqlite; flags given on the command line override it")
+	fl.StringVar(&_profile, "profile", "", "Name of the profile in the config file to use")
 
 	rootCmd.AddCommand(extract.ExtractCmd)
+	rootCmd.AddCommand(extract.PruneCmd)
+	rootCmd.AddCommand(extract.ExportCmd)
 	rootCmd.AddCommand(merge.MergeCmd)
 
 }
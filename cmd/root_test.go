@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// resetFlags sets the flags of cmd and its subcommands back to their defaults, since they are package globals
+// that keep their values between executions.
+func resetFlags(cmd *cobra.Command) {
+	for _, fl := range []*pflag.FlagSet{cmd.Flags(), cmd.PersistentFlags()} {
+		fl.VisitAll(func(f *pflag.Flag) {
+			if v, ok := f.Value.(pflag.SliceValue); ok {
+				v.Replace(nil)
+			} else {
+				f.Value.Set(f.DefValue)
+			}
+			f.Changed = false
+		})
+	}
+	for _, c := range cmd.Commands() {
+		resetFlags(c)
+	}
+}
+
+// execute runs the root command with args on an input feed directory with the given files, and returns
+// the files of the output feed directory.
+func execute(t *testing.T, files map[string]string, args ...string) map[string]string {
+	t.Cleanup(func() { resetFlags(rootCmd) })
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	if err := os.Mkdir(in, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(in, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "out") + string(filepath.Separator)
+	rootCmd.SetArgs(append(args, in, out))
+	// Usage on errors, the error is reported by the test
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("Execute(%v) failed: %v", args, err)
+	}
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := map[string]string{}
+	for _, e := range entries {
+		content, err := os.ReadFile(filepath.Join(out, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output[e.Name()] = string(content)
+	}
+	return output
+}
+
+func TestApplyConfig_SortList(t *testing.T) {
+	// A list of fields is one --sort per field
+	cfgFile := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := "extract:\n  sort:\n    stop_times.txt: [trip_id, stop_sequence]\n"
+	if err := os.WriteFile(cfgFile, []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := execute(t, map[string]string{
+		"stop_times.txt": "trip_id,stop_id,stop_sequence\nT2,P1,1\nT1,P2,2\nT1,P1,1\nT2,P2,2\n",
+	}, "--config", cfgFile, "extract")
+	if got, want := output["stop_times.txt"], "trip_id,stop_id,stop_sequence\nT1,P1,1\nT1,P2,2\nT2,P1,1\nT2,P2,2\n"; got != want {
+		t.Errorf("stop_times.txt = %q, want %q", got, want)
+	}
+}
+
+func TestExtract_DedupeValue(t *testing.T) {
+	files := map[string]string{"stops.txt": "stop_id,stop_name\nP1,Konzorcij\nP1,Bavarski dvor\n"}
+	for _, args := range [][]string{
+		{"extract", "--dedupe", "keep-last"},
+		{"extract", "--dedupe=keep-last"},
+	} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			output := execute(t, files, args...)
+			if got, want := output["stops.txt"], "stop_id,stop_name\nP1,Bavarski dvor\n"; got != want {
+				t.Errorf("stops.txt = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestExtract_NoPartialOutput(t *testing.T) {
+	t.Cleanup(func() { resetFlags(rootCmd) })
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	if err := os.Mkdir(in, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(in, "stops.txt"), []byte("stop_id,stop_name\nP1,Konzorcij\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.zip")
+	if err := os.WriteFile(out, []byte("previous"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Removing the required stop_id fails the extraction
+	rootCmd.SetArgs([]string{"extract", "--exclude-fields", "stops.txt,stop_id", in, out})
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	if err := rootCmd.Execute(); err == nil {
+		t.Fatal("Execute() succeeded, want an error")
+	}
+	if content, err := os.ReadFile(out); err != nil || string(content) != "previous" {
+		t.Errorf("output = %q, %v, want the previous output", content, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("output directory has %d entries, want only in and out.zip", len(entries))
+	}
+}
@@ -0,0 +1,106 @@
+package columnorder
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/schema"
+)
+
+// Modes of an Order
+const (
+	Input     = "input"     // the order of the input file
+	Canonical = "canonical" // the order of the fields in the GTFS reference, unknown fields after them
+	Custom    = "custom"    // the order of the custom list of the file, other fields after them
+)
+
+var (
+	ErrInvalidMode        = errors.New("invalid column order; must be input, canonical or custom")
+	ErrInvalidCustomOrder = errors.New("invalid custom column order format; must be filename,field1,field2,...")
+	ErrCustomWithoutMode  = errors.New("custom column orders require the custom column order mode")
+)
+
+// Order is the order of the columns of output files.
+type Order struct {
+	mode string
+	// file name -> fields, for the custom mode
+	custom map[string][]string
+}
+
+// Parse creates an Order from the mode and, for the custom mode, the lists of fields in the format
+// filename,field1,field2,... An empty mode is the input order.
+func Parse(mode string, custom []string) (*Order, error) {
+	if mode == "" {
+		mode = Input
+	}
+	if mode != Input && mode != Canonical && mode != Custom {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMode, mode)
+	}
+	if mode != Custom && len(custom) > 0 {
+		return nil, ErrCustomWithoutMode
+	}
+
+	o := &Order{mode: mode, custom: make(map[string][]string, len(custom))}
+	for _, c := range custom {
+		parts := strings.Split(c, ",")
+		if len(parts) < 2 || slices.Contains(parts, "") {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCustomOrder, c)
+		}
+		o.custom[parts[0]] = append(o.custom[parts[0]], parts[1:]...)
+	}
+	return o, nil
+}
+
+// Mode returns the mode of the order, Input, Canonical or Custom.
+func (o *Order) Mode() string {
+	return o.mode
+}
+
+// Permutation returns the order of the columns of a file with the given header, as indices into the header.
+// It returns nil if the columns keep their order. Fields that are not ordered by the mode keep their
+// input order after the ordered ones.
+func (o *Order) Permutation(fileName string, header []string) []int {
+	var fields []string
+	switch o.mode {
+	case Canonical:
+		if file, ok := schema.Lookup(fileName); ok {
+			for _, field := range file.Fields {
+				fields = append(fields, field.Name)
+			}
+		}
+	case Custom:
+		fields = o.custom[fileName]
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	perm := make([]int, 0, len(header))
+	for _, field := range fields {
+		if i := slices.Index(header, field); i >= 0 && !slices.Contains(perm, i) {
+			perm = append(perm, i)
+		}
+	}
+	for i := range header {
+		if !slices.Contains(perm, i) {
+			perm = append(perm, i)
+		}
+	}
+	if slices.IsSorted(perm) {
+		return nil
+	}
+	return perm
+}
+
+// Reorder sets dst to the values of src in the order of the permutation. A nil permutation copies src.
+func Reorder(dst, src []string, perm []int) {
+	if perm == nil {
+		copy(dst, src)
+		return
+	}
+	for i, j := range perm {
+		dst[i] = src[j]
+	}
+}
@@ -0,0 +1,103 @@
+package columnorder_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/columnorder"
+)
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		custom  []string
+		wantErr error
+	}{
+		{"default", "", nil, nil},
+		{"canonical", columnorder.Canonical, nil, nil},
+		{"custom", columnorder.Custom, []string{"stops.txt,stop_name,stop_id"}, nil},
+		{"unknown mode", "alphabetical", nil, columnorder.ErrInvalidMode},
+		{"custom lists without custom mode", columnorder.Canonical, []string{"stops.txt,stop_id"}, columnorder.ErrCustomWithoutMode},
+		{"custom list without fields", columnorder.Custom, []string{"stops.txt"}, columnorder.ErrInvalidCustomOrder},
+		{"custom list with empty field", columnorder.Custom, []string{"stops.txt,stop_id,"}, columnorder.ErrInvalidCustomOrder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := columnorder.Parse(tt.mode, tt.custom)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse(%q, %v) error = %v, want %v", tt.mode, tt.custom, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOrder_Permutation(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		custom   []string
+		fileName string
+		header   []string
+		want     []string
+	}{
+		{
+			name:     "input",
+			mode:     columnorder.Input,
+			fileName: "stops.txt",
+			header:   []string{"stop_name", "stop_id"},
+			want:     []string{"stop_name", "stop_id"},
+		},
+		{
+			name:     "canonical",
+			mode:     columnorder.Canonical,
+			fileName: "stops.txt",
+			header:   []string{"stop_lon", "stop_lat", "stop_name", "stop_id"},
+			want:     []string{"stop_id", "stop_name", "stop_lat", "stop_lon"},
+		},
+		{
+			name:     "canonical with unknown fields last",
+			mode:     columnorder.Canonical,
+			fileName: "trips.txt",
+			header:   []string{"lpp_direction", "trip_id", "route_id", "lpp_note", "service_id"},
+			want:     []string{"route_id", "service_id", "trip_id", "lpp_direction", "lpp_note"},
+		},
+		{
+			name:     "canonical unknown file",
+			mode:     columnorder.Canonical,
+			fileName: "lpp_extra.txt",
+			header:   []string{"b", "a"},
+			want:     []string{"b", "a"},
+		},
+		{
+			name:     "custom",
+			mode:     columnorder.Custom,
+			custom:   []string{"stops.txt,stop_name,stop_code", "stops.txt,stop_id"},
+			fileName: "stops.txt",
+			header:   []string{"stop_id", "stop_lat", "stop_name", "stop_lon"},
+			want:     []string{"stop_name", "stop_id", "stop_lat", "stop_lon"},
+		},
+		{
+			name:     "custom other file",
+			mode:     columnorder.Custom,
+			custom:   []string{"stops.txt,stop_name"},
+			fileName: "routes.txt",
+			header:   []string{"route_type", "route_id"},
+			want:     []string{"route_type", "route_id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := columnorder.Parse(tt.mode, tt.custom)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			got := make([]string, len(tt.header))
+			columnorder.Reorder(got, tt.header, order.Permutation(tt.fileName, tt.header))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("reordered header = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+// Package columnorder decides the order of the columns of output files. Columns can keep the order
+// of the input, follow the order of the fields in the GTFS reference, or follow custom lists per file.
+package columnorder
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidConfig  = errors.New("invalid config")
+	ErrUnknownProfile = errors.New("unknown profile")
+)
+
+const profilesKey = "profiles"
+
+// section holds the flag values of a single command
+type section struct {
+	// flag name -> value, in the order of the file
+	keys   []*yaml.Node
+	values []*yaml.Node
+}
+
+// Config is a loaded profile file.
+type Config struct {
+	path     string
+	defaults map[string]*section
+	profiles map[string]map[string]*section
+}
+
+// Load reads and checks the structure of the profile file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", path, ErrInvalidConfig, err)
+	}
+	c := &Config{
+		path:     path,
+		defaults: map[string]*section{},
+		profiles: map[string]map[string]*section{},
+	}
+	if len(doc.Content) == 0 {
+		// empty file
+		return c, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, c.errorf(root, "must be a mapping of commands and profiles")
+	}
+	for key, value := range pairs(root) {
+		if key.Value != profilesKey {
+			s, err := c.parseSection(value)
+			if err != nil {
+				return nil, err
+			}
+			c.defaults[key.Value] = s
+			continue
+		}
+		if value.Kind != yaml.MappingNode {
+			return nil, c.errorf(value, "profiles must be a mapping of profile names")
+		}
+		for name, profile := range pairs(value) {
+			if profile.Kind != yaml.MappingNode {
+				return nil, c.errorf(profile, "profile %s must be a mapping of commands", name.Value)
+			}
+			sections := map[string]*section{}
+			for command, value := range pairs(profile) {
+				s, err := c.parseSection(value)
+				if err != nil {
+					return nil, err
+				}
+				sections[command.Value] = s
+			}
+			c.profiles[name.Value] = sections
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) parseSection(node *yaml.Node) (*section, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, c.errorf(node, "command section must be a mapping of flag names")
+	}
+	s := &section{}
+	for key, value := range pairs(node) {
+		switch value.Kind {
+		case yaml.ScalarNode:
+		case yaml.SequenceNode:
+			if i := slices.IndexFunc(value.Content, isNotScalar); i >= 0 {
+				return nil, c.errorf(value.Content[i], "elements of %s must be values", key.Value)
+			}
+		case yaml.MappingNode:
+			for file, v := range pairs(value) {
+				if v.Kind == yaml.SequenceNode {
+					if i := slices.IndexFunc(v.Content, isNotScalar); i >= 0 {
+						return nil, c.errorf(v.Content[i], "elements of %s of %s must be values", key.Value, file.Value)
+					}
+				} else if v.Kind != yaml.ScalarNode {
+					return nil, c.errorf(v, "%s of %s must be a value or a list of values", key.Value, file.Value)
+				}
+			}
+		default:
+			return nil, c.errorf(value, "%s must be a value, a list or a mapping", key.Value)
+		}
+		s.keys = append(s.keys, key)
+		s.values = append(s.values, value)
+	}
+	return s, nil
+}
+
+// Profiles returns the names of the profiles in the file, sorted.
+func (c *Config) Profiles() []string {
+	names := make([]string, 0, len(c.profiles))
+	for name := range c.profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Apply sets the flags of command that are not set on the command line from the top level section of the command
+// and the section of the profile, if profile is not empty. Sections are named after the full command path without
+// the program name, like extract or export sqlite, so a subcommand doesn't read the section of another command.
+func (c *Config) Apply(profile, command string, flags *pflag.FlagSet) error {
+	sections := []*section{c.defaults[command]}
+	if profile != "" {
+		p, ok := c.profiles[profile]
+		if !ok {
+			return fmt.Errorf("%s: %w %q; profiles in the file: %s",
+				c.path, ErrUnknownProfile, profile, strings.Join(c.Profiles(), ", "))
+		}
+		sections = append(sections, p[command])
+	}
+
+	// The profile overrides the top level section flag by flag
+	var keys []*yaml.Node
+	values := map[string]*yaml.Node{}
+	for _, s := range sections {
+		if s == nil {
+			continue
+		}
+		for i, key := range s.keys {
+			if flags.Lookup(key.Value) == nil {
+				return c.errorf(key, "unknown flag %s of command %s", key.Value, command)
+			}
+			if _, ok := values[key.Value]; !ok {
+				keys = append(keys, key)
+			}
+			values[key.Value] = s.values[i]
+		}
+	}
+
+	for _, key := range keys {
+		flag := flags.Lookup(key.Value)
+		if flag.Changed {
+			continue
+		}
+		if err := c.set(flag, values[key.Value]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set sets the flag from a scalar, a list or a mapping of file names to values.
+func (c *Config) set(flag *pflag.Flag, node *yaml.Node) error {
+	var values []*yaml.Node
+	var prefixes []string
+	switch node.Kind {
+	case yaml.ScalarNode:
+		values, prefixes = []*yaml.Node{node}, []string{""}
+	case yaml.SequenceNode:
+		for _, v := range node.Content {
+			values, prefixes = append(values, v), append(prefixes, "")
+		}
+	case yaml.MappingNode:
+		for file, v := range pairs(node) {
+			elements := []*yaml.Node{v}
+			if v.Kind == yaml.SequenceNode {
+				elements = v.Content
+			}
+			for _, e := range elements {
+				values, prefixes = append(values, e), append(prefixes, file.Value+",")
+			}
+		}
+	}
+	if len(values) > 1 || node.Kind == yaml.MappingNode {
+		if t := flag.Value.Type(); !strings.HasSuffix(t, "Slice") && !strings.HasSuffix(t, "Array") {
+			return c.errorf(node, "flag %s takes a single value", flag.Name)
+		}
+	}
+	for i, v := range values {
+		if err := flag.Value.Set(prefixes[i] + v.Value); err != nil {
+			return c.errorf(v, "invalid value %q of flag %s: %v", v.Value, flag.Name, err)
+		}
+	}
+	flag.Changed = true
+	return nil
+}
+
+func (c *Config) errorf(node *yaml.Node, format string, a ...any) error {
+	return fmt.Errorf("%s:%d:%d: %w: %s", c.path, node.Line, node.Column, ErrInvalidConfig, fmt.Sprintf(format, a...))
+}
+
+func isNotScalar(node *yaml.Node) bool {
+	return node.Kind != yaml.ScalarNode
+}
+
+// pairs iterates over the keys and values of a mapping node.
+func pairs(node *yaml.Node) func(yield func(key, value *yaml.Node) bool) {
+	return func(yield func(key, value *yaml.Node) bool) {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if !yield(node.Content[i], node.Content[i+1]) {
+				return
+			}
+		}
+	}
+}
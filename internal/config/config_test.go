@@ -0,0 +1,168 @@
+package config_test
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/config"
+	"github.com/spf13/pflag"
+)
+
+const testConfig = `extract:
+  exclude-empty-fields: true
+  jobs: 2
+profiles:
+  lpp:
+    extract:
+      jobs: 4
+      exclude-fields:
+        stops.txt: [stop_desc, stop_code]
+        routes.txt: route_color
+      where:
+        - routes.txt,route_type=3
+`
+
+type testFlags struct {
+	set                *pflag.FlagSet
+	excludeEmptyFields bool
+	jobs               int
+	excludeFields      []string
+	where              []string
+}
+
+func newTestFlags() *testFlags {
+	f := &testFlags{set: pflag.NewFlagSet("extract", pflag.ContinueOnError)}
+	f.set.BoolVar(&f.excludeEmptyFields, "exclude-empty-fields", false, "")
+	f.set.IntVar(&f.jobs, "jobs", 1, "")
+	f.set.StringArrayVar(&f.excludeFields, "exclude-fields", []string{}, "")
+	f.set.StringArrayVar(&f.where, "where", []string{}, "")
+	return f
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name              string
+		config            string
+		profile           string
+		command           string
+		args              []string
+		wantEmptyFields   bool
+		wantJobs          int
+		wantExcludeFields []string
+		wantWhere         []string
+	}{
+		{
+			name:            "top level only",
+			config:          testConfig,
+			wantEmptyFields: true,
+			wantJobs:        2,
+		},
+		{
+			name:              "profile overrides top level",
+			config:            testConfig,
+			profile:           "lpp",
+			wantEmptyFields:   true,
+			wantJobs:          4,
+			wantExcludeFields: []string{"stops.txt,stop_desc", "stops.txt,stop_code", "routes.txt,route_color"},
+			wantWhere:         []string{"routes.txt,route_type=3"},
+		},
+		{
+			name:              "command line overrides profile",
+			config:            testConfig,
+			profile:           "lpp",
+			args:              []string{"--jobs", "8", "--where", "routes.txt,route_id=6"},
+			wantEmptyFields:   true,
+			wantJobs:          8,
+			wantExcludeFields: []string{"stops.txt,stop_desc", "stops.txt,stop_code", "routes.txt,route_color"},
+			wantWhere:         []string{"routes.txt,route_id=6"},
+		},
+		{
+			name:            "json",
+			config:          `{"extract": {"exclude-empty-fields": true, "where": {"stops.txt": ["wheelchair_boarding=1"]}}}`,
+			wantEmptyFields: true,
+			wantJobs:        1,
+			wantWhere:       []string{"stops.txt,wheelchair_boarding=1"},
+		},
+		{
+			name:      "subcommand",
+			config:    "sqlite:\n  jobs: 3\nexport sqlite:\n  jobs: 5\nprofiles:\n  lpp:\n    export sqlite:\n      where: routes.txt,route_id=6\n",
+			profile:   "lpp",
+			command:   "export sqlite",
+			wantJobs:  5,
+			wantWhere: []string{"routes.txt,route_id=6"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.Load(writeConfig(t, "profile.yaml", tt.config))
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+			flags := newTestFlags()
+			if err := flags.set.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			command := cmp.Or(tt.command, "extract")
+			if err := cfg.Apply(tt.profile, command, flags.set); err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if flags.excludeEmptyFields != tt.wantEmptyFields {
+				t.Errorf("exclude-empty-fields = %v, want %v", flags.excludeEmptyFields, tt.wantEmptyFields)
+			}
+			if flags.jobs != tt.wantJobs {
+				t.Errorf("jobs = %v, want %v", flags.jobs, tt.wantJobs)
+			}
+			if !slices.Equal(flags.excludeFields, tt.wantExcludeFields) && len(flags.excludeFields)+len(tt.wantExcludeFields) > 0 {
+				t.Errorf("exclude-fields = %v, want %v", flags.excludeFields, tt.wantExcludeFields)
+			}
+			if !slices.Equal(flags.where, tt.wantWhere) && len(flags.where)+len(tt.wantWhere) > 0 {
+				t.Errorf("where = %v, want %v", flags.where, tt.wantWhere)
+			}
+		})
+	}
+}
+
+func TestApply_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		profile  string
+		wantErr  error
+		wantLine string
+	}{
+		{name: "unknown flag", config: "extract:\n  jbos: 2\n", wantErr: config.ErrInvalidConfig, wantLine: ":2:3:"},
+		{name: "invalid value", config: "extract:\n  jobs: many\n", wantErr: config.ErrInvalidConfig, wantLine: ":2:9:"},
+		{name: "list for single value flag", config: "extract:\n  jobs: [1, 2]\n", wantErr: config.ErrInvalidConfig, wantLine: ":2:9:"},
+		{name: "nested list", config: "extract:\n  where:\n    - [a, b]\n", wantErr: config.ErrInvalidConfig, wantLine: ":3:7:"},
+		{name: "unknown profile", config: "profiles:\n  lpp: {}\n", profile: "arriva", wantErr: config.ErrUnknownProfile},
+		{name: "section is not a mapping", config: "extract: true\n", wantErr: config.ErrInvalidConfig, wantLine: ":1:10:"},
+		{name: "not yaml", config: "extract: [\n", wantErr: config.ErrInvalidConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.Load(writeConfig(t, "profile.yaml", tt.config))
+			if err == nil {
+				err = cfg.Apply(tt.profile, "extract", newTestFlags().set)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantLine) {
+				t.Errorf("error = %v, want position %s", err, tt.wantLine)
+			}
+		})
+	}
+}
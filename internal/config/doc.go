@@ -0,0 +1,19 @@
+// Package config loads profile files, which hold flag values of the commands, so long invocations can be
+// written down once. A profile file is YAML (or JSON) with a section per command, keyed by flag names:
+//
+//	extract:
+//	  exclude-empty-fields: true
+//	merge:
+//	  force: true
+//	profiles:
+//	  lpp:
+//	    extract:
+//	      exclude-fields:
+//	        stops.txt: [stop_desc, stop_code]
+//	      where:
+//	        routes.txt: route_type=3
+//
+// Sections at the top level apply to every profile, and the sections of the selected profile override them
+// flag by flag. Flags given on the command line override both. List values are set one element at a time,
+// like a repeated flag, and a mapping from file names is the same as a list of "filename,value" elements.
+package config
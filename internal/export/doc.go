@@ -0,0 +1,3 @@
+// Package export writes GTFS feeds to formats other than GTFS, for analysis with other tools.
+// Every format is a gtfsio.Sink, so anything that writes a feed, like the extractor, can write to it.
+package export
@@ -0,0 +1,213 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/schema"
+	"github.com/parquet-go/parquet-go"
+)
+
+// ParquetRowGroupSize is the number of rows of a row group. Only a single row group of a file
+// is held in memory while it is written.
+const ParquetRowGroupSize = 128 * 1024
+
+// Reject is a row with a value that doesn't match the type of its column, which is written as null instead.
+type Reject struct {
+	File string
+	// Line of the written file the row is on, counting from 1 for the header
+	Line int
+	// The row as written to the sink, with fields joined by commas
+	Content string
+	Reason  string
+}
+
+type parquetSink struct {
+	dir    string
+	strict bool
+
+	// guarded by rejectsMu, since files can be written concurrently
+	rejects   []Reject
+	rejectsMu sync.Mutex
+}
+
+// CreateParquetSink creates the directory at path, if it doesn't exist yet. Every file written to the sink
+// is written to a Parquet file in the directory, named after the file with the .parquet extension.
+// A value that doesn't match the type of its column is an error if strict, otherwise it is written as null
+// and its row is returned by the Rejects method of the sink.
+func CreateParquetSink(path string, strict bool) (gtfsio.Sink, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return &parquetSink{dir: path, strict: strict}, nil
+}
+
+// Rejects returns the rows with values written as null, in the order they were written.
+func (s *parquetSink) Rejects() []Reject {
+	s.rejectsMu.Lock()
+	defer s.rejectsMu.Unlock()
+	return slices.Clone(s.rejects)
+}
+
+func (s *parquetSink) reject(r Reject) {
+	s.rejectsMu.Lock()
+	defer s.rejectsMu.Unlock()
+	s.rejects = append(s.rejects, r)
+}
+
+func (s *parquetSink) Create(name string) (io.WriteCloser, error) {
+	r, w := io.Pipe()
+	t := &pipedTable{w: w, done: make(chan error, 1)}
+	go func() {
+		err := s.write(name, r)
+		// Unblocks the writer if writing stopped early
+		r.CloseWithError(err)
+		t.done <- err
+	}()
+	return t, nil
+}
+
+func (s *parquetSink) Close() error {
+	return nil
+}
+
+// write converts the CSV read from r to a Parquet file. An empty file doesn't create a Parquet file,
+// since it needs at least one column.
+func (s *parquetSink) write(name string, r io.Reader) error {
+	csvReader := csv.NewReader(r)
+	csvReader.ReuseRecord = true
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading header of file %s: %w", name, err)
+	}
+	header = slices.Clone(header)
+
+	file, ok := schema.Lookup(name)
+	if !ok {
+		file = &schema.File{Name: name}
+	}
+	types := make([]schema.Type, len(header))
+	group := parquet.Group{}
+	for i, column := range header {
+		field, _ := file.Field(column)
+		types[i] = field.Type
+		if _, ok := group[column]; ok {
+			return fmt.Errorf("duplicate field %s in file %s", column, name)
+		}
+		group[column] = parquet.Optional(parquetNode(field.Type))
+	}
+	table := strings.TrimSuffix(name, ".txt")
+	parquetSchema := parquet.NewSchema(table, group)
+	// Group columns are sorted by name, so values are placed by column index instead of header order
+	columnIndex := make([]int, len(header))
+	for i, column := range header {
+		leaf, _ := parquetSchema.Lookup(column)
+		columnIndex[i] = leaf.ColumnIndex
+	}
+
+	out, err := os.Create(filepath.Join(s.dir, table+".parquet"))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	writer := parquet.NewWriter(out,
+		parquetSchema,
+		parquet.Compression(&parquet.Snappy),
+		parquet.MaxRowsPerRowGroup(ParquetRowGroupSize),
+	)
+
+	row := make(parquet.Row, len(header))
+	for n := 1; ; n++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading row %d of file %s: %w", n, name, err)
+		}
+		for i, value := range record {
+			v, err := parquetValue(types[i], value)
+			if err != nil && s.strict {
+				return fmt.Errorf("error converting field %s in row %d of file %s: %w", header[i], n, name, err)
+			}
+			if err != nil {
+				v = parquet.NullValue()
+				s.reject(Reject{
+					File:    name,
+					Line:    n + 1,
+					Content: strings.Join(record, ","),
+					Reason:  fmt.Sprintf("invalid %s %q in field %s, written as null", types[i], value, header[i]),
+				})
+			}
+			definitionLevel := 1
+			if v.IsNull() {
+				definitionLevel = 0
+			}
+			row[columnIndex[i]] = v.Level(0, definitionLevel, columnIndex[i])
+		}
+		if _, err := writer.WriteRows([]parquet.Row{row}); err != nil {
+			return fmt.Errorf("error writing row %d of file %s: %w", n, name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error writing file %s: %w", name, err)
+	}
+	return out.Close()
+}
+
+// parquetNode returns the Parquet column type of a field type. Strings are dictionary encoded, since IDs
+// like trip_id in stop_times.txt repeat a lot. Times stay strings, since GTFS times can be past midnight.
+func parquetNode(t schema.Type) parquet.Node {
+	switch t {
+	case schema.Integer:
+		return parquet.Int(64)
+	case schema.Float:
+		return parquet.Leaf(parquet.DoubleType)
+	case schema.Date:
+		return parquet.Date()
+	default:
+		return parquet.Encoded(parquet.String(), &parquet.RLEDictionary)
+	}
+}
+
+// parquetValue converts a CSV value to a Parquet value of the column type. Empty values are null.
+func parquetValue(t schema.Type, value string) (parquet.Value, error) {
+	if value == "" {
+		return parquet.NullValue(), nil
+	}
+	switch t {
+	case schema.Integer:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.Int64Value(v), nil
+	case schema.Float:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.DoubleValue(v), nil
+	case schema.Date:
+		d, err := time.Parse("20060102", value)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		// Days since the Unix epoch
+		return parquet.Int32Value(int32(d.Unix() / (24 * 60 * 60))), nil
+	default:
+		return parquet.ByteArrayValue([]byte(value)), nil
+	}
+}
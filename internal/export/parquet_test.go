@@ -0,0 +1,151 @@
+package export_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/export"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+func TestParquetSink(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := export.CreateParquetSink(dir, false)
+	if err != nil {
+		t.Fatalf("CreateParquetSink() failed: %v", err)
+	}
+	writeFiles(t, sink, [][2]string{
+		{"stop_times.txt", "trip_id,arrival_time,stop_id,stop_sequence,shape_dist_traveled\nT1,25:10:00,P1,1,\nT1,25:12:00,P2,2,120.5\n"},
+		{"calendar.txt", "service_id,monday,start_date,end_date\nWD,1,19700102,20250101\n"},
+		{"levels.txt", ""},
+		{"custom.txt", "id,value\n1,x\n"},
+	})
+	if rejects := sink.(interface{ Rejects() []export.Reject }).Rejects(); len(rejects) > 0 {
+		t.Errorf("Rejects() = %v, want none", rejects)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"calendar.parquet", "custom.parquet", "stop_times.parquet"}; !slices.Equal(names, want) {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+
+	tests := []struct {
+		file   string
+		column string
+		kind   parquet.Kind
+		want   []any
+	}{
+		{"stop_times.parquet", "trip_id", parquet.ByteArray, []any{"T1", "T1"}},
+		{"stop_times.parquet", "arrival_time", parquet.ByteArray, []any{"25:10:00", "25:12:00"}},
+		{"stop_times.parquet", "stop_sequence", parquet.Int64, []any{int64(1), int64(2)}},
+		{"stop_times.parquet", "shape_dist_traveled", parquet.Double, []any{nil, 120.5}},
+		{"calendar.parquet", "start_date", parquet.Int32, []any{int32(1)}},
+		{"custom.parquet", "id", parquet.ByteArray, []any{"1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file+" "+tt.column, func(t *testing.T) {
+			f, err := os.Open(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatalf("failed to open: %v", err)
+			}
+			defer f.Close()
+			info, _ := f.Stat()
+			pf, err := parquet.OpenFile(f, info.Size())
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			leaf, ok := pf.Schema().Lookup(tt.column)
+			if !ok {
+				t.Fatalf("column %s not found in %v", tt.column, pf.Schema())
+			}
+			if kind := leaf.Node.Type().Kind(); kind != tt.kind {
+				t.Errorf("type = %v, want %v", kind, tt.kind)
+			}
+			if tt.kind == parquet.ByteArray {
+				encodings := pf.Metadata().RowGroups[0].Columns[leaf.ColumnIndex].MetaData.Encoding
+				if !slices.Contains(encodings, format.RLEDictionary) {
+					t.Errorf("encodings = %v, want dictionary encoding", encodings)
+				}
+			}
+
+			var got []any
+			rows := make([]parquet.Row, 10)
+			reader := parquet.NewReader(pf)
+			defer reader.Close()
+			n, _ := reader.ReadRows(rows)
+			for _, row := range rows[:n] {
+				v := row[leaf.ColumnIndex]
+				switch {
+				case v.IsNull():
+					got = append(got, nil)
+				case tt.kind == parquet.ByteArray:
+					got = append(got, v.String())
+				case tt.kind == parquet.Int64:
+					got = append(got, v.Int64())
+				case tt.kind == parquet.Int32:
+					got = append(got, v.Int32())
+				default:
+					got = append(got, v.Double())
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParquetSink_InvalidValue(t *testing.T) {
+	input := [][2]string{{"stop_times.txt", "trip_id,stop_sequence\nT1,1\nT1,2.0\n"}}
+
+	dir := t.TempDir()
+	sink, err := export.CreateParquetSink(dir, false)
+	if err != nil {
+		t.Fatalf("CreateParquetSink() failed: %v", err)
+	}
+	writeFiles(t, sink, input)
+	want := []export.Reject{{
+		File:    "stop_times.txt",
+		Line:    3,
+		Content: "T1,2.0",
+		Reason:  `invalid integer "2.0" in field stop_sequence, written as null`,
+	}}
+	if got := sink.(interface{ Rejects() []export.Reject }).Rejects(); !slices.Equal(got, want) {
+		t.Errorf("Rejects() = %v, want %v", got, want)
+	}
+	rows, err := parquet.ReadFile[struct {
+		StopSequence *int64 `parquet:"stop_sequence"`
+	}](filepath.Join(dir, "stop_times.parquet"))
+	if err != nil {
+		t.Fatalf("failed to read stop_times.parquet: %v", err)
+	}
+	if len(rows) != 2 || rows[0].StopSequence == nil || *rows[0].StopSequence != 1 || rows[1].StopSequence != nil {
+		t.Errorf("stop_sequence = %v, want 1 and null", rows)
+	}
+
+	strictSink, err := export.CreateParquetSink(t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("CreateParquetSink() failed: %v", err)
+	}
+	defer strictSink.Close()
+	w, err := strictSink.Create(input[0][0])
+	if err != nil {
+		t.Fatalf("failed to create %s: %v", input[0][0], err)
+	}
+	_, writeErr := io.WriteString(w, input[0][1])
+	if err := errors.Join(writeErr, w.Close()); err == nil {
+		t.Errorf("writing invalid stop_sequence with strict succeeded, want an error")
+	}
+}
@@ -0,0 +1,19 @@
+package export
+
+import "io"
+
+// pipedTable is a file written to a sink. The CSV written to it is converted concurrently,
+// closing it waits until the conversion is done and returns its error.
+type pipedTable struct {
+	w    *io.PipeWriter
+	done chan error
+}
+
+func (t *pipedTable) Write(p []byte) (int, error) {
+	return t.w.Write(p)
+}
+
+func (t *pipedTable) Close() error {
+	t.w.Close()
+	return <-t.done
+}
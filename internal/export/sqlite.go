@@ -0,0 +1,215 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/schema"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ErrDuplicateKey is returned when rows of a file have the same primary key, which the table declares.
+var ErrDuplicateKey = errors.New("duplicate primary key, use dedupe to keep one row per key")
+
+type sqliteSink struct {
+	db *sql.DB
+}
+
+// CreateSQLiteSink creates a SQLite database at path, replacing any existing file.
+// Every file written to the sink is loaded into a table named after the file without the extension.
+func CreateSQLiteSink(path string) (gtfsio.Sink, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection, so the pragmas apply to all statements
+	db.SetMaxOpenConns(1)
+	// The database is written once, a crash while writing leaves an incomplete export anyway
+	if _, err := db.Exec("PRAGMA journal_mode = OFF; PRAGMA synchronous = OFF"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteSink{db: db}, nil
+}
+
+func (s *sqliteSink) Create(name string) (io.WriteCloser, error) {
+	r, w := io.Pipe()
+	t := &pipedTable{w: w, done: make(chan error, 1)}
+	go func() {
+		err := s.load(name, r)
+		// Unblocks the writer if loading stopped early
+		r.CloseWithError(err)
+		t.done <- err
+	}()
+	return t, nil
+}
+
+func (s *sqliteSink) Close() error {
+	return s.db.Close()
+}
+
+// load creates the table for the file and inserts the rows of the CSV read from r.
+// An empty file doesn't create a table, since a table needs at least one column.
+func (s *sqliteSink) load(name string, r io.Reader) error {
+	csvReader := csv.NewReader(r)
+	csvReader.ReuseRecord = true
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading header of file %s: %w", name, err)
+	}
+	header = slices.Clone(header)
+
+	table := strings.TrimSuffix(name, ".txt")
+	file, ok := schema.Lookup(name)
+	if !ok {
+		file = &schema.File{Name: name}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(createTable(table, file, header)); err != nil {
+		return fmt.Errorf("error creating table %s: %w", table, err)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(header)), ",")
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", quote(table), placeholders))
+	if err != nil {
+		return fmt.Errorf("error preparing insert into table %s: %w", table, err)
+	}
+	defer insert.Close()
+
+	values := make([]any, len(header))
+	for row := 1; ; row++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading row %d of file %s: %w", row, name, err)
+		}
+		for i, value := range record {
+			// Empty values are missing values in GTFS
+			if value == "" {
+				values[i] = nil
+			} else {
+				values[i] = value
+			}
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			var sqliteErr *sqlite.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+				return fmt.Errorf("%w: %s on row %d of file %s", ErrDuplicateKey, keyOf(file, header, record), row, name)
+			}
+			return fmt.Errorf("error inserting row %d of file %s: %w", row, name, err)
+		}
+	}
+
+	// Indexes are created after the rows are inserted, which is faster than updating them for every row
+	for _, index := range createIndexes(table, file, header) {
+		if _, err := tx.Exec(index); err != nil {
+			return fmt.Errorf("error creating index on table %s: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// createTable returns the statement creating the table for a file with the given header.
+// Columns get types from the schema, and the primary key and foreign keys are declared
+// only if all of their columns are in the header.
+func createTable(table string, file *schema.File, header []string) string {
+	var defs []string
+	for _, column := range header {
+		field, _ := file.Field(column)
+		defs = append(defs, quote(column)+" "+sqliteType(field.Type))
+	}
+	if len(file.PrimaryKey) > 0 && containsAll(header, file.PrimaryKey) {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", quoteAll(file.PrimaryKey)))
+	}
+	for _, column := range header {
+		field, _ := file.Field(column)
+		if field.References == nil {
+			continue
+		}
+		defs = append(defs, fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)", quote(column),
+			quote(strings.TrimSuffix(field.References.File, ".txt")), quote(field.References.Field)))
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", quote(table), strings.Join(defs, ",\n\t"))
+}
+
+// createIndexes returns the statements creating an index on every ID column of the header,
+// except the first column of the primary key, which is already indexed by it.
+func createIndexes(table string, file *schema.File, header []string) []string {
+	var indexes []string
+	for _, column := range header {
+		field, ok := file.Field(column)
+		if !ok || field.Type != schema.ID {
+			continue
+		}
+		if len(file.PrimaryKey) > 0 && file.PrimaryKey[0] == column && containsAll(header, file.PrimaryKey) {
+			continue
+		}
+		indexes = append(indexes, fmt.Sprintf("CREATE INDEX %s ON %s (%s)",
+			quote(table+"_"+column), quote(table), quote(column)))
+	}
+	return indexes
+}
+
+// keyOf describes the primary key of a record, like trip_id=T1, stop_sequence=1.
+func keyOf(file *schema.File, header, record []string) string {
+	key := make([]string, len(file.PrimaryKey))
+	for i, field := range file.PrimaryKey {
+		key[i] = field + "=" + record[slices.Index(header, field)]
+	}
+	return strings.Join(key, ", ")
+}
+
+func sqliteType(t schema.Type) string {
+	switch t {
+	case schema.Integer:
+		return "INTEGER"
+	case schema.Float:
+		return "REAL"
+	default:
+		// Dates and times too, GTFS times can be past midnight and don't fit SQLite date functions anyway
+		return "TEXT"
+	}
+}
+
+func containsAll(header, fields []string) bool {
+	for _, field := range fields {
+		if !slices.Contains(header, field) {
+			return false
+		}
+	}
+	return true
+}
+
+func quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func quoteAll(identifiers []string) string {
+	quoted := make([]string, len(identifiers))
+	for i, identifier := range identifiers {
+		quoted[i] = quote(identifier)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,106 @@
+package export_test
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/export"
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
+)
+
+func writeFiles(t *testing.T, sink gtfsio.Sink, files [][2]string) {
+	for _, file := range files {
+		w, err := sink.Create(file[0])
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", file[0], err)
+		}
+		if _, err := io.WriteString(w, file[1]); err != nil {
+			t.Fatalf("failed to write %s: %v", file[0], err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to close %s: %v", file[0], err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+}
+
+func TestSQLiteSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feed.db")
+	sink, err := export.CreateSQLiteSink(path)
+	if err != nil {
+		t.Fatalf("CreateSQLiteSink() failed: %v", err)
+	}
+	writeFiles(t, sink, [][2]string{
+		{"stops.txt", "stop_id,stop_name,stop_lat,location_type,parent_station\nST1,Center,46.05,1,\nP1,\"Center, platform 1\",46.0501,0,ST1\n"},
+		{"stop_times.txt", "trip_id,stop_id,stop_sequence,arrival_time\nT1,P1,1,25:10:00\n"},
+		{"levels.txt", ""},
+		{"custom.txt", "id,value\n1,x\n"},
+	})
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	queries := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT stop_name FROM stops ORDER BY stop_id", []string{"Center, platform 1", "Center"}},
+		{"SELECT typeof(stop_lat) || ',' || typeof(location_type) FROM stops", []string{"real,integer", "real,integer"}},
+		{"SELECT coalesce(parent_station, 'NULL') FROM stops ORDER BY stop_id", []string{"ST1", "NULL"}},
+		{"SELECT typeof(stop_sequence) || ',' || arrival_time FROM stop_times", []string{"integer,25:10:00"}},
+		{"SELECT value FROM custom WHERE id = '1'", []string{"x"}},
+		{"SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name", []string{"custom", "stop_times", "stops"}},
+		{"SELECT name FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL ORDER BY name", []string{"stop_times_stop_id", "stops_parent_station"}},
+		{"SELECT \"table\" || '.' || \"to\" FROM pragma_foreign_key_list('stop_times') ORDER BY \"from\"", []string{"stops.stop_id", "trips.trip_id"}},
+		{"SELECT name FROM pragma_table_info('stop_times') WHERE pk > 0 ORDER BY pk", []string{"trip_id", "stop_sequence"}},
+	}
+	for _, q := range queries {
+		rows, err := db.Query(q.query)
+		if err != nil {
+			t.Errorf("%s failed: %v", q.query, err)
+			continue
+		}
+		var got []string
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				t.Errorf("%s failed: %v", q.query, err)
+			}
+			got = append(got, v)
+		}
+		rows.Close()
+		if !slices.Equal(got, q.want) {
+			t.Errorf("%s = %v, want %v", q.query, got, q.want)
+		}
+	}
+}
+
+func TestSQLiteSink_DuplicateKey(t *testing.T) {
+	sink, err := export.CreateSQLiteSink(filepath.Join(t.TempDir(), "feed.db"))
+	if err != nil {
+		t.Fatalf("CreateSQLiteSink() failed: %v", err)
+	}
+	defer sink.Close()
+	w, err := sink.Create("stops.txt")
+	if err != nil {
+		t.Fatalf("failed to create stops.txt: %v", err)
+	}
+	_, writeErr := io.WriteString(w, "stop_id,stop_name\nP1,A\nP1,B\n")
+	err = errors.Join(writeErr, w.Close())
+	if !errors.Is(err, export.ErrDuplicateKey) {
+		t.Fatalf("writing duplicate stop_id error = %v, want %v", err, export.ErrDuplicateKey)
+	}
+	if want := "stop_id=P1 on row 2 of file stops.txt"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %q, want it to contain %q", err, want)
+	}
+}
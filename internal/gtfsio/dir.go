@@ -0,0 +1,75 @@
+package gtfsio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type dirFile struct {
+	dir  string
+	name string
+}
+
+func (f dirFile) Name() string {
+	return f.name
+}
+
+func (f dirFile) Open() (io.ReadCloser, error) {
+	return os.Open(filepath.Join(f.dir, f.name))
+}
+
+type dirSource struct {
+	dir   string
+	names []string
+}
+
+// OpenDirSource opens the directory at path. The .txt files directly in the directory are the files of the feed,
+// everything else is ignored.
+func OpenDirSource(path string) (Source, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &dirSource{dir: path}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".txt") {
+			s.names = append(s.names, entry.Name())
+		}
+	}
+	return s, nil
+}
+
+func (s *dirSource) Files() []File {
+	files := make([]File, len(s.names))
+	for i, name := range s.names {
+		files[i] = dirFile{dir: s.dir, name: name}
+	}
+	return files
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
+
+type dirSink struct {
+	dir string
+}
+
+// CreateDirSink creates the directory at path, if it doesn't exist yet. Files already in it are
+// only replaced when a file with the same name is written.
+func CreateDirSink(path string) (Sink, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return &dirSink{dir: path}, nil
+}
+
+func (s *dirSink) Create(name string) (io.WriteCloser, error) {
+	return os.Create(filepath.Join(s.dir, filepath.Base(name)))
+}
+
+func (s *dirSink) Close() error {
+	return nil
+}
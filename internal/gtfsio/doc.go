@@ -0,0 +1,3 @@
+// Package gtfsio reads and writes GTFS feeds stored either as a .zip archive or as a directory of .txt files.
+// Commands open feeds with OpenSource and CreateSink, which detect the kind of feed from the path.
+package gtfsio
@@ -0,0 +1,155 @@
+package gtfsio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// AutoEncoding is the name of the encoding that detects the encoding of every file.
+const AutoEncoding = "auto"
+
+var ErrUnknownEncoding = errors.New("unknown encoding")
+
+// ErrInvalidUTF8 is returned when a file detected as UTF-8 from its first bytes has invalid UTF-8 further on,
+// which would otherwise be replaced silently.
+var ErrInvalidUTF8 = errors.New("invalid UTF-8 after the start of the file used to detect its encoding, give the encoding explicitly")
+
+// sniffSize is how many bytes of a file are looked at to detect its encoding
+const sniffSize = 64 * 1024
+
+// LookupEncoding returns the encoding with the given name, like utf-8, utf-16, windows-1250 or iso-8859-2.
+// AutoEncoding and the empty name return nil, which makes DecodeSource detect the encoding.
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(name) {
+	case "", AutoEncoding:
+		return nil, nil
+	case "utf-8", "utf8":
+		// Also strips the BOM
+		return unicode.UTF8BOM, nil
+	case "utf-16", "utf16":
+		// Little endian unless the BOM says otherwise, like Windows writes it
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownEncoding, name)
+	}
+	return enc, nil
+}
+
+// DecodeSource returns a Source whose files are decoded from enc to UTF-8, without a byte order mark.
+// If enc is nil, the encoding of every file is detected with DetectEncoding.
+func DecodeSource(source Source, enc encoding.Encoding) Source {
+	return &decodedSource{Source: source, enc: enc}
+}
+
+type decodedSource struct {
+	Source
+	enc encoding.Encoding
+}
+
+func (s *decodedSource) Files() []File {
+	files := s.Source.Files()
+	for i, f := range files {
+		files[i] = decodedFile{File: f, enc: s.enc}
+	}
+	return files
+}
+
+type decodedFile struct {
+	File
+	enc encoding.Encoding
+}
+
+func (f decodedFile) Open() (io.ReadCloser, error) {
+	rc, err := f.File.Open()
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(rc, sniffSize)
+	enc := f.enc
+	var decoder transform.Transformer
+	if enc == nil {
+		// Peek returns an error if the file is shorter, but still all of it
+		head, _ := br.Peek(sniffSize)
+		enc = DetectEncoding(head)
+		decoder = enc.NewDecoder()
+		if enc == unicode.UTF8BOM {
+			// Only the head is known to be valid, the decoder would replace invalid UTF-8 after it
+			decoder = transform.Chain(encoding.UTF8Validator, decoder)
+		}
+	} else {
+		decoder = enc.NewDecoder()
+	}
+	return decodedReader{Reader: transform.NewReader(br, decoder), Closer: rc, name: f.Name()}, nil
+}
+
+type decodedReader struct {
+	io.Reader
+	io.Closer
+	name string
+}
+
+func (r decodedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if errors.Is(err, encoding.ErrInvalidUTF8) {
+		err = fmt.Errorf("%w, in file %s", ErrInvalidUTF8, r.name)
+	}
+	return n, err
+}
+
+// DetectEncoding guesses the encoding of a file from its first bytes. A byte order mark decides between
+// UTF-8 and UTF-16. Without one, valid UTF-8 is UTF-8, and anything else is one of the single byte
+// encodings used for Slovenian: Windows-1250, unless only letters at ISO-8859-2 positions are used.
+func DetectEncoding(head []byte) encoding.Encoding {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return unicode.UTF8BOM
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	case validUTF8Prefix(head):
+		return unicode.UTF8BOM
+	}
+
+	// Š, Ž, š and ž are at 0x8A, 0x8E, 0x9A and 0x9E in Windows-1250, where ISO-8859-2 has control
+	// characters, and at 0xA9, 0xAE, 0xB9 and 0xBE in ISO-8859-2, where Windows-1250 has ©, ®, ą and ľ.
+	windows, iso := 0, 0
+	for _, b := range head {
+		switch b {
+		case 0x8A, 0x8E, 0x9A, 0x9E:
+			windows++
+		case 0xA9, 0xAE, 0xB9, 0xBE:
+			iso++
+		}
+	}
+	if iso > 0 && windows == 0 {
+		return charmap.ISO8859_2
+	}
+	return charmap.Windows1250
+}
+
+// validUTF8Prefix reports whether head is valid UTF-8, except maybe for a rune cut off at its end.
+func validUTF8Prefix(head []byte) bool {
+	for i := len(head) - 1; i >= 0 && i >= len(head)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(head[i]) {
+			if !utf8.FullRune(head[i:]) {
+				head = head[:i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(head)
+}
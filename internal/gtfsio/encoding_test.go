@@ -0,0 +1,125 @@
+package gtfsio_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want encoding.Encoding
+	}{
+		{"utf-8 bom", []byte("\xEF\xBB\xBFagency_id"), unicode.UTF8BOM},
+		{"utf-16le bom", []byte("\xFF\xFEa\x00"), unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)},
+		{"utf-16be bom", []byte("\xFE\xFF\x00a"), unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)},
+		{"utf-8", []byte("stop_name\nŠiška"), unicode.UTF8BOM},
+		{"utf-8 cut off rune", []byte("stop_name\nŠ")[:11], unicode.UTF8BOM},
+		{"windows-1250", []byte("stop_name\n\x8Aiška\n\x9Eale"), charmap.Windows1250},
+		{"iso-8859-2", []byte("stop_name\n\xA9i\xB9ka"), charmap.ISO8859_2},
+		{"other latin-2 letters", []byte("stop_name\nKo\xE8evje"), charmap.Windows1250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gtfsio.DetectEncoding(tt.head); got != tt.want {
+				t.Errorf("DetectEncoding(%q) = %v, want %v", tt.head, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    encoding.Encoding
+		wantErr error
+	}{
+		{"", nil, nil},
+		{"auto", nil, nil},
+		{"UTF-8", unicode.UTF8BOM, nil},
+		{"windows-1250", charmap.Windows1250, nil},
+		{"cp1250", charmap.Windows1250, nil},
+		{"iso-8859-2", charmap.ISO8859_2, nil},
+		{"klingon", nil, gtfsio.ErrUnknownEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gtfsio.LookupEncoding(tt.name)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LookupEncoding(%q) error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("LookupEncoding(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		enc     string
+		want    string
+		wantErr error
+	}{
+		{"utf-8 bom", "\xEF\xBB\xBFagency_id\n1\n", "auto", "agency_id\n1\n", nil},
+		{"utf-8", "stop_name\nŠiška\n", "auto", "stop_name\nŠiška\n", nil},
+		{"windows-1250", "stop_name\nLjubljana \x8Ai\x9Aka\n", "auto", "stop_name\nLjubljana Šiška\n", nil},
+		{"utf-16le bom", "\xFF\xFEa\x00\n\x00`\x01\n\x00", "auto", "a\nŠ\n", nil},
+		{"override", "stop_name\n\xA9i\xB9ka\n", "iso-8859-2", "stop_name\nŠiška\n", nil},
+		{"utf-8 override strips bom", "\xEF\xBB\xBFagency_id\n", "utf-8", "agency_id\n", nil},
+		{"windows-1250 after sniffed utf-8", "stop_name\n" + strings.Repeat("Ljubljana\n", 7000) + "\x8Ai\x9Aka\n", "auto", "", gtfsio.ErrInvalidUTF8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir() + "/feed/"
+			sink, err := gtfsio.CreateSink(path)
+			if err != nil {
+				t.Fatalf("CreateSink(%s) failed: %v", path, err)
+			}
+			writeFeed(t, sink, map[string]string{"stops.txt": tt.content})
+
+			source, err := gtfsio.OpenSource(path)
+			if err != nil {
+				t.Fatalf("OpenSource(%s) failed: %v", path, err)
+			}
+			defer source.Close()
+			enc, err := gtfsio.LookupEncoding(tt.enc)
+			if err != nil {
+				t.Fatalf("LookupEncoding(%q) failed: %v", tt.enc, err)
+			}
+
+			files := gtfsio.DecodeSource(source, enc).Files()
+			if len(files) != 1 {
+				t.Fatalf("got %d files, want 1", len(files))
+			}
+			rc, err := files[0].Open()
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ReadAll error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadAll failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
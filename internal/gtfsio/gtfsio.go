@@ -0,0 +1,72 @@
+package gtfsio
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File is a single file of a feed, like stops.txt.
+type File interface {
+	Name() string
+	Open() (io.ReadCloser, error)
+}
+
+// Source is a feed that is read from.
+type Source interface {
+	// Files returns the files of the feed. The returned slice may be modified by the caller.
+	Files() []File
+	Close() error
+}
+
+// Sink is a feed that is written to. Only one file can be written at a time,
+// it must be closed before the next one is created.
+type Sink interface {
+	Create(name string) (io.WriteCloser, error)
+	Close() error
+}
+
+// Stdio is the path of the standard input or output. Feeds on them are always zip archives.
+const Stdio = "-"
+
+// OpenSource opens the feed at path, which is either a directory or a zip archive.
+// If path is Stdio, the zip archive is read from the standard input.
+func OpenSource(path string) (Source, error) {
+	if path == Stdio {
+		return SpoolSource(os.Stdin)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return OpenDirSource(path)
+	}
+	return OpenZipSource(path)
+}
+
+// CreateSink creates the feed at path. The feed is written to a directory if path is an existing directory
+// or ends with a path separator, and to a zip archive otherwise, even without a .zip extension.
+// If path is Stdio, the zip archive is streamed to the standard output.
+func CreateSink(path string) (Sink, error) {
+	if path == Stdio {
+		return NewZipSink(zip.NewWriter(os.Stdout)), nil
+	}
+	if IsDirPath(path) {
+		return CreateDirSink(path)
+	}
+	return CreateZipSink(path)
+}
+
+// IsDirPath reports whether CreateSink writes a directory for path.
+func IsDirPath(path string) bool {
+	if path == Stdio {
+		return false
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.IsDir()
+	}
+	return strings.HasSuffix(path, string(filepath.Separator)) || strings.HasSuffix(path, "/")
+}
@@ -0,0 +1,179 @@
+package gtfsio_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/gtfsio"
+)
+
+func writeFeed(t *testing.T, sink gtfsio.Sink, files map[string]string) {
+	for name, content := range files {
+		w, err := sink.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to close %s: %v", name, err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close sink: %v", err)
+	}
+}
+
+func readFeed(t *testing.T, path string) map[string]string {
+	source, err := gtfsio.OpenSource(path)
+	if err != nil {
+		t.Fatalf("OpenSource(%s) failed: %v", path, err)
+	}
+	defer source.Close()
+	files := map[string]string{}
+	for _, f := range source.Files() {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name(), err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", f.Name(), err)
+		}
+		files[f.Name()] = string(content)
+	}
+	return files
+}
+
+func TestRoundTrip(t *testing.T) {
+	files := map[string]string{
+		"agency.txt": "agency_id,agency_name\nA1,LPP\n",
+		"stops.txt":  "stop_id,stop_name\nP1,Konzorcij\n",
+	}
+	tests := []struct {
+		name    string
+		path    string
+		mkdir   bool
+		wantDir bool
+	}{
+		{name: "zip", path: "feed.zip", wantDir: false},
+		{name: "zip without extension", path: "feed", wantDir: false},
+		{name: "existing directory", path: "feed", mkdir: true, wantDir: true},
+		{name: "directory with trailing separator", path: "feed.gtfs" + string(filepath.Separator), wantDir: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir() + string(filepath.Separator) + tt.path
+			if tt.mkdir {
+				if err := os.Mkdir(path, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sink, err := gtfsio.CreateSink(path)
+			if err != nil {
+				t.Fatalf("CreateSink(%s) failed: %v", path, err)
+			}
+			writeFeed(t, sink, files)
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("output %s not created: %v", path, err)
+			}
+			if info.IsDir() != tt.wantDir {
+				t.Errorf("output is directory = %v, want %v", info.IsDir(), tt.wantDir)
+			}
+			got := readFeed(t, path)
+			if len(got) != len(files) {
+				t.Errorf("read %d files, want %d", len(got), len(files))
+			}
+			for name, content := range files {
+				if got[name] != content {
+					t.Errorf("%s = %q, want %q", name, got[name], content)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateZipSink_Reproducible(t *testing.T) {
+	files := map[string]string{"stops.txt": "stop_id,stop_name\nP1,Konzorcij\n"}
+	var archives [][]byte
+	for _, name := range []string{"first.zip", "second.zip"} {
+		path := filepath.Join(t.TempDir(), name)
+		sink, err := gtfsio.CreateZipSink(path)
+		if err != nil {
+			t.Fatalf("CreateZipSink(%s) failed: %v", path, err)
+		}
+		writeFeed(t, sink, files)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, content)
+		if len(archives) == 1 {
+			// Archives written at different times
+			time.Sleep(2 * time.Second)
+		}
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Error("archives of the same feed differ")
+	}
+}
+
+func TestOpenDirSource_OnlyTxtFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"stops.txt", "README.md", "agency.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("a\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested.txt"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	source, err := gtfsio.OpenDirSource(dir)
+	if err != nil {
+		t.Fatalf("OpenDirSource() failed: %v", err)
+	}
+	var names []string
+	for _, f := range source.Files() {
+		names = append(names, f.Name())
+	}
+	if want := []string{"agency.txt", "stops.txt"}; !slices.Equal(names, want) {
+		t.Errorf("Files() = %v, want %v", names, want)
+	}
+}
+
+func TestSpoolSource(t *testing.T) {
+	var buf bytes.Buffer
+	writeFeed(t, gtfsio.NewZipSink(zip.NewWriter(&buf)), map[string]string{"agency.txt": "agency_id\nA1\n"})
+
+	// Hide everything but Read, like a pipe
+	source, err := gtfsio.SpoolSource(struct{ io.Reader }{&buf})
+	if err != nil {
+		t.Fatalf("SpoolSource() failed: %v", err)
+	}
+	files := source.Files()
+	if len(files) != 1 || files[0].Name() != "agency.txt" {
+		t.Fatalf("Files() = %v, want [agency.txt]", files)
+	}
+	r, err := files[0].Open()
+	if err != nil {
+		t.Fatalf("failed to open agency.txt: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != "agency_id\nA1\n" {
+		t.Errorf("agency.txt = %q, want %q", content, "agency_id\nA1\n")
+	}
+	if err := source.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+}
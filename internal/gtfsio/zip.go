@@ -0,0 +1,146 @@
+package gtfsio
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"time"
+)
+
+type zipFile struct {
+	file *zip.File
+}
+
+func (f zipFile) Name() string {
+	return f.file.Name
+}
+
+func (f zipFile) Open() (io.ReadCloser, error) {
+	return f.file.Open()
+}
+
+type zipSource struct {
+	reader *zip.Reader
+	closer io.Closer
+}
+
+// OpenZipSource opens the zip archive at path.
+func OpenZipSource(path string) (Source, error) {
+	rc, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	return &zipSource{reader: &rc.Reader, closer: rc}, nil
+}
+
+// NewZipSource creates a Source reading from an already opened zip archive. Closing it doesn't close the reader.
+func NewZipSource(reader *zip.Reader) Source {
+	return &zipSource{reader: reader}
+}
+
+func (s *zipSource) Files() []File {
+	files := make([]File, 0, len(s.reader.File))
+	for _, f := range s.reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files = append(files, zipFile{file: f})
+	}
+	return files
+}
+
+func (s *zipSource) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// SpoolSource reads a zip archive from r, which doesn't have to support random access, like a pipe.
+// The archive is copied to a temporary file, which is removed when the Source is closed.
+func SpoolSource(r io.Reader) (Source, error) {
+	f, err := os.CreateTemp("", "gtfs-tool-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	spool := &spoolFile{f}
+	size, err := io.Copy(f, r)
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+	reader, err := zip.NewReader(f, size)
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+	return &zipSource{reader: reader, closer: spool}, nil
+}
+
+// spoolFile is a temporary file removed on close
+type spoolFile struct {
+	*os.File
+}
+
+func (f *spoolFile) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// zipModified is the modification time of every file written to a zip archive. It is fixed, so that the same feed
+// always gives the same archive. It is the earliest time the zip format can store.
+var zipModified = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type zipSink struct {
+	writer *zip.Writer
+	file   *os.File
+}
+
+// CreateZipSink creates a zip archive at path, replacing any existing file.
+func CreateZipSink(path string) (Sink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &zipSink{writer: zip.NewWriter(f), file: f}, nil
+}
+
+// NewZipSink creates a Sink writing to a zip archive. Closing the Sink closes the zip writer,
+// but not the underlying writer.
+func NewZipSink(writer *zip.Writer) Sink {
+	return &zipSink{writer: writer}
+}
+
+func (s *zipSink) Create(name string) (io.WriteCloser, error) {
+	w, err := s.writer.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: zipModified,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nopWriteCloser{w}, nil
+}
+
+func (s *zipSink) Close() error {
+	err := s.writer.Close()
+	if s.file != nil {
+		if closeErr := s.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// nopWriteCloser is a file in a zip archive, which is finished when the next one is created
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
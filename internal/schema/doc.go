@@ -0,0 +1,4 @@
+// Package schema describes the files and fields of the GTFS reference: whether they are required,
+// the type of each field, the primary key of each file and the fields that refer to other files.
+// Files and fields not in the reference are not described, and are treated as text by users of the schema.
+package schema
@@ -0,0 +1,517 @@
+package schema
+
+// Type is the type of the values of a field.
+type Type int
+
+const (
+	Text    Type = iota
+	ID           // identifier of an entity, or a reference to one
+	Integer      // including enums
+	Float
+	Date // YYYYMMDD
+	Time // HH:MM:SS, can be past 24:00:00
+)
+
+func (t Type) String() string {
+	switch t {
+	case ID:
+		return "id"
+	case Integer:
+		return "integer"
+	case Float:
+		return "float"
+	case Date:
+		return "date"
+	case Time:
+		return "time"
+	default:
+		return "text"
+	}
+}
+
+// Presence tells whether a file must be in a feed, or a field in its file.
+type Presence int
+
+const (
+	Optional Presence = iota
+	Required
+	// Required only in some cases, like agency_id of routes.txt when the feed has more than one agency
+	ConditionallyRequired
+)
+
+func (p Presence) String() string {
+	switch p {
+	case Required:
+		return "required"
+	case ConditionallyRequired:
+		return "conditionally required"
+	default:
+		return "optional"
+	}
+}
+
+// Reference is the field of another file that a field refers to.
+type Reference struct {
+	File  string
+	Field string
+}
+
+// Field is a single field of a file.
+type Field struct {
+	Name     string
+	Type     Type
+	Presence Presence
+	// nil if the field doesn't refer to a unique field of another file
+	References *Reference
+}
+
+// File is a single file of a feed.
+type File struct {
+	Name     string
+	Presence Presence
+	// Fields that together identify a row, empty if the file has no primary key
+	PrimaryKey []string
+	Fields     []Field
+}
+
+// Field returns the field with the given name.
+func (f *File) Field(name string) (Field, bool) {
+	for _, field := range f.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+// Lookup returns the file with the given name, like stops.txt.
+func Lookup(name string) (*File, bool) {
+	f, ok := filesByName[name]
+	return f, ok
+}
+
+// Files returns all files of the reference.
+func Files() []*File {
+	return files
+}
+
+var filesByName = func() map[string]*File {
+	m := make(map[string]*File, len(files))
+	for _, f := range files {
+		m[f.Name] = f
+	}
+	return m
+}()
+
+func ref(file, field string) *Reference {
+	return &Reference{File: file, Field: field}
+}
+
+var files = []*File{
+	{
+		Name:       "agency.txt",
+		Presence:   Required,
+		PrimaryKey: []string{"agency_id"},
+		Fields: []Field{
+			{Name: "agency_id", Type: ID, Presence: ConditionallyRequired},
+			{Name: "agency_name", Type: Text, Presence: Required},
+			{Name: "agency_url", Type: Text, Presence: Required},
+			{Name: "agency_timezone", Type: Text, Presence: Required},
+			{Name: "agency_lang", Type: Text},
+			{Name: "agency_phone", Type: Text},
+			{Name: "agency_fare_url", Type: Text},
+			{Name: "agency_email", Type: Text},
+			{Name: "cemv_support", Type: Integer},
+		},
+	},
+	{
+		Name:       "stops.txt",
+		Presence:   ConditionallyRequired,
+		PrimaryKey: []string{"stop_id"},
+		Fields: []Field{
+			{Name: "stop_id", Type: ID, Presence: Required},
+			{Name: "stop_code", Type: Text},
+			{Name: "stop_name", Type: Text, Presence: ConditionallyRequired},
+			{Name: "tts_stop_name", Type: Text},
+			{Name: "stop_desc", Type: Text},
+			{Name: "stop_lat", Type: Float, Presence: ConditionallyRequired},
+			{Name: "stop_lon", Type: Float, Presence: ConditionallyRequired},
+			{Name: "zone_id", Type: ID},
+			{Name: "stop_url", Type: Text},
+			{Name: "location_type", Type: Integer},
+			{Name: "parent_station", Type: ID, References: ref("stops.txt", "stop_id"), Presence: ConditionallyRequired},
+			{Name: "stop_timezone", Type: Text},
+			{Name: "wheelchair_boarding", Type: Integer},
+			{Name: "level_id", Type: ID, References: ref("levels.txt", "level_id")},
+			{Name: "platform_code", Type: Text},
+			{Name: "stop_access", Type: Integer},
+		},
+	},
+	{
+		Name:       "routes.txt",
+		Presence:   Required,
+		PrimaryKey: []string{"route_id"},
+		Fields: []Field{
+			{Name: "route_id", Type: ID, Presence: Required},
+			{Name: "agency_id", Type: ID, References: ref("agency.txt", "agency_id"), Presence: ConditionallyRequired},
+			{Name: "route_short_name", Type: Text, Presence: ConditionallyRequired},
+			{Name: "route_long_name", Type: Text, Presence: ConditionallyRequired},
+			{Name: "route_desc", Type: Text},
+			{Name: "route_type", Type: Integer, Presence: Required},
+			{Name: "route_url", Type: Text},
+			{Name: "route_color", Type: Text},
+			{Name: "route_text_color", Type: Text},
+			{Name: "route_sort_order", Type: Integer},
+			{Name: "continuous_pickup", Type: Integer},
+			{Name: "continuous_drop_off", Type: Integer},
+			{Name: "network_id", Type: ID},
+			{Name: "cemv_support", Type: Integer},
+		},
+	},
+	{
+		Name:       "trips.txt",
+		Presence:   Required,
+		PrimaryKey: []string{"trip_id"},
+		Fields: []Field{
+			{Name: "route_id", Type: ID, References: ref("routes.txt", "route_id"), Presence: Required},
+			// Refers to calendar.txt or calendar_dates.txt, neither of which it has to be unique in
+			{Name: "service_id", Type: ID, Presence: Required},
+			{Name: "trip_id", Type: ID, Presence: Required},
+			{Name: "trip_headsign", Type: Text},
+			{Name: "trip_short_name", Type: Text},
+			{Name: "direction_id", Type: Integer},
+			{Name: "block_id", Type: ID},
+			// Not unique in shapes.txt, which has a row per point
+			{Name: "shape_id", Type: ID, Presence: ConditionallyRequired},
+			{Name: "wheelchair_accessible", Type: Integer},
+			{Name: "bikes_allowed", Type: Integer},
+			{Name: "cars_allowed", Type: Integer},
+		},
+	},
+	{
+		Name:       "stop_times.txt",
+		Presence:   Required,
+		PrimaryKey: []string{"trip_id", "stop_sequence"},
+		Fields: []Field{
+			{Name: "trip_id", Type: ID, References: ref("trips.txt", "trip_id"), Presence: Required},
+			{Name: "arrival_time", Type: Time, Presence: ConditionallyRequired},
+			{Name: "departure_time", Type: Time, Presence: ConditionallyRequired},
+			{Name: "stop_id", Type: ID, References: ref("stops.txt", "stop_id"), Presence: ConditionallyRequired},
+			{Name: "location_group_id", Type: ID, References: ref("location_groups.txt", "location_group_id")},
+			{Name: "location_id", Type: ID},
+			{Name: "stop_sequence", Type: Integer, Presence: Required},
+			{Name: "stop_headsign", Type: Text},
+			{Name: "start_pickup_drop_off_window", Type: Time, Presence: ConditionallyRequired},
+			{Name: "end_pickup_drop_off_window", Type: Time, Presence: ConditionallyRequired},
+			{Name: "pickup_type", Type: Integer},
+			{Name: "drop_off_type", Type: Integer},
+			{Name: "continuous_pickup", Type: Integer},
+			{Name: "continuous_drop_off", Type: Integer},
+			{Name: "shape_dist_traveled", Type: Float},
+			{Name: "timepoint", Type: Integer},
+			{Name: "pickup_booking_rule_id", Type: ID, References: ref("booking_rules.txt", "booking_rule_id")},
+			{Name: "drop_off_booking_rule_id", Type: ID, References: ref("booking_rules.txt", "booking_rule_id")},
+		},
+	},
+	{
+		Name:       "calendar.txt",
+		Presence:   ConditionallyRequired,
+		PrimaryKey: []string{"service_id"},
+		Fields: []Field{
+			{Name: "service_id", Type: ID, Presence: Required},
+			{Name: "monday", Type: Integer, Presence: Required},
+			{Name: "tuesday", Type: Integer, Presence: Required},
+			{Name: "wednesday", Type: Integer, Presence: Required},
+			{Name: "thursday", Type: Integer, Presence: Required},
+			{Name: "friday", Type: Integer, Presence: Required},
+			{Name: "saturday", Type: Integer, Presence: Required},
+			{Name: "sunday", Type: Integer, Presence: Required},
+			{Name: "start_date", Type: Date, Presence: Required},
+			{Name: "end_date", Type: Date, Presence: Required},
+		},
+	},
+	{
+		Name:       "calendar_dates.txt",
+		Presence:   ConditionallyRequired,
+		PrimaryKey: []string{"service_id", "date"},
+		Fields: []Field{
+			{Name: "service_id", Type: ID, Presence: Required},
+			{Name: "date", Type: Date, Presence: Required},
+			{Name: "exception_type", Type: Integer, Presence: Required},
+		},
+	},
+	{
+		Name:       "fare_attributes.txt",
+		PrimaryKey: []string{"fare_id"},
+		Fields: []Field{
+			{Name: "fare_id", Type: ID, Presence: Required},
+			{Name: "price", Type: Float, Presence: Required},
+			{Name: "currency_type", Type: Text, Presence: Required},
+			{Name: "payment_method", Type: Integer, Presence: Required},
+			{Name: "transfers", Type: Integer, Presence: Required},
+			{Name: "agency_id", Type: ID, References: ref("agency.txt", "agency_id"), Presence: ConditionallyRequired},
+			{Name: "transfer_duration", Type: Integer},
+		},
+	},
+	{
+		Name: "fare_rules.txt",
+		Fields: []Field{
+			{Name: "fare_id", Type: ID, References: ref("fare_attributes.txt", "fare_id"), Presence: Required},
+			{Name: "route_id", Type: ID, References: ref("routes.txt", "route_id")},
+			{Name: "origin_id", Type: ID},
+			{Name: "destination_id", Type: ID},
+			{Name: "contains_id", Type: ID},
+		},
+	},
+	{
+		Name: "timeframes.txt",
+		Fields: []Field{
+			{Name: "timeframe_group_id", Type: ID, Presence: Required},
+			{Name: "start_time", Type: Time, Presence: ConditionallyRequired},
+			{Name: "end_time", Type: Time, Presence: ConditionallyRequired},
+			{Name: "service_id", Type: ID, Presence: Required},
+		},
+	},
+	{
+		Name:       "rider_categories.txt",
+		PrimaryKey: []string{"rider_category_id"},
+		Fields: []Field{
+			{Name: "rider_category_id", Type: ID, Presence: Required},
+			{Name: "rider_category_name", Type: Text, Presence: Required},
+			{Name: "is_default_fare_category", Type: Integer, Presence: Required},
+			{Name: "eligibility_url", Type: Text},
+		},
+	},
+	{
+		Name:       "fare_media.txt",
+		PrimaryKey: []string{"fare_media_id"},
+		Fields: []Field{
+			{Name: "fare_media_id", Type: ID, Presence: Required},
+			{Name: "fare_media_name", Type: Text},
+			{Name: "fare_media_type", Type: Integer, Presence: Required},
+		},
+	},
+	{
+		Name:       "fare_products.txt",
+		PrimaryKey: []string{"fare_product_id", "rider_category_id", "fare_media_id"},
+		Fields: []Field{
+			{Name: "fare_product_id", Type: ID, Presence: Required},
+			{Name: "fare_product_name", Type: Text},
+			{Name: "rider_category_id", Type: ID, References: ref("rider_categories.txt", "rider_category_id")},
+			{Name: "fare_media_id", Type: ID, References: ref("fare_media.txt", "fare_media_id")},
+			{Name: "amount", Type: Float, Presence: Required},
+			{Name: "currency", Type: Text, Presence: Required},
+		},
+	},
+	{
+		Name:       "fare_leg_rules.txt",
+		PrimaryKey: []string{"network_id", "from_area_id", "to_area_id", "from_timeframe_group_id", "to_timeframe_group_id", "fare_product_id"},
+		Fields: []Field{
+			{Name: "leg_group_id", Type: ID},
+			{Name: "network_id", Type: ID},
+			{Name: "from_area_id", Type: ID, References: ref("areas.txt", "area_id")},
+			{Name: "to_area_id", Type: ID, References: ref("areas.txt", "area_id")},
+			{Name: "from_timeframe_group_id", Type: ID},
+			{Name: "to_timeframe_group_id", Type: ID},
+			{Name: "fare_product_id", Type: ID, Presence: Required},
+			{Name: "rule_priority", Type: Integer},
+		},
+	},
+	{
+		Name: "fare_leg_join_rules.txt",
+		Fields: []Field{
+			{Name: "from_network_id", Type: ID, Presence: Required},
+			{Name: "to_network_id", Type: ID, Presence: Required},
+			{Name: "from_stop_id", Type: ID, References: ref("stops.txt", "stop_id"), Presence: ConditionallyRequired},
+			{Name: "to_stop_id", Type: ID, References: ref("stops.txt", "stop_id"), Presence: ConditionallyRequired},
+		},
+	},
+	{
+		Name:       "fare_transfer_rules.txt",
+		PrimaryKey: []string{"from_leg_group_id", "to_leg_group_id", "fare_product_id", "transfer_count", "duration_limit"},
+		Fields: []Field{
+			{Name: "from_leg_group_id", Type: ID},
+			{Name: "to_leg_group_id", Type: ID},
+			{Name: "transfer_count", Type: Integer, Presence: ConditionallyRequired},
+			{Name: "duration_limit", Type: Integer},
+			{Name: "duration_limit_type", Type: Integer, Presence: ConditionallyRequired},
+			{Name: "fare_transfer_type", Type: Integer, Presence: Required},
+			{Name: "fare_product_id", Type: ID},
+		},
+	},
+	{
+		Name:       "areas.txt",
+		PrimaryKey: []string{"area_id"},
+		Fields: []Field{
+			{Name: "area_id", Type: ID, Presence: Required},
+			{Name: "area_name", Type: Text},
+		},
+	},
+	{
+		Name: "stop_areas.txt",
+		Fields: []Field{
+			{Name: "area_id", Type: ID, References: ref("areas.txt", "area_id"), Presence: Required},
+			{Name: "stop_id", Type: ID, References: ref("stops.txt", "stop_id"), Presence: Required},
+		},
+	},
+	{
+		Name:       "networks.txt",
+		Presence:   ConditionallyRequired,
+		PrimaryKey: []string{"network_id"},
+		Fields: []Field{
+			{Name: "network_id", Type: ID, Presence: Required},
+			{Name: "network_name", Type: Text},
+		},
+	},
+	{
+		Name:       "route_networks.txt",
+		Presence:   ConditionallyRequired,
+		PrimaryKey: []string{"route_id"},
+		Fields: []Field{
+			{Name: "network_id", Type: ID, References: ref("networks.txt", "network_id"), Presence: Required},
+			{Name: "route_id", Type: ID, References: ref("routes.txt", "route_id"), Presence: Required},
+		},
+	},
+	{
+		Name:       "shapes.txt",
+		PrimaryKey: []string{"shape_id", "shape_pt_sequence"},
+		Fields: []Field{
+			{Name: "shape_id", Type: ID, Presence: Required},
+			{Name: "shape_pt_lat", Type: Float, Presence: Required},
+			{Name: "shape_pt_lon", Type: Float, Presence: Required},
+			{Name: "shape_pt_sequence", Type: Integer, Presence: Required},
+			{Name: "shape_dist_traveled", Type: Float},
+		},
+	},
+	{
+		Name:       "frequencies.txt",
+		PrimaryKey: []string{"trip_id", "start_time"},
+		Fields: []Field{
+			{Name: "trip_id", Type: ID, References: ref("trips.txt", "trip_id"), Presence: Required},
+			{Name: "start_time", Type: Time, Presence: Required},
+			{Name: "end_time", Type: Time, Presence: Required},
+			{Name: "headway_secs", Type: Integer, Presence: Required},
+			{Name: "exact_times", Type: Integer},
+		},
+	},
+	{
+		Name: "transfers.txt",
+		Fields: []Field{
+			{Name: "from_stop_id", Type: ID, References: ref("stops.txt", "stop_id"), Presence: ConditionallyRequired},
+			{Name: "to_stop_id", Type: ID, References: ref("stops.txt", "stop_id"), Presence: ConditionallyRequired},
+			{Name: "from_route_id", Type: ID, References: ref("routes.txt", "route_id")},
+			{Name: "to_route_id", Type: ID, References: ref("routes.txt", "route_id")},
+			{Name: "from_trip_id", Type: ID, References: ref("trips.txt", "trip_id"), Presence: ConditionallyRequired},
+			{Name: "to_trip_id", Type: ID, References: ref("trips.txt", "trip_id"), Presence: ConditionallyRequired},
+			{Name: "transfer_type", Type: Integer, Presence: Required},
+			{Name: "min_transfer_time", Type: Integer},
+		},
+	},
+	{
+		Name:       "pathways.txt",
+		PrimaryKey: []string{"pathway_id"},
+		Fields: []Field{
+			{Name: "pathway_id", Type: ID, Presence: Required},
+			{Name: "from_stop_id", Type: ID, References: ref("stops.txt", "stop_id"), Presence: Required},
+			{Name: "to_stop_id", Type: ID, References: ref("stops.txt", "stop_id"), Presence: Required},
+			{Name: "pathway_mode", Type: Integer, Presence: Required},
+			{Name: "is_bidirectional", Type: Integer, Presence: Required},
+			{Name: "length", Type: Float},
+			{Name: "traversal_time", Type: Integer},
+			{Name: "stair_count", Type: Integer},
+			{Name: "max_slope", Type: Float},
+			{Name: "min_width", Type: Float},
+			{Name: "signposted_as", Type: Text},
+			{Name: "reversed_signposted_as", Type: Text},
+		},
+	},
+	{
+		Name:       "levels.txt",
+		Presence:   ConditionallyRequired,
+		PrimaryKey: []string{"level_id"},
+		Fields: []Field{
+			{Name: "level_id", Type: ID, Presence: Required},
+			{Name: "level_index", Type: Float, Presence: Required},
+			{Name: "level_name", Type: Text},
+		},
+	},
+	{
+		Name:       "location_groups.txt",
+		PrimaryKey: []string{"location_group_id"},
+		Fields: []Field{
+			{Name: "location_group_id", Type: ID, Presence: Required},
+			{Name: "location_group_name", Type: Text},
+		},
+	},
+	{
+		Name: "location_group_stops.txt",
+		Fields: []Field{
+			{Name: "location_group_id", Type: ID, References: ref("location_groups.txt", "location_group_id"), Presence: Required},
+			{Name: "stop_id", Type: ID, References: ref("stops.txt", "stop_id"), Presence: Required},
+		},
+	},
+	{
+		Name:       "booking_rules.txt",
+		PrimaryKey: []string{"booking_rule_id"},
+		Fields: []Field{
+			{Name: "booking_rule_id", Type: ID, Presence: Required},
+			{Name: "booking_type", Type: Integer, Presence: Required},
+			{Name: "prior_notice_duration_min", Type: Integer, Presence: ConditionallyRequired},
+			{Name: "prior_notice_duration_max", Type: Integer},
+			{Name: "prior_notice_last_day", Type: Integer, Presence: ConditionallyRequired},
+			{Name: "prior_notice_last_time", Type: Time, Presence: ConditionallyRequired},
+			{Name: "prior_notice_start_day", Type: Integer},
+			{Name: "prior_notice_start_time", Type: Time},
+			{Name: "prior_notice_service_id", Type: ID},
+			{Name: "message", Type: Text},
+			{Name: "pickup_message", Type: Text},
+			{Name: "drop_off_message", Type: Text},
+			{Name: "phone_number", Type: Text},
+			{Name: "info_url", Type: Text},
+			{Name: "booking_url", Type: Text},
+		},
+	},
+	{
+		Name: "translations.txt",
+		Fields: []Field{
+			{Name: "table_name", Type: Text, Presence: Required},
+			{Name: "field_name", Type: Text, Presence: Required},
+			{Name: "language", Type: Text, Presence: Required},
+			{Name: "translation", Type: Text, Presence: Required},
+			{Name: "record_id", Type: ID, Presence: ConditionallyRequired},
+			{Name: "record_sub_id", Type: ID, Presence: ConditionallyRequired},
+			{Name: "field_value", Type: Text, Presence: ConditionallyRequired},
+		},
+	},
+	{
+		Name:     "feed_info.txt",
+		Presence: ConditionallyRequired,
+		Fields: []Field{
+			{Name: "feed_publisher_name", Type: Text, Presence: Required},
+			{Name: "feed_publisher_url", Type: Text, Presence: Required},
+			{Name: "feed_lang", Type: Text, Presence: Required},
+			{Name: "default_lang", Type: Text},
+			{Name: "feed_start_date", Type: Date},
+			{Name: "feed_end_date", Type: Date},
+			{Name: "feed_version", Type: Text},
+			{Name: "feed_contact_email", Type: Text},
+			{Name: "feed_contact_url", Type: Text},
+		},
+	},
+	{
+		Name: "attributions.txt",
+		Fields: []Field{
+			{Name: "attribution_id", Type: ID},
+			{Name: "agency_id", Type: ID, References: ref("agency.txt", "agency_id")},
+			{Name: "route_id", Type: ID, References: ref("routes.txt", "route_id")},
+			{Name: "trip_id", Type: ID, References: ref("trips.txt", "trip_id")},
+			{Name: "organization_name", Type: Text, Presence: Required},
+			{Name: "is_producer", Type: Integer},
+			{Name: "is_operator", Type: Integer},
+			{Name: "is_authority", Type: Integer},
+			{Name: "attribution_url", Type: Text},
+			{Name: "attribution_email", Type: Text},
+			{Name: "attribution_phone", Type: Text},
+		},
+	},
+}
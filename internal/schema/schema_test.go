@@ -0,0 +1,46 @@
+package schema_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/InternatManhole/dujpp-gtfs-tool/internal/schema"
+)
+
+// References must point to a field that identifies rows of the other file, so they can be foreign keys.
+func TestReferences(t *testing.T) {
+	for _, file := range schema.Files() {
+		for _, key := range file.PrimaryKey {
+			if _, ok := file.Field(key); !ok {
+				t.Errorf("%s: primary key field %s is not a field of the file", file.Name, key)
+			}
+		}
+		for _, field := range file.Fields {
+			if field.References == nil {
+				continue
+			}
+			target, ok := schema.Lookup(field.References.File)
+			if !ok {
+				t.Errorf("%s.%s refers to unknown file %s", file.Name, field.Name, field.References.File)
+				continue
+			}
+			if !slices.Equal(target.PrimaryKey, []string{field.References.Field}) {
+				t.Errorf("%s.%s refers to %s.%s, which is not the primary key %v",
+					file.Name, field.Name, target.Name, field.References.Field, target.PrimaryKey)
+			}
+		}
+	}
+}
+
+// A single field primary key identifies rows, so it can't be optional. Fields of composite keys can,
+// like the fields of fare_leg_rules.txt.
+func TestPrimaryKeyPresence(t *testing.T) {
+	for _, file := range schema.Files() {
+		for _, key := range file.PrimaryKey {
+			field, _ := file.Field(key)
+			if field.Presence == schema.Optional && len(file.PrimaryKey) == 1 {
+				t.Errorf("%s: primary key field %s is optional", file.Name, key)
+			}
+		}
+	}
+}